@@ -1,8 +1,9 @@
 package main
 
 import (
-	_ "errors" // debug
+	"context"
 	"log"
+	"strings"
 )
 
 type ChannelIdRequest struct {
@@ -15,11 +16,34 @@ type ChannelNameRequest struct {
 	Reply chan string
 }
 
-type PutChannelInfo struct {
-	Id   string
-	Name string
+// ChannelInfo is a conversation in the channel list.
+type ChannelInfo struct {
+	Id       string
+	Name     string // the name shown, see conversationName, which is provisionalName until it's resolved
+	UserId   string // the other user, if this is a DM
+	Private  bool   // whether only members may see the conversation. Private conversations are only listed while the user is a member.
+	Member   bool
+	Archived bool
+	Deleted  bool
+}
+
+// listed returns whether the conversation is in the channel list: every public channel, and the private
+// conversations the user is a member of, unless they were deleted.
+func (c ChannelInfo) listed() bool {
+	return (!c.Private || c.Member) && !c.Deleted
+}
+
+// ChannelChange changes the conversation with the given id, e.g. from an RTM channel_rename event.
+type ChannelChange struct {
+	Id     string
+	Change func(channel *ChannelInfo)
 }
 
+type ChannelListRequest struct {
+	Reply chan<- []ChannelInfo
+}
+
+// GetChannelId returns the id of the conversation with the given name, or the empty string if there's none.
 func GetChannelId(name string, getChannelIdChan chan<- ChannelIdRequest) string {
 	replyChan := make(chan string)
 	getChannelIdChan <- ChannelIdRequest{name, replyChan}
@@ -32,55 +56,198 @@ func GetChannelName(id string, getChannelNameChan chan<- ChannelNameRequest) str
 	return <-replyChan
 }
 
-// TODO(remove, and write to chan directly?)
-func PutChannelId(name, id string, putChannelIdChan chan<- PutChannelInfo) {
-	putChannelIdChan <- PutChannelInfo{name, id}
+// GetChannels returns the conversations in the channel list, in the order they were added.
+func GetChannels(getChannelsChan chan<- ChannelListRequest) []ChannelInfo {
+	replyChan := make(chan []ChannelInfo)
+	getChannelsChan <- ChannelListRequest{replyChan}
+	return <-replyChan
+}
+
+// conversationName returns the name shown for the given conversation. Channels use their name,
+// DMs the other user's name, and multi-person DMs the names of their members.
+func conversationName(ctx context.Context, client *SlackClient, channel SlackChannel, getUserNameChan chan<- UserNameRequest) string {
+	switch {
+	case channel.IsIm:
+		return "@" + GetUserName(channel.User, getUserNameChan)
+	case channel.IsMpim:
+		members, err := client.GetSlackChannelMembers(ctx, channel.Id)
+		if err != nil {
+			log.Println("conversationName error getting members of " + channel.Id + ": " + err.Error())
+			return channel.Name
+		}
+		names := make([]string, 0, len(members))
+		for _, member := range members {
+			names = append(names, GetUserName(member, getUserNameChan))
+		}
+		return strings.Join(names, ",")
+	default:
+		return channel.Name
+	}
+}
+
+// provisionalName returns the name shown for the given conversation until conversationName returns, which for DMs
+// and multi-person DMs asks the user manager, and Slack. DMs show the other user's id, and multi-person DMs the name
+// Slack gives them.
+func provisionalName(channel SlackChannel) string {
+	if channel.IsIm {
+		return "@" + channel.User
+	}
+	return channel.Name
 }
 
-// channelIdManager acts like a CSP map, with put and get operations via channels
-// TODO(add terminate channel, to safely shut down the manager)
-// TODO(load group and personal channels)
-func channelIdManager(token string, put <-chan PutChannelInfo, get <-chan ChannelIdRequest, getName <-chan ChannelNameRequest) {
+// channelName is the name of a conversation, resolved by conversationName outside the channel manager's loop, or the
+// error getting the conversation.
+type channelName struct {
+	Id   string
+	Name string
+	Err  error
+}
+
+// slackChannelToChannelInfo returns the channel list entry of the given conversation, with its provisionalName.
+func slackChannelToChannelInfo(channel SlackChannel) ChannelInfo {
+	return ChannelInfo{
+		Id:       channel.Id,
+		Name:     provisionalName(channel),
+		UserId:   channel.User,
+		Private:  channel.IsPrivate || channel.IsGroup || channel.IsIm || channel.IsMpim,
+		Member:   channel.IsMember || channel.IsIm || channel.IsMpim,
+		Archived: channel.IsArchived,
+	}
+}
+
+// channelIdManager acts like a CSP map, with put and get operations via channels. It also keeps the channel list,
+// of the conversations put, as changed. It returns when ctx is done.
+// Names which need requests, of DMs, multi-person DMs, and conversations which weren't put, are resolved in
+// goroutines, so the requests don't block the manager. The ids of conversations in the list whose names are resolved
+// are written to changed, if it isn't nil.
+func channelIdManager(ctx context.Context, client *SlackClient, getUserNameChan chan<- UserNameRequest, changed chan<- string, put <-chan SlackChannel, change <-chan ChannelChange, get <-chan ChannelIdRequest, getName <-chan ChannelNameRequest, getList <-chan ChannelListRequest) {
 	// TODO(create name and id types?)
 	channels := make(map[string]string)     // map[name]id
 	channelNames := make(map[string]string) // map[id]name
+	infos := make(map[string]ChannelInfo)   // map[id]info, of the conversations put
+	var list []string                       // the ids of the listed conversations, in the order they were put
+	named := make(chan channelName)
+	waiting := make(map[string][]chan string) // map[id]replies, of the getName requests of names being resolved
+
+	// setInfo stores the info, replacing the conversation's old name, and adds it to or removes it from the list.
+	// Deleted conversations keep their id's name, for their messages, but their name no longer has an id.
+	setInfo := func(info ChannelInfo) {
+		if old, ok := infos[info.Id]; ok && (old.Name != info.Name || info.Deleted) && channels[old.Name] == info.Id {
+			delete(channels, old.Name)
+		}
+		infos[info.Id] = info
+		if !info.Deleted {
+			channels[info.Name] = info.Id
+		}
+		channelNames[info.Id] = info.Name
+		i := indexOf(list, info.Id)
+		switch {
+		case info.listed() && i < 0:
+			list = append(list, info.Id)
+		case !info.listed() && i >= 0:
+			list = append(list[:i:i], list[i+1:]...)
+		}
+	}
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case p := <-put:
-			channels[p.Name] = p.Id
-			channelNames[p.Id] = p.Name
-		case g := <-get:
-			id, ok := channels[g.Name]
+			setInfo(slackChannelToChannelInfo(p))
+			if p.IsIm || p.IsMpim {
+				go func() {
+					n := channelName{Id: p.Id, Name: conversationName(ctx, client, p, getUserNameChan)}
+					select {
+					case named <- n:
+					case <-ctx.Done():
+						return
+					}
+					if changed == nil {
+						return
+					}
+					select {
+					case changed <- p.Id:
+					case <-ctx.Done():
+					}
+				}()
+			}
+		case n := <-named:
+			if info, ok := infos[n.Id]; ok && n.Err == nil {
+				info.Name = n.Name
+				setInfo(info)
+			} else if n.Err == nil {
+				channels[n.Name] = n.Id
+				channelNames[n.Id] = n.Name
+			} else if SlackErrorCode(n.Err) == "channel_not_found" {
+				// cache, so repeated messages from inaccessible channels don't each make a request
+				channelNames[n.Id] = ""
+			}
+			for _, reply := range waiting[n.Id] {
+				reply <- channelNames[n.Id]
+			}
+			delete(waiting, n.Id)
+		case c := <-change:
+			info, ok := infos[c.Id]
 			if !ok {
-				g.Reply <- "not found" // debug
+				log.Println("channelIdManager change for unknown channel " + c.Id)
 				continue
-				// Shouldn't be possible to have an name without an id.
-				// If it was, we could iterate names via channels.list
-				//				log.Panicln(errors.New("channel id requested for name that doesn't exist")) // debug
 			}
-			g.Reply <- id
+			c.Change(&info)
+			setInfo(info)
+		case l := <-getList:
+			infoList := make([]ChannelInfo, len(list))
+			for i, id := range list {
+				infoList[i] = infos[id]
+			}
+			l.Reply <- infoList
+		case g := <-get:
+			g.Reply <- channels[g.Name]
 		case gn := <-getName:
-			name, ok := channelNames[gn.Id]
-			if !ok {
-				newName, err := GetSlackChannel(token, gn.Id)
-				if err != nil {
-					log.Println("channelIdManager error getting channel: " + err.Error())
-					gn.Reply <- "" // TODO(fix to get group and private channels, and resume panicing)
-					continue
-				}
-				name = newName.Name
-				channels[name] = gn.Id
-				channelNames[gn.Id] = name
+			if name, ok := channelNames[gn.Id]; ok {
+				gn.Reply <- name
+				continue
 			}
-			gn.Reply <- name
+			if _, ok := waiting[gn.Id]; !ok {
+				go func(id string) {
+					n := channelName{Id: id}
+					channel, err := client.GetSlackChannel(ctx, id)
+					if err != nil {
+						log.Println("channelIdManager error getting channel " + id + ": " + err.Error())
+						n.Err = err
+					} else {
+						n.Name = conversationName(ctx, client, channel, getUserNameChan)
+					}
+					select {
+					case named <- n:
+					case <-ctx.Done():
+					}
+				}(gn.Id)
+			}
+			waiting[gn.Id] = append(waiting[gn.Id], gn.Reply)
+		}
+	}
+}
+
+// indexOf returns the index of s in strs, or -1 if it isn't there.
+func indexOf(strs []string, s string) int {
+	for i, str := range strs {
+		if str == s {
+			return i
 		}
 	}
+	return -1
 }
 
-func StartChannelIdManager(token string) (chan<- PutChannelInfo, chan<- ChannelIdRequest, chan<- ChannelNameRequest) {
-	putChan := make(chan PutChannelInfo)
+// StartChannelIdManager starts the channel manager, and returns chans to put and change conversations, get ids by
+// name and names by id, and get the channel list. The ids of conversations in the list whose names were resolved
+// after they were put are written to channelsChanged, if it isn't nil.
+func StartChannelIdManager(ctx context.Context, client *SlackClient, getUserNameChan chan<- UserNameRequest, channelsChanged chan<- string) (chan<- SlackChannel, chan<- ChannelChange, chan<- ChannelIdRequest, chan<- ChannelNameRequest, chan<- ChannelListRequest) {
+	putChan := make(chan SlackChannel)
+	changeChan := make(chan ChannelChange)
 	getChan := make(chan ChannelIdRequest)
 	getNameChan := make(chan ChannelNameRequest)
-	go channelIdManager(token, putChan, getChan, getNameChan)
-	return putChan, getChan, getNameChan
+	getListChan := make(chan ChannelListRequest)
+	go channelIdManager(ctx, client, getUserNameChan, channelsChanged, putChan, changeChan, getChan, getNameChan, getListChan)
+	return putChan, changeChan, getChan, getNameChan, getListChan
 }
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestChannelManagerResolvesNames(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general", IsChannel: true})
+	s.AddChannel(slacktest.Channel{Id: "D1", IsIm: true, User: "U1"})
+	s.AddChannel(slacktest.Channel{Id: "D2", IsIm: true, User: "U2"})
+	s.AddChannel(slacktest.Channel{Id: "M1", Name: "mpdm-alice--bob-1", IsMpim: true, Members: []string{"U1", "U2"}})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(slacktest.User{Id: "U2", Name: "bob"})
+	client := testClient(t, s)
+
+	// conversations.members blocks until released, as if Slack were slow
+	release := make(chan struct{})
+	defer close(release)
+	members := make(chan struct{}, 1)
+	s.Handle("conversations.members", func(w http.ResponseWriter, params url.Values) {
+		members <- struct{}{}
+		<-release
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": true, "members": []string{"U1", "U2"}})
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getUser, _, _, _, _ := StartUserManager(ctx, client)
+	changed := make(chan string, 10)
+	put, _, getId, getName, getList := StartChannelIdManager(ctx, client, getUser, changed)
+	channels, err := client.GetSlackChannels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range channels {
+		if c.Id != "D2" {
+			put <- c
+		}
+	}
+	<-members
+
+	// the manager answers while the multi-person DM's members are requested
+	if id := GetChannelId("general", getId); id != "C1" {
+		t.Fatalf("expected C1, got %q", id)
+	}
+	if list := GetChannels(getList); len(list) != 3 || list[2].Name != "mpdm-alice--bob-1" {
+		t.Fatalf("expected the multi-person DM's provisional name, got %v", list)
+	}
+	if id := <-changed; id != "D1" {
+		t.Fatalf("expected the DM's name resolved, got %s", id)
+	}
+	if id := GetChannelId("@alice", getId); id != "D1" {
+		t.Fatalf("expected D1 for @alice, got %q", id)
+	}
+
+	release <- struct{}{}
+	if id := <-changed; id != "M1" {
+		t.Fatalf("expected the multi-person DM's name resolved, got %s", id)
+	}
+	if list := GetChannels(getList); list[2].Name != "alice,bob" || GetChannelId("mpdm-alice--bob-1", getId) != "" {
+		t.Fatalf("expected the provisional name replaced, got %v", list)
+	}
+
+	// names of conversations which weren't put are got from Slack, once
+	if name := GetChannelName("D2", getName); name != "@bob" {
+		t.Fatalf("expected @bob, got %q", name)
+	}
+	s.Handle("conversations.info", func(w http.ResponseWriter, params url.Values) {
+		t.Error("conversations.info called for a known name")
+	})
+	if name := GetChannelName("D2", getName); name != "@bob" {
+		t.Fatalf("expected @bob, got %q", name)
+	}
+	select {
+	case id := <-changed:
+		t.Fatalf("expected no change for a conversation which isn't listed, got %s", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestChannelManagerUnknownChannel(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getUser, _, _, _, _ := StartUserManager(ctx, client)
+	_, _, _, getName, _ := StartChannelIdManager(ctx, client, getUser, nil)
+
+	// concurrent requests for the same unknown channel make one request, and the failure is cached
+	infos := 0
+	s.Handle("conversations.info", func(w http.ResponseWriter, params url.Values) {
+		infos++
+		time.Sleep(20 * time.Millisecond)
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+	})
+	names := make(chan string)
+	for i := 0; i < 3; i++ {
+		go func() { names <- GetChannelName("C9", getName) }()
+	}
+	for i := 0; i < 3; i++ {
+		if name := <-names; name != "" {
+			t.Fatalf("expected no name, got %q", name)
+		}
+	}
+	if name := GetChannelName("C9", getName); name != "" || infos != 1 {
+		t.Fatalf("expected one conversations.info, got %d, and %q", infos, name)
+	}
+}
+
+func TestConversationNames(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general", IsChannel: true})
+	s.AddChannel(slacktest.Channel{Id: "G1", Name: "secret", IsGroup: true, IsPrivate: true})
+	s.AddChannel(slacktest.Channel{Id: "D1", IsIm: true, User: "U1"})
+	s.AddChannel(slacktest.Channel{Id: "M1", Name: "mpdm-alice--bob-1", IsMpim: true, Members: []string{"U1", "U2"}})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(slacktest.User{Id: "U2", Name: "bob"})
+	s.AddMessage("G1", slacktest.Message{User: "U1", Text: "psst"})
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	getUser, _, _, _, _ := StartUserManager(ctx, client)
+	channels, err := client.GetSlackChannels(ctx)
+	if err != nil || len(channels) != 4 {
+		t.Fatalf("expected every type of conversation, got %v %v", channels, err)
+	}
+	var names []string
+	for _, c := range channels {
+		names = append(names, conversationName(ctx, client, c, getUser))
+	}
+	if names[0] != "general" || names[1] != "secret" || names[2] != "@alice" || names[3] != "alice,bob" {
+		t.Fatalf("expected channel names, @user for DMs, and members for multi-person DMs, got %v", names)
+	}
+
+	// private channels' history is got with conversations.history too
+	getMsgs, _, _, _, _, _, _ := StartMessagesManager(ctx, client, getUser)
+	if msgs, err := GetMessages("G1", getMsgs); err != nil || len(msgs) != 1 {
+		t.Fatalf("expected the private channel's message, got %v %v", msgs, err)
+	}
+}
+
+func TestChannelListUpdates(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general", IsMember: true, Members: []string{"U0SELF"}})
+	s.AddChannel(slacktest.Channel{Id: "C2", Name: "random"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(s.Self)
+	changed := make(chan string, 10)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{ChannelsChanged: changed})
+	go func() {
+		for range pipe.updateMsgs {
+		}
+	}()
+	channels, err := pipe.client.GetSlackChannels(pipe.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range channels {
+		pipe.putChannel <- c
+	}
+	waitConns(t, s)
+
+	// waitList waits for the event changing the list, then for the list, whose names are resolved in the background
+	waitList := func(want string) {
+		t.Helper()
+		<-changed
+		list := ""
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			list = ""
+			for _, c := range GetChannels(pipe.getChannels) {
+				list += c.Name
+				if c.Archived {
+					list += "(archived)"
+				}
+				list += " "
+			}
+			if list == want {
+				return
+			}
+		}
+		t.Fatalf("expected the channels %q, got %q", want, list)
+	}
+
+	if _, err := pipe.client.JoinSlackChannel(pipe.ctx, "C2"); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random ")
+	c, err := pipe.client.CreateSlackChannel(pipe.ctx, "newthing", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-changed // channel_created, then channel_joined
+	waitList("general random newthing ")
+	if err := s.RenameChannel(c.Id, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed ")
+	if GetChannelId("newthing", pipe.getChannelId) != "" || GetChannelId("renamed", pipe.getChannelId) != c.Id || GetChannelName(c.Id, pipe.getChannelName) != "renamed" {
+		t.Fatal("expected the channel's name and id replaced")
+	}
+	if err := pipe.client.ArchiveSlackChannel(pipe.ctx, c.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed(archived) ")
+	if err := s.UnarchiveChannel(c.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed ")
+
+	// left, and deleted, conversations are removed
+	if err := pipe.client.LeaveSlackChannel(pipe.ctx, "C2"); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed ") // public channels stay listed, to be joined again
+	private, err := pipe.client.CreateSlackChannel(pipe.ctx, "priv", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed priv ")
+	if err := pipe.client.LeaveSlackChannel(pipe.ctx, private.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general random renamed ")
+	if err := s.DeleteChannel("C2"); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general renamed ")
+	if GetChannelId("random", pipe.getChannelId) != "" || GetChannelName("C2", pipe.getChannelName) != "random" {
+		t.Fatal("expected the deleted channel's name kept for its id only")
+	}
+
+	// a new DM is named after its user
+	if err := s.CreateChannel(slacktest.Channel{Id: "D1", IsIm: true, User: "U1"}); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general renamed @alice ")
+	if list := GetChannels(pipe.getChannels); list[2].UserId != "U1" {
+		t.Fatalf("expected the DM's user, got %v", list)
+	}
+	if err := s.CreateChannel(slacktest.Channel{Id: "G2", Name: "hidden", IsPrivate: true, IsMember: true}); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general renamed @alice hidden ")
+	if err := s.DeleteChannel("G2"); err != nil {
+		t.Fatal(err)
+	}
+	waitList("general renamed @alice ")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"github.com/jroimartin/gocui"
+	"log"
+	"strings"
+)
+
+// commandEnv is what input commands may use. Like guiState, it must only be used from the GUI goroutine.
+type commandEnv struct {
+	ctx    context.Context
+	client *SlackClient
+	chans  GuiChans
+	config GuiConfig
+	state  *guiState
+}
+
+// inputCommand runs a slash command typed into the input. args is the text after the command name.
+// Commands which call Slack must do so in a new goroutine, and report the result with setStatus, so the GUI doesn't hang.
+type inputCommand func(g *gocui.Gui, env commandEnv, args string) error
+
+const (
+	reactCommand   = "/react"
+	unreactCommand = "/unreact"
+	deleteCommand  = "/delete"
+)
+
+var inputCommands = map[string]inputCommand{
+	reactCommand:    react,
+	unreactCommand:  unreact,
+	deleteCommand:   deleteSelected,
+	uploadCommand:   uploadFile,
+	snippetCommand:  startSnippet,
+	downloadCommand: downloadFiles,
+	viewCommand:     viewFile,
+	searchCommand:   search,
+	pinsCommand:     showPins,
+	pinCommand:      pin,
+	unpinCommand:    unpin,
+	awayCommand:     setAway,
+	autoCommand:     setAuto,
+	statusCommand:   setUserStatus,
+	joinCommand:     joinChannel,
+	leaveCommand:    leaveChannel,
+	createCommand:   createChannel,
+	archiveCommand:  archiveChannel,
+	topicCommand:    setTopic,
+	purposeCommand:  setPurpose,
+	inviteCommand:   inviteUsers,
+	kickCommand:     kickUser,
+}
+
+// runCommand runs the input command text, e.g. "/react thumbsup".
+func runCommand(g *gocui.Gui, env commandEnv, text string) error {
+	name, args := text, ""
+	if i := strings.Index(text, " "); i >= 0 {
+		name, args = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	command, ok := inputCommands[name]
+	if !ok {
+		setStatus(g, "Unknown command "+name)
+		return nil
+	}
+	log.Println("runCommand " + name + " " + args)
+	return command(g, env, args)
+}
+
+// reactionName returns the Slack name of the emoji typed as name, which may be surrounded by colons, e.g. :+1:.
+func reactionName(name string) string {
+	return strings.Trim(strings.TrimSpace(name), ":")
+}
+
+func react(g *gocui.Gui, env commandEnv, args string) error {
+	return changeReaction(g, env, args, true)
+}
+
+func unreact(g *gocui.Gui, env commandEnv, args string) error {
+	return changeReaction(g, env, args, false)
+}
+
+// changeReaction adds or removes the named reaction on the selected message.
+// The message itself is updated when Slack sends the reaction_added or reaction_removed RTM event.
+func changeReaction(g *gocui.Gui, env commandEnv, args string, add bool) error {
+	name := reactionName(args)
+	if name == "" {
+		setStatus(g, "Usage: "+reactCommand+" emoji, or "+unreactCommand+" emoji")
+		return nil
+	}
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		setStatus(g, "Select a message in the messages view first")
+		return nil
+	}
+	channelId := env.state.channelId
+	go func() {
+		var err error
+		if add {
+			err = env.client.AddSlackReaction(env.ctx, channelId, msg.Time, name)
+		} else {
+			err = env.client.RemoveSlackReaction(env.ctx, channelId, msg.Time, name)
+		}
+		if err != nil {
+			log.Println("error changing reaction " + name + " on " + channelId + " " + msg.Time + ": " + err.Error())
+			setStatus(g, reactionErrorText(name, err))
+		}
+	}()
+	return nil
+}
+
+func reactionErrorText(name string, err error) string {
+	switch SlackErrorCode(err) {
+	case "already_reacted":
+		return "You already reacted with :" + name + ":"
+	case "no_reaction":
+		return "You haven't reacted with :" + name + ":"
+	case "invalid_name":
+		return "Unknown emoji :" + name + ":"
+	case "too_many_reactions", "too_many_emoji":
+		return "That message has too many reactions"
+	default:
+		return "Error changing reaction: " + err.Error()
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// JoinSlackChannel joins the public channel, and returns it.
+func (c *SlackClient) JoinSlackChannel(ctx context.Context, channelId string) (SlackChannel, error) {
+	var response SlackChannelRequest
+	if err := c.apiPost(ctx, `conversations.join`, url.Values{"channel": {channelId}}, &response); err != nil {
+		return SlackChannel{}, err
+	}
+	return response.Channel, nil
+}
+
+// LeaveSlackChannel leaves the conversation.
+func (c *SlackClient) LeaveSlackChannel(ctx context.Context, channelId string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.leave`, url.Values{"channel": {channelId}}, &response)
+}
+
+// CreateSlackChannel creates a public or private channel, which the token's user is a member of, and returns it.
+func (c *SlackClient) CreateSlackChannel(ctx context.Context, name string, private bool) (SlackChannel, error) {
+	params := url.Values{"name": {name}}
+	if private {
+		params.Set("is_private", "true")
+	}
+	var response SlackChannelRequest
+	if err := c.apiPost(ctx, `conversations.create`, params, &response); err != nil {
+		return SlackChannel{}, err
+	}
+	return response.Channel, nil
+}
+
+// ArchiveSlackChannel archives the channel.
+func (c *SlackClient) ArchiveSlackChannel(ctx context.Context, channelId string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.archive`, url.Values{"channel": {channelId}}, &response)
+}
+
+// SetSlackChannelTopic sets the conversation's topic.
+func (c *SlackClient) SetSlackChannelTopic(ctx context.Context, channelId, topic string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.setTopic`, url.Values{"channel": {channelId}, "topic": {topic}}, &response)
+}
+
+// SetSlackChannelPurpose sets the conversation's purpose.
+func (c *SlackClient) SetSlackChannelPurpose(ctx context.Context, channelId, purpose string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.setPurpose`, url.Values{"channel": {channelId}, "purpose": {purpose}}, &response)
+}
+
+// InviteToSlackChannel invites the users to the channel.
+func (c *SlackClient) InviteToSlackChannel(ctx context.Context, channelId string, userIds []string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.invite`, url.Values{"channel": {channelId}, "users": {strings.Join(userIds, ",")}}, &response)
+}
+
+// KickFromSlackChannel removes the user from the channel.
+func (c *SlackClient) KickFromSlackChannel(ctx context.Context, channelId, userId string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `conversations.kick`, url.Values{"channel": {channelId}, "user": {userId}}, &response)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestChannelAdmin(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general", IsMember: true, Members: []string{"U0SELF"}})
+	s.AddChannel(slacktest.Channel{Id: "C2", Name: "random"})
+	s.AddChannel(slacktest.Channel{Id: "G1", Name: "secret", IsPrivate: true})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(s.Self)
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if c, err := client.JoinSlackChannel(ctx, "C2"); err != nil || !c.IsMember {
+		t.Fatalf("expected random joined, got %v %v", c, err)
+	}
+	if _, err := client.JoinSlackChannel(ctx, "G1"); SlackErrorCode(err) != "channel_not_found" {
+		t.Fatalf("expected channel_not_found for a private channel, got %v", err)
+	}
+	if err := client.LeaveSlackChannel(ctx, "C2"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.CreateSlackChannel(ctx, "newthing", false)
+	if err != nil || c.Name != "newthing" || !c.IsMember {
+		t.Fatalf("expected newthing created, got %v %v", c, err)
+	}
+	if _, err := client.CreateSlackChannel(ctx, "newthing", false); SlackErrorCode(err) != "name_taken" {
+		t.Fatalf("expected name_taken, got %v", err)
+	}
+	_, err = client.CreateSlackChannel(ctx, "Bad Name", false)
+	if text := channelErrorText("create", err); text != "Channel names must be lowercase, without spaces or periods, and at most 80 characters" {
+		t.Fatalf("expected the naming rules, got %q", text)
+	}
+	if err := client.ArchiveSlackChannel(ctx, c.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ArchiveSlackChannel(ctx, c.Id); SlackErrorCode(err) != "already_archived" {
+		t.Fatalf("expected already_archived, got %v", err)
+	}
+
+	if err := client.SetSlackChannelTopic(ctx, "C1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetSlackChannelPurpose(ctx, "C1", "stuff"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := client.GetSlackChannel(ctx, "C1"); err != nil || info.Topic.Value != "hello" || info.Purpose.Value != "stuff" {
+		t.Fatalf("expected the topic and purpose set, got %v %v", info, err)
+	}
+	if err := client.InviteToSlackChannel(ctx, "C1", []string{"U1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.InviteToSlackChannel(ctx, "C1", []string{"U1"}); SlackErrorCode(err) != "already_in_channel" {
+		t.Fatalf("expected already_in_channel, got %v", err)
+	}
+	if err := client.KickFromSlackChannel(ctx, "C1", "U1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.KickFromSlackChannel(ctx, "C1", "U0SELF"); SlackErrorCode(err) != "cant_kick_self" {
+		t.Fatalf("expected cant_kick_self, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SlackUpload is a file to upload to a conversation.
+type SlackUpload struct {
+	ChannelId   string
+	ThreadTs    string // the thread to share the file in, if any
+	Filename    string
+	Title       string // optional, defaults to the Filename
+	Comment     string // optional message shared with the file
+	SnippetType string // optional, e.g. text or go, to upload the file as a snippet
+	Content     io.Reader
+	Size        int64
+	Progress    func(sent int64) // optional, called as the content is uploaded
+}
+
+type slackUploadUrl struct {
+	Ok        bool   `json:"ok"`
+	UploadUrl string `json:"upload_url"`
+	FileId    string `json:"file_id"`
+}
+
+type slackCompletedUpload struct {
+	Ok    bool        `json:"ok"`
+	Files []SlackFile `json:"files"`
+}
+
+// progressReader calls progress with the total bytes read so far, after each read.
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	progress func(sent int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	if n > 0 && p.progress != nil {
+		p.progress(p.sent)
+	}
+	return n, err
+}
+
+// UploadSlackFile uploads a file and shares it in the upload's conversation, using Slack's external upload flow:
+// files.getUploadURLExternal, then a POST of the content to the returned URL, then files.completeUploadExternal.
+// The file_share message arrives over RTM like any other message.
+// The content upload isn't subject to the client's Timeout, because large files may take longer. It's still cancelled with ctx.
+// Nor is it scheduled by the RequestScheduler: the upload URL isn't a Web API method, so Slack's tiers don't limit it,
+// and the content is streamed, so it couldn't be sent again after a 429. The API calls before and after it are scheduled.
+func (c *SlackClient) UploadSlackFile(ctx context.Context, upload SlackUpload) (SlackFile, error) {
+	params := url.Values{"filename": {upload.Filename}, "length": {strconv.FormatInt(upload.Size, 10)}}
+	if upload.SnippetType != "" {
+		params.Set("snippet_type", upload.SnippetType)
+	}
+	var uploadUrl slackUploadUrl
+	if err := c.apiPost(ctx, `files.getUploadURLExternal`, params, &uploadUrl); err != nil {
+		return SlackFile{}, err
+	}
+
+	// the upload URL is pre-authorized, so the token isn't sent to it
+	request, err := http.NewRequest(http.MethodPost, uploadUrl.UploadUrl, &progressReader{r: upload.Content, progress: upload.Progress})
+	if err != nil {
+		return SlackFile{}, sanitizeError(err)
+	}
+	request.ContentLength = upload.Size
+	request.Header.Set("Content-Type", "application/octet-stream")
+	response, err := c.HttpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return SlackFile{}, sanitizeError(err)
+	}
+	_, err = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return SlackFile{}, sanitizeError(err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return SlackFile{}, &ApiError{Method: "upload", Code: "upload_failed", HttpStatus: response.StatusCode}
+	}
+
+	title := upload.Title
+	if title == "" {
+		title = upload.Filename
+	}
+	files, err := json.Marshal([]map[string]string{{"id": uploadUrl.FileId, "title": title}})
+	if err != nil {
+		return SlackFile{}, err
+	}
+	params = url.Values{"files": {string(files)}, "channel_id": {upload.ChannelId}}
+	if upload.Comment != "" {
+		params.Set("initial_comment", upload.Comment)
+	}
+	if upload.ThreadTs != "" {
+		params.Set("thread_ts", upload.ThreadTs)
+	}
+	var completed slackCompletedUpload
+	if err := c.apiPost(ctx, `files.completeUploadExternal`, params, &completed); err != nil {
+		return SlackFile{}, err
+	}
+	if len(completed.Files) == 0 {
+		return SlackFile{Id: uploadUrl.FileId, Title: title}, nil
+	}
+	return completed.Files[0], nil
+}
+
+// sendsTokenTo returns whether the token may be sent to fileUrl: whether it's Slack's, or the client's API server's.
+// Files are served from Slack's domain, e.g. files.slack.com, but links in messages may point anywhere.
+func (c *SlackClient) sendsTokenTo(fileUrl *url.URL) bool {
+	if apiUrl, err := url.Parse(c.BaseUrl); err == nil && apiUrl.Host == fileUrl.Host {
+		return true
+	}
+	host := fileUrl.Hostname()
+	return fileUrl.Scheme == "https" && (host == "slack.com" || strings.HasSuffix(host, ".slack.com"))
+}
+
+// DownloadSlackFile writes the content of the file's url_private_download, or url_private, to w, and returns its size.
+// Like UploadSlackFile's content upload, it isn't subject to the client's Timeout, or scheduled.
+func (c *SlackClient) DownloadSlackFile(ctx context.Context, file SlackFile, w io.Writer) (int64, error) {
+	fileUrl := file.UrlPrivateDownload
+	if fileUrl == "" {
+		fileUrl = file.UrlPrivate
+	}
+	if fileUrl == "" {
+		return 0, errors.New("file " + file.Id + " has no URL")
+	}
+	request, err := http.NewRequest(http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return 0, sanitizeError(err)
+	}
+	if !c.sendsTokenTo(request.URL) {
+		return 0, errors.New("not sending the Slack token to " + request.URL.Host)
+	}
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+	response, err := c.HttpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return 0, sanitizeError(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, &ApiError{Method: "download", Code: "download_failed", HttpStatus: response.StatusCode}
+	}
+	n, err := io.Copy(w, response.Body)
+	return n, sanitizeError(err)
+}
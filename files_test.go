@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestUpload(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U0SELF", Name: "me"})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	if _, err := GetMessages("C1", pipe.getMsgs); err != nil {
+		t.Fatal(err)
+	}
+	waitConns(t, s)
+
+	content := strings.Repeat("x", 1000)
+	var sent int64
+	file, err := pipe.client.UploadSlackFile(pipe.ctx, SlackUpload{ChannelId: "C1", Filename: "a.log", Comment: "see", Content: strings.NewReader(content), Size: 1000, Progress: func(n int64) { sent = n }})
+	if err != nil || sent != 1000 || file.Title != "a.log" {
+		t.Fatalf("expected the upload titled with its name, and its progress reported, got %v %d %v", file, sent, err)
+	}
+	if _, uploaded, _ := s.File(file.Id); string(uploaded) != content {
+		t.Fatalf("expected the content uploaded, got %d bytes", len(uploaded))
+	}
+	<-pipe.updateMsgs
+	if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 1 || msgs[0].Files[0].Id != file.Id || msgs[0].Text != "see" {
+		t.Fatalf("expected the file shared with its comment, got %v", msgs)
+	}
+	if id, err := findChannelId(pipe.ctx, pipe.client, "#general"); err != nil || id != "C1" {
+		t.Fatalf("expected C1 for #general, got %q %v", id, err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U0SELF", Name: "me"})
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	file, err := client.UploadSlackFile(ctx, SlackUpload{ChannelId: "C1", Filename: "../../a.txt", SnippetType: "text", Content: strings.NewReader("hello"), Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := client.DownloadSlackFile(ctx, file, &buf); err != nil || buf.String() != "hello" {
+		t.Fatalf("expected hello, got %q %v", buf.String(), err)
+	}
+	if _, err := client.DownloadSlackFile(ctx, file, &limitedBuffer{limit: 3}); err != errTooLarge {
+		t.Fatalf("expected the file too large to view, got %v", err)
+	}
+	elsewhere := file
+	elsewhere.UrlPrivateDownload = "https://example.com/a.txt"
+	if _, err := client.DownloadSlackFile(ctx, elsewhere, &buf); err == nil || !strings.Contains(err.Error(), "not sending") {
+		t.Fatalf("expected the token not sent to another host, got %v", err)
+	}
+
+	getUser, _, _, _, _ := StartUserManager(ctx, client)
+	getMsgs, _, _, _, _, _, _ := StartMessagesManager(ctx, client, getUser)
+	msgs, _ := GetMessages("C1", getMsgs)
+	if len(msgs) != 1 || !isTextFile(msgs[0].Files[0].SlackFile) {
+		t.Fatalf("expected the shared text file, got %v", msgs)
+	}
+	if text := fileInfoText(msgs[0].Files[0]); text != "file: ../../a.txt 5 B text/plain by me" {
+		t.Fatalf("expected the file's info, got %q", text)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/jroimartin/gocui"
 	"hash/fnv"
@@ -8,28 +9,80 @@ import (
 	"log"
 	"math"
 	"strings"
+	"time"
 )
 
+// GuiChans are the chans the GUI uses to talk to the managers and the RTM handler.
+type GuiChans struct {
+	PutChannel        chan<- SlackChannel
+	ChangeChannel     chan<- ChannelChange
+	GetChannelId      chan<- ChannelIdRequest
+	GetChannelName    chan<- ChannelNameRequest
+	GetChannels       chan<- ChannelListRequest
+	GetUserName       chan<- UserNameRequest
+	GetUserId         chan<- UserIdRequest
+	GetUserStatus     chan<- UserStatusRequest
+	GetMessages       chan<- MessageRequest
+	GetThread         chan<- ThreadRequest
+	UpdateMsgs        <-chan string
+	PinsChanged       <-chan string
+	UsersChanged      <-chan []string
+	ChannelsChanged   <-chan string
+	RtmStatus         <-chan RtmStatus
+	UserTyping        <-chan SlackRtmUserTyping
+	SubscribePresence chan<- []string // the users whose presence is shown, i.e. those with DMs and the token's user
+	SendMsg           chan<- PutRtmMsg
+	RetryMsg          chan<- OutboxRetry
+	Typing            chan<- string // the ids of channels the user is typing a message in
+	Throttled         <-chan ThrottleInfo
+}
+
+// guiState is the state of the GUI's views. It must only be accessed from the GUI goroutine,
+// i.e. in the layout, keybinding handlers, and funcs passed to g.Execute.
+type guiState struct {
+	channelId  string    // the channel whose messages are shown
+	msgLines   []TermMsg // the message on each line of the messages view, or the empty TermMsg for blank lines
+	thread     *openThread
+	contextTs  string                   // the ts of the message, e.g. a search result, whose surrounding messages are shown, instead of the newest
+	editing    *editingMessage          // the message being edited in the input, if any
+	snippet    *snippet                 // the snippet being written in the input, if any
+	viewer     *fileViewer              // the file shown over the messages, if any
+	search     *searchResults           // the search results shown over the messages, if any
+	pins       *pinnedMessages          // the pinned messages shown over the messages, if any
+	channels   []ChannelInfo            // the conversation on each line of the channels view
+	presence   []string                 // the users whose presence is subscribed to
+	rtm        RtmStatus                // the state of the RTM connection, shown in the channels view title
+	typing     map[typingKey]typingUser // the users shown typing in each channel
+	msgsLoad   int                      // incremented by each populateMessages, so only the newest load is rendered
+	threadLoad int                      // incremented by each populateThread, so only the newest load is rendered
+}
+
+// openThread is the thread shown in the thread view.
+type openThread struct {
+	ChannelId string
+	ThreadTs  string
+}
+
+// GuiConfig is the configuration of the GUI.
+type GuiConfig struct {
+	SelfId      string // the id of the token's user, whose messages may be edited
+	DownloadDir string // the directory files are downloaded to
+}
+
 // EnterTheGui creates the GUI and enters a loop. This function does not return
-// until the user sends the kill signal C-c
+// until the user sends the kill signal C-c, or ctx is done.
 // TODO(make start a goroutine and return with a channel to kill it)
-func EnterTheGui(slackToken string,
-	putChannelIdChan chan<- PutChannelInfo,
-	getChannelIdChan chan<- ChannelIdRequest,
-	getUserNameChan chan<- UserNameRequest,
-	getMessagesChan chan<- MessageRequest,
-	getChannelNameChan chan<- ChannelNameRequest,
-	updateMsgsChan <-chan string,
-	sendMsgChan chan<- PutRtmMsg) {
-
+func EnterTheGui(ctx context.Context, client *SlackClient, config GuiConfig, chans GuiChans) {
 	g := gocui.NewGui()
 	if err := g.Init(); err != nil {
 		log.Panicln(err)
 	}
 	defer g.Close()
 
-	g.SetLayout(layout)
-	layout(g) // draw once, to create views
+	state := &guiState{}
+	layoutState := func(g *gocui.Gui) error { return layout(g, state) }
+	g.SetLayout(layoutState)
+	layoutState(g) // draw once, to create views
 
 	// if err := g.Flush(); err != nil {
 	// 	log.Panicln(err)
@@ -39,25 +92,30 @@ func EnterTheGui(slackToken string,
 		log.Panicln(err)
 	}
 
-	if err := populateChannels(g, slackToken, putChannelIdChan); err != nil {
+	env := commandEnv{ctx: ctx, client: client, config: config, chans: chans, state: state}
+	g.Editor = typingEditor(env)
+	if err := populateChannels(g, env); err != nil {
 		log.Panicln(err)
 	}
 
-	setKeybindings(g, slackToken, getChannelIdChan, getUserNameChan, getMessagesChan, sendMsgChan)
+	setKeybindings(g, env)
 
-	go guiUpdater(g, updateMsgsChan, getUserNameChan, getChannelNameChan, getMessagesChan, slackToken)
+	go guiUpdater(g, env)
 
 	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
 		log.Panicln(err)
 	}
 }
 
-func layout(g *gocui.Gui) error {
+func layout(g *gocui.Gui, state *guiState) error {
 	maxX, maxY := g.Size()
 	maxY = maxY - 1
 
 	const channelsWidth = 30
-	const inputHeight = 1
+	inputHeight := 1
+	if state.snippet != nil {
+		inputHeight = snippetHeight
+	}
 	const messageNamesWidth = 20 // TODO(dynamically get widest name?)
 
 	// the -1 everywhere is subtracting borders
@@ -75,7 +133,7 @@ func layout(g *gocui.Gui) error {
 	}
 
 	//	messagesWidth := maxX - channelsWidth
-	if v, err := g.SetView("messages-names", channelsWidth, 0, channelsWidth+messageNamesWidth, maxY-inputHeight); err != nil {
+	if v, err := g.SetView("messages-names", channelsWidth, 0, channelsWidth+messageNamesWidth, maxY-inputHeight-2); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
@@ -85,13 +143,44 @@ func layout(g *gocui.Gui) error {
 		v.Frame = false
 	}
 
-	if v, err := g.SetView("messages", channelsWidth+messageNamesWidth, 0, maxX-1, maxY-inputHeight); err != nil {
+	messagesX1 := maxX - 1
+	if state.thread != nil {
+		messagesX1 = maxX - (maxX-channelsWidth)*threadWidthPercent/100
+		if err := layoutThread(g, messagesX1+1, 0, maxX-1, maxY-inputHeight); err != nil {
+			return err
+		}
+	} else if err := g.DeleteView("thread"); err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+
+	if v, err := g.SetView("messages", channelsWidth+messageNamesWidth, 0, messagesX1, maxY-inputHeight-2); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = false
+		v.SelFgColor = gocui.AttrReverse
+		v.SelBgColor = gocui.AttrReverse
+	}
+
+	// the line under the messages, above the input, showing who is typing. Its view is frameless, so its one line is
+	// the row between its y0 and y1, under the messages' last row.
+	if v, err := g.SetView("typing", channelsWidth, maxY-inputHeight-3, messagesX1, maxY-inputHeight-1); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
 		v.Frame = false
 	}
 
+	if err := layoutSearch(g, state, channelsWidth, 0, maxX-1, maxY-inputHeight); err != nil {
+		return err
+	}
+	if err := layoutPins(g, state, channelsWidth, 0, maxX-1, maxY-inputHeight); err != nil {
+		return err
+	}
+	if err := layoutFileViewer(g, state, channelsWidth, 0, maxX-1, maxY-inputHeight); err != nil {
+		return err
+	}
+
 	if v, err := g.SetView("input", 0, maxY-inputHeight-1, maxX-1, maxY); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
@@ -110,28 +199,95 @@ func quit(g *gocui.Gui, v *gocui.View) error {
 	return gocui.ErrQuit
 }
 
-func populateChannels(g *gocui.Gui, token string, putChannelIdChan chan<- PutChannelInfo) error {
-	channelsView, err := g.View("channels")
+// populateChannels gets the conversations, writes them to the channels view, and subscribes to the presence of the
+// users shown.
+func populateChannels(g *gocui.Gui, env commandEnv) error {
+	slackChannels, err := env.client.GetSlackChannels(env.ctx)
 	if err != nil {
 		return err
 	}
 
-	slackChannels, err := GetSlackChannels(token)
-	if err != nil {
-		return err
+	for _, channel := range slackChannels {
+		env.chans.PutChannel <- channel
 	}
+	return refreshChannels(g, env)
+}
 
-	for _, channel := range slackChannels {
-		putChannelIdChan <- PutChannelInfo{channel.Id, channel.Name}
+// refreshChannels gets the channel list from the channel manager, and redraws the channels view, keeping the
+// selected conversation selected. If DMs were added, the presence of their users is subscribed to.
+func refreshChannels(g *gocui.Gui, env commandEnv) error {
+	state := env.state
+	selected, wasSelected := selectedChannel(g, state)
+	state.channels = GetChannels(env.chans.GetChannels)
+	subscribePresence(env)
+	if err := renderChannels(g, env.chans, state); err != nil {
+		return err
+	}
+	if !wasSelected || selectChannelLine(g, state, selected.Id) || len(state.channels) == 0 {
+		return nil
+	}
+	if _, ok := selectedChannel(g, state); !ok { // the selected conversation was the last, and was removed
+		selectChannelLine(g, state, state.channels[len(state.channels)-1].Id)
 	}
+	return nil
+}
 
-	for _, channel := range slackChannels {
-		fmt.Fprintln(channelsView, channel.Name)
+// subscribePresence subscribes to the presence of the token's user, and the users of the DMs in the channel list,
+// if they changed since the last subscription.
+func subscribePresence(env commandEnv) {
+	userIds := []string{env.config.SelfId}
+	for _, channel := range env.state.channels {
+		if channel.UserId != "" {
+			userIds = append(userIds, channel.UserId)
+		}
+	}
+	if strings.Join(userIds, ",") == strings.Join(env.state.presence, ",") {
+		return
 	}
+	env.state.presence = userIds
+	go func() { // the RTM handler may not have connected yet
+		select {
+		case env.chans.SubscribePresence <- userIds:
+		case <-env.ctx.Done():
+		}
+	}()
+}
 
+// renderChannels writes the conversations to the channels view, with the presence and status of DM users.
+// Archived channels are faint.
+func renderChannels(g *gocui.Gui, chans GuiChans, state *guiState) error {
+	v, err := g.View("channels")
+	if err != nil {
+		return err
+	}
+	v.Clear()
+	for _, channel := range state.channels {
+		line := channel.Name
+		if channel.Archived {
+			line = faint(line)
+		}
+		if channel.UserId != "" {
+			line += userStatusText(GetUserStatus(channel.UserId, chans.GetUserStatus), true)
+		}
+		fmt.Fprintln(v, line)
+	}
 	return nil
 }
 
+// selectedChannel returns the conversation under the cursor of the channels view, and false if there's none there.
+func selectedChannel(g *gocui.Gui, state *guiState) (ChannelInfo, bool) {
+	v, err := g.View("channels")
+	if err != nil {
+		return ChannelInfo{}, false
+	}
+	_, oy := v.Origin()
+	_, cy := v.Cursor()
+	if i := oy + cy; i >= 0 && i < len(state.channels) {
+		return state.channels[i], true
+	}
+	return ChannelInfo{}, false
+}
+
 func cursorDown(g *gocui.Gui, v *gocui.View) error {
 	if v == nil {
 		return nil
@@ -169,55 +325,219 @@ func cursorUp(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
-func selectChannel(g *gocui.Gui, v *gocui.View, token string, getChannelIdChan chan<- ChannelIdRequest, getUserNameChan chan<- UserNameRequest, getMessagesChan chan<- MessageRequest) error {
+func selectChannel(g *gocui.Gui, v *gocui.View, chans GuiChans, state *guiState) error {
 	log.Println("selectChannel called")
-	_, cy := v.Cursor()
-	channel, err := v.Line(cy)
-	if err != nil {
-		log.Println("selectChannel returning err")
-		return err
+	channel, ok := selectedChannel(g, state)
+	if !ok {
+		return nil
 	}
-	log.Println("selectChannel calling getChannelId with " + channel)
-	channelId := GetChannelId(channel, getChannelIdChan)
-	log.Println("selectChannel calling populateMessages")
-	err = populateMessages(g, token, channelId, getUserNameChan, getMessagesChan)
+	log.Println("selectChannel calling populateMessages with " + channel.Name)
+	err := populateMessages(g, channel.Id, chans, state)
 	log.Println("selectChannel returning")
 	return err
 }
 
-// TODO(make asynchronous, so the GUI doesn't hang)
-func populateMessages(g *gocui.Gui,
-	token string,
-	channelId string,
-	getUserNameChan chan<- UserNameRequest,
-	getMessagesChan chan<- MessageRequest) error {
-	log.Println("populateMessages called")
-	v, err := g.View("messages")
-	if err != nil {
-		return err
+// consistentHashColorName colors each name with a consistent, unique color
+func consistentHashColorName(name string) string {
+	h := fnv.New32a()
+	io.WriteString(h, name)
+	hNum := (h.Sum32() % 7) + 1
+	return fmt.Sprintf("\033[1;3%dm%s\033[0m", hNum, name)
+}
+
+// faint returns s in the color of secondary text, like system messages and reply counts. It's a color, because gocui
+// ignores the faint attribute.
+func faint(s string) string {
+	return "\033[34m" + s + "\033[0m"
+}
+
+// systemSubtypes are the message subtypes which are events, rather than something a user said, and are shown faint.
+var systemSubtypes = map[string]bool{
+	"channel_join":      true,
+	"channel_leave":     true,
+	"channel_topic":     true,
+	"channel_purpose":   true,
+	"channel_name":      true,
+	"channel_archive":   true,
+	"channel_unarchive": true,
+	"group_join":        true,
+	"group_leave":       true,
+	"group_topic":       true,
+	"group_purpose":     true,
+	"group_name":        true,
+	"group_archive":     true,
+	"group_unarchive":   true,
+	"pinned_item":       true,
+	"unpinned_item":     true,
+	"tombstone":         true,
+}
+
+// displayName returns the name shown for the sender of msg, prefixed with the bot's icon if it has one.
+func displayName(msg TermMsg) string {
+	if msg.Icon != "" {
+		return ":" + msg.Icon + ": " + msg.UserName
 	}
-	vn, err := g.View("messages-names")
-	if err != nil {
-		return err
+	return msg.UserName
+}
+
+// fileName returns the name shown for a shared file.
+func fileName(file SlackFile) string {
+	if file.Title != "" {
+		return file.Title
+	}
+	if file.Name != "" {
+		return file.Name
 	}
+	return file.Id
+}
 
-	v.Clear()
-	vn.Clear()
-	fmt.Fprintln(v, "Loading Messages...")
-	//	g.Flush()
+// messageBody returns the text of msg with its files, faint if it's a system message or being sent, and marked if it
+// was edited or sending it failed.
+func messageBody(msg TermMsg) string {
+	body := strings.TrimRight(msg.Text, " \n\t")
+	for _, file := range msg.Files {
+		if body != "" {
+			body += " "
+		}
+		body += faint("[" + fileInfoText(file) + "]")
+	}
+	if systemSubtypes[msg.Subtype] {
+		body = faint(ansiEscape.ReplaceAllString(body, ""))
+	}
+	if msg.Edited {
+		body += " " + faint("(edited)")
+	}
+	switch {
+	case msg.Sending:
+		body = faint(ansiEscape.ReplaceAllString(body, "") + " (sending)")
+	case msg.SendError != "":
+		body += " \033[31m[not sent: " + msg.SendError + ". r retries, d discards]\033[0m"
+	}
+	return body
+}
+
+// messageText returns the text of msg to print on one line, with a reply count if it's a thread parent, and whether
+// it's pinned or starred.
+func messageText(msg TermMsg) string {
+	// For now, strip newlines, to work with the dumb logic printing the number of messages as the screen height
+	msgtxt := strings.Replace(messageBody(msg), "\n", "", -1) // TODO(print newlines [which requires accounting for them when getting the number of lines to print])
+	switch {
+	case msg.ReplyCount == 1:
+		msgtxt += " " + faint("[1 reply]")
+	case msg.ReplyCount > 1:
+		msgtxt += " " + faint(fmt.Sprintf("[%d replies]", msg.ReplyCount))
+	}
+	if msg.Pinned {
+		msgtxt += " " + faint("[pinned]")
+	}
+	if msg.Starred {
+		msgtxt += " " + faint("[starred]")
+	}
+	return msgtxt
+}
+
+// reactionsText returns the compact line of reactions shown under a message, e.g. ":+1: 3  :eyes: 1", or the empty string if it has none.
+func reactionsText(reactions []SlackReaction) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	texts := make([]string, len(reactions))
+	for i, r := range reactions {
+		texts[i] = fmt.Sprintf(":%s: %d", r.Name, r.Count)
+	}
+	return faint(strings.Join(texts, "  "))
+}
 
-	msgs := GetMessages(channelId, getMessagesChan)
+// messageLines returns the lines msg is printed on: its text, and its reactions if it has any.
+func messageLines(msg TermMsg) []string {
+	lines := []string{messageText(msg)}
+	if reactions := reactionsText(msg.Reactions); reactions != "" {
+		lines = append(lines, reactions)
+	}
+	return lines
+}
 
+// populateMessages shows the channel's messages in the messages view. They're got in the background, because the
+// first time a channel is shown its history is got from Slack, which may take a while, and are rendered when they're
+// got, unless another channel, a search result's context, or a newer load of the channel was shown meanwhile.
+func populateMessages(g *gocui.Gui, channelId string, chans GuiChans, state *guiState) error {
+	log.Println("populateMessages called")
+	if channelId != state.channelId || state.contextTs != "" {
+		v, err := g.View("messages")
+		if err != nil {
+			return err
+		}
+		vn, err := g.View("messages-names")
+		if err != nil {
+			return err
+		}
+		v.Clear()
+		vn.Clear()
+		state.channelId = channelId
+		state.contextTs = ""
+		state.msgLines = nil
+		fmt.Fprintln(v, "Loading Messages...")
+		if err := renderTyping(g, state); err != nil {
+			return err
+		}
+	}
+	state.msgsLoad++
+	load := state.msgsLoad
+	go func() {
+		msgs, err := GetMessages(channelId, chans.GetMessages)
+		g.Execute(func(g *gocui.Gui) error {
+			if load != state.msgsLoad || state.channelId != channelId || state.contextTs != "" {
+				return nil
+			}
+			v, verr := g.View("messages")
+			if verr != nil {
+				return verr
+			}
+			vn, verr := g.View("messages-names")
+			if verr != nil {
+				return verr
+			}
+			if err != nil {
+				v.Clear()
+				vn.Clear()
+				state.msgLines = nil
+				fmt.Fprintln(v, messagesErrorText(err))
+				return nil
+			}
+			renderMessages(v, vn, msgs, chans, state)
+			log.Println("populateMessages rendered " + channelId)
+			return nil
+		})
+	}()
+	return nil
+}
+
+// renderMessages writes msgs, newest first, to the messages view v and names view vn, with the newest at the bottom,
+// as many as fit. Names are shown with their user's presence and status emoji.
+func renderMessages(v *gocui.View, vn *gocui.View, msgs []TermMsg, chans GuiChans, state *guiState) {
+	state.msgLines = nil
 	_, vHeight := v.Size()
-	lastMsgsI := int(math.Max(math.Min(float64(vHeight-1), float64(len(msgs)-1)), 0))
-	msgs = msgs[:lastMsgsI]
+	maxLines := int(math.Max(float64(vHeight-1), 0))
+
+	// msgs are newest first, so take messages until the screen is full, and print them in reverse
+	var shown []TermMsg
+	numLines := 0
+	for _, msg := range msgs {
+		n := len(messageLines(msg))
+		if numLines+n > maxLines {
+			break
+		}
+		shown = append(shown, msg)
+		numLines += n
+	}
 	v.Clear()
 	vn.Clear()
 
-	blankHeight := vHeight - len(msgs)
+	blankHeight := vHeight - numLines
 	for i := 0; i < blankHeight; i++ {
 		fmt.Fprintln(v, "")
 		fmt.Fprintln(vn, "")
+		state.msgLines = append(state.msgLines, TermMsg{})
 	}
 
 	padName := func(name string, width int) string {
@@ -225,40 +545,123 @@ func populateMessages(g *gocui.Gui,
 			return name
 		}
 
-		for len(name) < width {
-			name = " " + name
+		if n := width - textWidth(name); n > 0 {
+			name = strings.Repeat(" ", n) + name
 		}
 		return name
 	}
 
-	// consistentHashColorName colors each name with a consistent, unique color
-	consistentHashColorName := func(name string) string {
-		h := fnv.New32a()
-		io.WriteString(h, name)
-		hNum := (h.Sum32() % 7) + 1
-		return fmt.Sprintf("\033[1;3%dm%s\033[0m", hNum, name)
-		return name
-	}
-
 	vnWidth, _ := vn.Size()
 
-	for i := len(msgs) - 1; i >= 0; i-- {
-		// For now, strip newlines, to work with the dumb logic printing the number of messages as the screen height
-		msg := msgs[i]
-		msgtxt := strings.Replace(strings.TrimRight(msg.Text, " \n\t"), "\n", "", -1) // TODO(print newlines [which requires accounting for them when getting the number of lines to print])
-		fmt.Fprintln(vn, consistentHashColorName(padName(msg.UserName, vnWidth)))
-		fmt.Fprintln(v, msgtxt)
+	for i := len(shown) - 1; i >= 0; i-- {
+		msg := shown[i]
+		for j, line := range messageLines(msg) {
+			if j == 0 {
+				name := consistentHashColorName(displayName(msg))
+				if msg.UserId != "" {
+					name += userStatusText(GetUserStatus(msg.UserId, chans.GetUserStatus), false)
+				}
+				fmt.Fprintln(vn, padName(name, vnWidth))
+			} else {
+				fmt.Fprintln(vn, "")
+			}
+			fmt.Fprintln(v, line)
+			state.msgLines = append(state.msgLines, msg)
+		}
 		//		g.Flush()
 	}
 
 	// // debug
 	// //	fmt.Fprintln(v, channelId)
 	//	g.Flush()
-	log.Println("populateMessages returning")
+}
+
+// isBlankLine returns whether the messages view line of msg is blank, rather than part of a message.
+func isBlankLine(msg TermMsg) bool {
+	return msg.Time == "" && msg.OutboxId == 0
+}
+
+// sameMessage returns whether the messages view lines of a and b are the same message. Unacked messages have no ts,
+// so they're identified by their outbox id.
+func sameMessage(a, b TermMsg) bool {
+	return a.Time == b.Time && a.OutboxId == b.OutboxId
+}
+
+// selectedLine returns the message under the cursor of the messages view, which may be unacked, and false if there's
+// no message there.
+func selectedLine(g *gocui.Gui, state *guiState) (TermMsg, bool) {
+	v, err := g.View("messages")
+	if err != nil {
+		return TermMsg{}, false
+	}
+	_, cy := v.Cursor()
+	if cy < 0 || cy >= len(state.msgLines) || isBlankLine(state.msgLines[cy]) {
+		return TermMsg{}, false
+	}
+	return state.msgLines[cy], true
+}
+
+// selectedMessage returns the message under the cursor of the messages view, and false if there's no message there,
+// or it's unacked, and so can't be changed in Slack.
+func selectedMessage(g *gocui.Gui, state *guiState) (TermMsg, bool) {
+	msg, ok := selectedLine(g, state)
+	if !ok || msg.Time == "" {
+		return TermMsg{}, false
+	}
+	return msg, true
+}
+
+// messagesCursorDown moves the messages view cursor to the first line of the next message.
+func messagesCursorDown(g *gocui.Gui, v *gocui.View, state *guiState) error {
+	cx, cy := v.Cursor()
+	if cy < 0 || cy >= len(state.msgLines) {
+		return nil
+	}
+	for y := cy + 1; y < len(state.msgLines); y++ {
+		if !sameMessage(state.msgLines[y], state.msgLines[cy]) {
+			return v.SetCursor(cx, y)
+		}
+	}
 	return nil
 }
 
-func nextView(g *gocui.Gui, v *gocui.View) error {
+// messagesCursorUp moves the messages view cursor to the first line of the previous message, skipping the blank lines above the oldest message.
+func messagesCursorUp(g *gocui.Gui, v *gocui.View, state *guiState) error {
+	cx, cy := v.Cursor()
+	if cy <= 0 || cy > len(state.msgLines) {
+		return nil
+	}
+	y := cy - 1
+	for y >= 0 && cy < len(state.msgLines) && sameMessage(state.msgLines[y], state.msgLines[cy]) {
+		y-- // skip the rest of the current message
+	}
+	if y < 0 || isBlankLine(state.msgLines[y]) {
+		return nil
+	}
+	for y > 0 && sameMessage(state.msgLines[y-1], state.msgLines[y]) {
+		y--
+	}
+	return v.SetCursor(cx, y)
+}
+
+// messagesErrorText returns the text shown in place of messages which couldn't be loaded.
+func messagesErrorText(err error) string {
+	switch SlackErrorCode(err) {
+	case "not_in_channel":
+		return "You are not a member of this channel."
+	case "channel_not_found":
+		return "Channel not found. It may have been deleted, or you may not have access."
+	case "missing_scope":
+		return "Your Slack token doesn't have permission to read this channel."
+	case "ratelimited":
+		return "Rate limited by Slack. Select the channel again to retry."
+	default:
+		return "Error loading messages: " + err.Error()
+	}
+}
+
+// nextView cycles the current view from the channels, to the input, to the messages.
+func nextView(g *gocui.Gui, v *gocui.View, state *guiState) error {
 	g.Cursor = false
 	v.Highlight = false
 	log.Println("nextView: " + v.Name())
@@ -268,6 +671,17 @@ func nextView(g *gocui.Gui, v *gocui.View) error {
 		err = g.SetCurrentView("input")
 		g.Cursor = true
 	case "input":
+		err = g.SetCurrentView("messages")
+		g.CurrentView().Highlight = true
+		// start at the newest message
+		if _, cy := g.CurrentView().Cursor(); cy < len(state.msgLines) && isBlankLine(state.msgLines[cy]) && len(state.msgLines) > 0 {
+			y := len(state.msgLines) - 1
+			for y > 0 && sameMessage(state.msgLines[y-1], state.msgLines[y]) {
+				y--
+			}
+			g.CurrentView().SetCursor(0, y)
+		}
+	case "messages":
 		err = g.SetCurrentView("channels")
 		g.CurrentView().Highlight = true
 	}
@@ -275,26 +689,69 @@ func nextView(g *gocui.Gui, v *gocui.View) error {
 	return err
 }
 
+// broadcastCommand prefixes a thread reply which is also sent to the channel.
+const broadcastCommand = "/broadcast "
+
 // TODO(strip newlines only at cursor position)
-func inputEnter(g *gocui.Gui, v *gocui.View, getChannelIdChan chan<- ChannelIdRequest, sendMsgChan chan<- PutRtmMsg) error {
+func inputEnter(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	chans, state := env.chans, env.state
 	text := strings.Replace(strings.TrimRight(v.Buffer(), " \n\t"), "\n", "", -1)
 	log.Println("Entered Text: X" + text + "X")
 
-	channelName, err := getSelectedChannelName(g)
-	if err != nil {
-		return err
+	if state.snippet != nil {
+		return nil // Enter adds a line to the snippet, which the editor already did
+	}
+	if state.editing != nil {
+		return saveEdit(g, env, text)
 	}
-	channelId := GetChannelId(channelName, getChannelIdChan)
 
-	sendMsgChan <- PutRtmMsg{channelId, text}
+	if strings.HasPrefix(text, "/") && !(state.thread != nil && strings.HasPrefix(text, broadcastCommand)) {
+		clearInput(v)
+		return runCommand(g, env, text)
+	}
 
+	channel, ok := selectedChannel(g, state)
+	if !ok && state.thread == nil {
+		setStatus(g, "Select a channel first")
+		return nil
+	}
+
+	msg := PutRtmMsg{ChannelId: channel.Id, Msg: text}
+	if state.thread != nil {
+		msg.ChannelId = state.thread.ChannelId
+		msg.ThreadTs = state.thread.ThreadTs
+		if strings.HasPrefix(text, broadcastCommand) {
+			msg.Msg = strings.TrimPrefix(text, broadcastCommand)
+			msg.Broadcast = true
+		}
+	}
+	chans.SendMsg <- msg
+
+	clearInput(v)
+	return nil
+}
+
+func clearInput(v *gocui.View) {
 	v.Clear()
 	v.SetCursor(0, 0)
 	v.SetOrigin(0, 0)
-	return nil
 }
 
-func setKeybindings(g *gocui.Gui, token string, getChannelIdChan chan<- ChannelIdRequest, getUserNameChan chan<- UserNameRequest, getMessagesChan chan<- MessageRequest, sendMsgChan chan<- PutRtmMsg) {
+// startInput switches to the input view, with the given text typed into it.
+func startInput(g *gocui.Gui, v *gocui.View, text string) error {
+	v.Highlight = false
+	if err := g.SetCurrentView("input"); err != nil {
+		return err
+	}
+	g.Cursor = true
+	input := g.CurrentView()
+	clearInput(input)
+	fmt.Fprint(input, text)
+	return input.SetCursor(len(text), 0)
+}
+
+func setKeybindings(g *gocui.Gui, env commandEnv) {
+	chans, state := env.chans, env.state
 	if err := g.SetKeybinding("", gocui.KeyCtrlC, gocui.ModNone, quit); err != nil {
 		log.Panicln(err)
 	}
@@ -310,67 +767,206 @@ func setKeybindings(g *gocui.Gui, token string, getChannelIdChan chan<- ChannelI
 	if err := g.SetKeybinding("channels", gocui.KeyCtrlN, gocui.ModNone, cursorDown); err != nil {
 		log.Panicln(err)
 	}
-	if err := g.SetKeybinding("channels", gocui.KeyTab, gocui.ModNone, nextView); err != nil {
+	nextViewState := func(g *gocui.Gui, v *gocui.View) error {
+		return nextView(g, v, state)
+	}
+	if err := g.SetKeybinding("channels", gocui.KeyTab, gocui.ModNone, nextViewState); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("input", gocui.KeyTab, gocui.ModNone, nextViewState); err != nil {
 		log.Panicln(err)
 	}
-	if err := g.SetKeybinding("input", gocui.KeyTab, gocui.ModNone, nextView); err != nil {
+	if err := g.SetKeybinding("messages", gocui.KeyTab, gocui.ModNone, nextViewState); err != nil {
 		log.Panicln(err)
 	}
 	if err := g.SetKeybinding("input", gocui.KeyEnter, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
-		return inputEnter(g, v, getChannelIdChan, sendMsgChan)
+		return inputEnter(g, v, env)
 	}); err != nil {
 		log.Panicln(err)
 	}
 
 	if err := g.SetKeybinding("channels", gocui.KeyEnter, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
-		return selectChannel(g, v, token, getChannelIdChan, getUserNameChan, getMessagesChan)
+		return selectChannel(g, v, chans, state)
+	}); err != nil {
+		log.Panicln(err)
+	}
+
+	messagesDown := func(g *gocui.Gui, v *gocui.View) error { return messagesCursorDown(g, v, state) }
+	messagesUp := func(g *gocui.Gui, v *gocui.View) error { return messagesCursorUp(g, v, state) }
+	for _, key := range []gocui.Key{gocui.KeyArrowDown, gocui.KeyCtrlN} {
+		if err := g.SetKeybinding("messages", key, gocui.ModNone, messagesDown); err != nil {
+			log.Panicln(err)
+		}
+	}
+	for _, key := range []gocui.Key{gocui.KeyArrowUp, gocui.KeyCtrlP} {
+		if err := g.SetKeybinding("messages", key, gocui.ModNone, messagesUp); err != nil {
+			log.Panicln(err)
+		}
+	}
+
+	if err := g.SetKeybinding("messages", '+', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return startInput(g, v, reactCommand+" ")
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", '-', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return startInput(g, v, unreactCommand+" ")
 	}); err != nil {
 		log.Panicln(err)
 	}
+
+	setThreadKeybindings(g, chans, state)
+	setEditKeybindings(g, env)
+	setUploadKeybindings(g, env)
+	setFileKeybindings(g, env)
+	setSearchKeybindings(g, env)
+	setPinKeybindings(g, env)
+	setOutboxKeybindings(g, env)
+}
+
+// statusDuration is how long status text is shown.
+const statusDuration = 10 * time.Second
+
+// setStatus shows the given text in the title of the input view, until it's replaced or statusDuration passes.
+// This may be safely called from any goroutine.
+func setStatus(g *gocui.Gui, text string) {
+	g.Execute(func(g *gocui.Gui) error {
+		v, err := g.View("input")
+		if err != nil {
+			return err
+		}
+		v.Title = text
+		return nil
+	})
+	time.AfterFunc(statusDuration, func() {
+		g.Execute(func(g *gocui.Gui) error {
+			v, err := g.View("input")
+			if err != nil {
+				return err
+			}
+			if v.Title == text {
+				v.Title = ""
+			}
+			return nil
+		})
+	})
 }
 
-func getSelectedChannelName(g *gocui.Gui) (string, error) {
+func throttleStatus(t ThrottleInfo) string {
+	wait := t.Wait.Round(time.Second)
+	if t.Limited {
+		return fmt.Sprintf("Rate limited by Slack on %s, retrying in %v", t.Method, wait)
+	}
+	return fmt.Sprintf("Waiting %v for Slack rate limit on %s", wait, t.Method)
+}
+
+// showRtmStatus shows the RTM connection and its latency in the channels view title, and shows a status when it
+// connects or disconnects.
+func showRtmStatus(g *gocui.Gui, state *guiState, st RtmStatus) error {
+	if st.Connected != state.rtm.Connected || !st.Connected {
+		setStatus(g, rtmStatusText(st))
+	}
+	state.rtm = st
 	v, err := g.View("channels")
 	if err != nil {
-		return "", err
+		return err
 	}
-	_, cy := v.Cursor()
-	name, err := v.Line(cy)
-	if err != nil {
-		return "", err
+	v.Title = rtmStatusTitle(st)
+	return nil
+}
+
+// rtmStatusTitle returns the short text showing the RTM connection, e.g. "Slack 42ms".
+func rtmStatusTitle(st RtmStatus) string {
+	switch {
+	case !st.Connected:
+		return "Slack: offline"
+	case st.Latency > 0:
+		return "Slack " + st.Latency.Round(time.Millisecond).String()
+	default:
+		return "Slack"
 	}
-	return name, nil
 }
 
-func guiUpdater(g *gocui.Gui,
-	updateMsgsChan <-chan string,
-	getUserNameChan chan<- UserNameRequest,
-	getChannelNameChan chan<- ChannelNameRequest,
-	getMessagesChan chan<- MessageRequest,
-	slackToken string) {
+func rtmStatusText(st RtmStatus) string {
+	switch {
+	case st.Connected:
+		return "Connected to Slack"
+	case isRtmAuthError(st.Err):
+		return fmt.Sprintf("Slack rejected the token, not reconnecting: %v. Press Ctrl-C to quit", st.Err)
+	case st.RetryIn > 0:
+		return fmt.Sprintf("Can't connect to Slack, retrying in %v: %v", st.RetryIn.Round(time.Second), st.Err)
+	default:
+		return "Disconnected from Slack, reconnecting"
+	}
+}
+
+// guiUpdater updates the GUI from Slack events. When env.ctx is done, it quits the GUI and returns.
+func guiUpdater(g *gocui.Gui, env commandEnv) {
+	ctx, chans, state := env.ctx, env.chans, env.state
 	for {
 		log.Println("guiUpdater listening")
 		select {
-		case channelId := <-updateMsgsChan:
+		case <-ctx.Done():
+			g.Execute(func(g *gocui.Gui) error { return gocui.ErrQuit })
+			return
+		case channelId := <-chans.UpdateMsgs:
 			log.Println("guiUpdater not listening")
 			log.Println("gui updater got " + channelId)
-			channelName := GetChannelName(channelId, getChannelNameChan)
-			log.Println("guiupdater name " + channelName)
-			selectedChannelName, err := getSelectedChannelName(g)
-			log.Println("guiupdater selectedname " + selectedChannelName)
-			if err != nil {
-				log.Panicln(err)
-			}
-			if channelName != selectedChannelName {
-				log.Println("guiupdater continuing")
-				continue
-			}
-			log.Println("guiupdater populating messages")
-			err = populateMessages(g, slackToken, channelId, getUserNameChan, getMessagesChan)
-			log.Println("guiupdater populated msgs")
-			if err != nil {
-				log.Panicln(err)
+			g.Execute(func(g *gocui.Gui) error {
+				if state.thread != nil && state.thread.ChannelId == channelId {
+					if err := populateThread(g, chans, state); err != nil {
+						return err
+					}
+				}
+				if selected, ok := selectedChannel(g, state); !ok || selected.Id != channelId {
+					log.Println("guiupdater not selected")
+					return nil
+				}
+				if state.contextTs != "" {
+					return nil // don't replace a search result's or pin's context with the newest messages
+				}
+				log.Println("guiupdater populating messages")
+				err := populateMessages(g, channelId, chans, state)
+				log.Println("guiupdater populated msgs")
+				return err
+			})
+		case channelId := <-chans.PinsChanged:
+			g.Execute(func(g *gocui.Gui) error {
+				if state.pins != nil && state.pins.ChannelId == channelId {
+					loadPins(g, env, channelId, state.pins.ChannelName, true)
+				}
+				return nil
+			})
+		case <-chans.UsersChanged:
+			g.Execute(func(g *gocui.Gui) error {
+				if err := renderChannels(g, chans, state); err != nil {
+					return err
+				}
+				if state.channelId == "" || state.contextTs != "" {
+					return nil
+				}
+				return populateMessages(g, state.channelId, chans, state)
+			})
+		case <-chans.ChannelsChanged:
+			g.Execute(func(g *gocui.Gui) error {
+				return refreshChannels(g, env)
+			})
+		case t := <-chans.Throttled:
+			setStatus(g, throttleStatus(t))
+		case st := <-chans.RtmStatus:
+			g.Execute(func(g *gocui.Gui) error {
+				return showRtmStatus(g, state, st)
+			})
+		case typing := <-chans.UserTyping:
+			if typing.UserId == env.config.SelfId {
+				continue // typing in another client
 			}
+			go func() {
+				name := GetUserName(typing.UserId, chans.GetUserName) // the user may be looked up, so not on the GUI goroutine
+				g.Execute(func(g *gocui.Gui) error {
+					return showTyping(g, env, typing, name)
+				})
+			}()
 		}
 	}
 }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"github.com/jroimartin/gocui"
+	"log"
+	"strings"
+)
+
+const (
+	joinCommand    = "/join"
+	leaveCommand   = "/leave"
+	createCommand  = "/create"
+	archiveCommand = "/archive"
+	topicCommand   = "/topic"
+	purposeCommand = "/purpose"
+	inviteCommand  = "/invite"
+	kickCommand    = "/kick"
+)
+
+// channelArg returns the id and name of the channel named in args, e.g. #general, or of the selected channel if args
+// is empty, and shows a status if there's none.
+func channelArg(g *gocui.Gui, env commandEnv, args string) (string, string, bool) {
+	if args == "" {
+		if env.state.channelId == "" {
+			setStatus(g, "Select a channel first")
+			return "", "", false
+		}
+		return env.state.channelId, GetChannelName(env.state.channelId, env.chans.GetChannelName), true
+	}
+	name := strings.TrimPrefix(args, "#")
+	id := GetChannelId(name, env.chans.GetChannelId)
+	if id == "" {
+		setStatus(g, "Unknown channel "+args)
+		return "", "", false
+	}
+	return id, name, true
+}
+
+// userArgs returns the ids of the users named in args, e.g. "@alice @bob", and shows a status if any are unknown.
+func userArgs(g *gocui.Gui, env commandEnv, args string) ([]string, bool) {
+	var ids []string
+	for _, name := range strings.Fields(args) {
+		id := GetUserId(strings.TrimPrefix(name, "@"), env.chans.GetUserId)
+		if id == "" {
+			setStatus(g, "Unknown user "+name)
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// changeChannelList applies a change made by a command to the channel manager, and redraws the channels view,
+// without waiting for Slack to send the RTM event.
+func changeChannelList(g *gocui.Gui, env commandEnv, change ChannelChange) {
+	env.chans.ChangeChannel <- change
+	g.Execute(func(g *gocui.Gui) error {
+		return refreshChannels(g, env)
+	})
+}
+
+// putChannelList adds a conversation joined or created by a command to the channel manager, and redraws the channels
+// view, without waiting for Slack to send the RTM event.
+func putChannelList(g *gocui.Gui, env commandEnv, channel SlackChannel) {
+	env.chans.PutChannel <- channel
+	g.Execute(func(g *gocui.Gui) error {
+		return refreshChannels(g, env)
+	})
+}
+
+func joinChannel(g *gocui.Gui, env commandEnv, args string) error {
+	if args == "" {
+		setStatus(g, "Usage: "+joinCommand+" #channel")
+		return nil
+	}
+	channelId, name, ok := channelArg(g, env, args)
+	if !ok {
+		return nil
+	}
+	go func() {
+		channel, err := env.client.JoinSlackChannel(env.ctx, channelId)
+		if err != nil {
+			log.Println("error joining " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("join "+name, err))
+			return
+		}
+		putChannelList(g, env, channel)
+		setStatus(g, "Joined #"+name)
+	}()
+	return nil
+}
+
+func leaveChannel(g *gocui.Gui, env commandEnv, args string) error {
+	channelId, name, ok := channelArg(g, env, args)
+	if !ok {
+		return nil
+	}
+	go func() {
+		if err := env.client.LeaveSlackChannel(env.ctx, channelId); err != nil {
+			log.Println("error leaving " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("leave "+name, err))
+			return
+		}
+		changeChannelList(g, env, ChannelChange{Id: channelId, Change: func(c *ChannelInfo) { c.Member = false }})
+		setStatus(g, "Left "+name)
+	}()
+	return nil
+}
+
+// createChannel creates a channel, which is private if args starts with -private.
+func createChannel(g *gocui.Gui, env commandEnv, args string) error {
+	private := false
+	if rest := strings.TrimPrefix(args, "-private"); rest != args {
+		private, args = true, strings.TrimSpace(rest)
+	}
+	name := strings.TrimPrefix(args, "#")
+	if name == "" || strings.Contains(name, " ") {
+		setStatus(g, "Usage: "+createCommand+" [-private] name")
+		return nil
+	}
+	go func() {
+		channel, err := env.client.CreateSlackChannel(env.ctx, name, private)
+		if err != nil {
+			log.Println("error creating " + name + ": " + err.Error())
+			setStatus(g, channelErrorText("create #"+name, err))
+			return
+		}
+		putChannelList(g, env, channel)
+		setStatus(g, "Created #"+channel.Name)
+	}()
+	return nil
+}
+
+func archiveChannel(g *gocui.Gui, env commandEnv, args string) error {
+	channelId, name, ok := channelArg(g, env, args)
+	if !ok {
+		return nil
+	}
+	go func() {
+		if err := env.client.ArchiveSlackChannel(env.ctx, channelId); err != nil {
+			log.Println("error archiving " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("archive "+name, err))
+			return
+		}
+		changeChannelList(g, env, ChannelChange{Id: channelId, Change: func(c *ChannelInfo) { c.Archived = true }})
+		setStatus(g, "Archived "+name)
+	}()
+	return nil
+}
+
+func setTopic(g *gocui.Gui, env commandEnv, args string) error {
+	return setChannelText(g, env, "topic", args)
+}
+
+func setPurpose(g *gocui.Gui, env commandEnv, args string) error {
+	return setChannelText(g, env, "purpose", args)
+}
+
+// setChannelText sets the selected channel's topic or purpose. Slack posts a channel_topic or channel_purpose
+// message, which is shown like any other.
+func setChannelText(g *gocui.Gui, env commandEnv, what, text string) error {
+	channelId, name, ok := channelArg(g, env, "")
+	if !ok {
+		return nil
+	}
+	go func() {
+		var err error
+		if what == "topic" {
+			err = env.client.SetSlackChannelTopic(env.ctx, channelId, text)
+		} else {
+			err = env.client.SetSlackChannelPurpose(env.ctx, channelId, text)
+		}
+		if err != nil {
+			log.Println("error setting " + what + " of " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("set the "+what+" of "+name, err))
+			return
+		}
+		if text == "" {
+			setStatus(g, "Cleared the "+what+" of "+name)
+		} else {
+			setStatus(g, "Set the "+what+" of "+name)
+		}
+	}()
+	return nil
+}
+
+func inviteUsers(g *gocui.Gui, env commandEnv, args string) error {
+	if args == "" {
+		setStatus(g, "Usage: "+inviteCommand+" @user...")
+		return nil
+	}
+	channelId, name, ok := channelArg(g, env, "")
+	if !ok {
+		return nil
+	}
+	userIds, ok := userArgs(g, env, args)
+	if !ok {
+		return nil
+	}
+	go func() {
+		if err := env.client.InviteToSlackChannel(env.ctx, channelId, userIds); err != nil {
+			log.Println("error inviting " + strings.Join(userIds, ",") + " to " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("invite "+args+" to "+name, err))
+			return
+		}
+		setStatus(g, "Invited "+args+" to "+name)
+	}()
+	return nil
+}
+
+func kickUser(g *gocui.Gui, env commandEnv, args string) error {
+	if args == "" || strings.Contains(args, " ") {
+		setStatus(g, "Usage: "+kickCommand+" @user")
+		return nil
+	}
+	channelId, name, ok := channelArg(g, env, "")
+	if !ok {
+		return nil
+	}
+	userIds, ok := userArgs(g, env, args)
+	if !ok {
+		return nil
+	}
+	go func() {
+		if err := env.client.KickFromSlackChannel(env.ctx, channelId, userIds[0]); err != nil {
+			log.Println("error removing " + userIds[0] + " from " + channelId + ": " + err.Error())
+			setStatus(g, channelErrorText("remove "+args+" from "+name, err))
+			return
+		}
+		setStatus(g, "Removed "+args+" from "+name)
+	}()
+	return nil
+}
+
+// channelErrorText returns the status shown when a channel command fails. action is what failed, e.g. "join #general".
+func channelErrorText(action string, err error) string {
+	switch SlackErrorCode(err) {
+	case "name_taken":
+		return "A channel with that name already exists"
+	case "invalid_name", "invalid_name_specials", "invalid_name_punctuation", "invalid_name_maxlength", "invalid_name_required":
+		return "Channel names must be lowercase, without spaces or periods, and at most 80 characters"
+	case "is_archived":
+		return "That channel is archived"
+	case "already_archived":
+		return "That channel is already archived"
+	case "not_in_channel", "channel_not_found":
+		return "You are not a member of that channel"
+	case "cant_leave_general", "cant_archive_general":
+		return "You can't " + action + ". It's the workspace's general channel"
+	case "already_in_channel":
+		return "They are already in that channel"
+	case "cant_invite_self":
+		return "You can't invite yourself"
+	case "cant_kick_self":
+		return "You can't remove yourself. Use " + leaveCommand
+	case "method_not_supported_for_channel_type":
+		return "You can't " + action + ". It isn't a channel"
+	case "missing_scope", "restricted_action", "not_authorized":
+		return "You don't have permission to " + action
+	default:
+		return "Error trying to " + action + ": " + err.Error()
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"github.com/jroimartin/gocui"
+	"log"
+	"strings"
+)
+
+const editTitle = "Editing message (Enter saves, Esc cancels)"
+
+// editingMessage is the message being edited in the input.
+type editingMessage struct {
+	ChannelId string
+	Time      string
+}
+
+// isOwnMessage returns whether msg was sent by the token's user, and so may be edited or deleted.
+func isOwnMessage(env commandEnv, msg TermMsg) bool {
+	return msg.Time != "" && msg.UserId == env.config.SelfId
+}
+
+// startEdit puts the text of msg in the input, to be edited and saved with Enter.
+func startEdit(g *gocui.Gui, v *gocui.View, env commandEnv, channelId string, msg TermMsg) error {
+	env.state.editing = &editingMessage{ChannelId: channelId, Time: msg.Time}
+	if err := startInput(g, v, strings.TrimRight(msg.Text, " \n\t")); err != nil {
+		return err
+	}
+	g.CurrentView().Title = editTitle
+	return nil
+}
+
+// endEdit stops editing, clearing the input.
+func endEdit(g *gocui.Gui, state *guiState) error {
+	state.editing = nil
+	v, err := g.View("input")
+	if err != nil {
+		return err
+	}
+	if v.Title == editTitle {
+		v.Title = ""
+	}
+	clearInput(v)
+	return nil
+}
+
+// saveEdit replaces the text of the message being edited with text. The message itself is updated when Slack sends
+// the message_changed RTM event.
+func saveEdit(g *gocui.Gui, env commandEnv, text string) error {
+	editing := *env.state.editing
+	if err := endEdit(g, env.state); err != nil {
+		return err
+	}
+	if text == "" {
+		setStatus(g, "Edit cancelled. To delete a message, select it and press d")
+		return nil
+	}
+	go func() {
+		if err := env.client.UpdateSlackMessage(env.ctx, editing.ChannelId, editing.Time, text); err != nil {
+			log.Println("error updating message " + editing.ChannelId + " " + editing.Time + ": " + err.Error())
+			setStatus(g, changeMessageErrorText("editing", err))
+		}
+	}()
+	return nil
+}
+
+// editSelectedMessage edits the message selected in the messages view, if it's the user's own.
+func editSelectedMessage(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		return nil
+	}
+	if !isOwnMessage(env, msg) {
+		setStatus(g, "You can only edit your own messages")
+		return nil
+	}
+	return startEdit(g, v, env, env.state.channelId, msg)
+}
+
+// editLastMessage edits the user's newest message in the open thread, or the selected channel, if the input is empty.
+// The messages are looked up in the background, because they may not be cached, and the edit is started if the input,
+// channel, and thread haven't changed meanwhile.
+func editLastMessage(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	if strings.TrimSpace(v.Buffer()) != "" || env.state.editing != nil || env.state.snippet != nil {
+		return nil
+	}
+	channelId, thread := env.state.channelId, env.state.thread
+	if thread == nil && channelId == "" {
+		return nil
+	}
+	go func() {
+		msg, ok := lastOwnMessage(g, env, channelId, thread)
+		if !ok {
+			return
+		}
+		g.Execute(func(g *gocui.Gui) error {
+			state := env.state
+			if strings.TrimSpace(v.Buffer()) != "" || state.editing != nil || state.snippet != nil || state.channelId != channelId || state.thread != thread {
+				return nil
+			}
+			if thread != nil {
+				return startEdit(g, v, env, thread.ChannelId, msg)
+			}
+			return startEdit(g, v, env, channelId, msg)
+		})
+	}()
+	return nil
+}
+
+// lastOwnMessage returns the user's newest message in thread, if it's not nil, or else in the channel. It must not be
+// called on the GUI goroutine, because the messages may be fetched from Slack.
+func lastOwnMessage(g *gocui.Gui, env commandEnv, channelId string, thread *openThread) (TermMsg, bool) {
+	if thread != nil {
+		msgs, err := GetThread(thread.ChannelId, thread.ThreadTs, env.chans.GetThread)
+		if err != nil {
+			setStatus(g, "Error getting thread: "+err.Error())
+			return TermMsg{}, false
+		}
+		for i := len(msgs) - 1; i >= 0; i-- { // threads are oldest first
+			if isOwnMessage(env, msgs[i]) {
+				return msgs[i], true
+			}
+		}
+		return TermMsg{}, false
+	}
+	msgs, err := GetMessages(channelId, env.chans.GetMessages)
+	if err != nil {
+		setStatus(g, "Error getting messages: "+err.Error())
+		return TermMsg{}, false
+	}
+	for _, msg := range msgs {
+		if isOwnMessage(env, msg) {
+			return msg, true
+		}
+	}
+	return TermMsg{}, false
+}
+
+// startDelete starts a delete command for the selected message, which is deleted when Enter is pressed. If sending
+// the message failed, it's discarded right away.
+func startDelete(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	if msg, ok := selectedLine(g, env.state); ok && msg.Time == "" {
+		return discardUnsent(g, env, msg)
+	}
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		return nil
+	}
+	if !isOwnMessage(env, msg) {
+		setStatus(g, "You can only delete your own messages")
+		return nil
+	}
+	if err := startInput(g, v, deleteCommand); err != nil {
+		return err
+	}
+	setStatus(g, "Press Enter to delete the selected message, or Esc to cancel")
+	return nil
+}
+
+// deleteSelected deletes the message selected in the messages view. The message itself is removed when Slack sends
+// the message_deleted RTM event.
+func deleteSelected(g *gocui.Gui, env commandEnv, args string) error {
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		setStatus(g, "Select a message in the messages view first")
+		return nil
+	}
+	if !isOwnMessage(env, msg) {
+		setStatus(g, "You can only delete your own messages")
+		return nil
+	}
+	channelId := env.state.channelId
+	go func() {
+		if err := env.client.DeleteSlackMessage(env.ctx, channelId, msg.Time); err != nil {
+			log.Println("error deleting message " + channelId + " " + msg.Time + ": " + err.Error())
+			setStatus(g, changeMessageErrorText("deleting", err))
+		}
+	}()
+	return nil
+}
+
+func changeMessageErrorText(action string, err error) string {
+	switch SlackErrorCode(err) {
+	case "cant_update_message", "cant_delete_message":
+		return "You can only change your own messages"
+	case "edit_window_closed":
+		return "This message is too old to edit"
+	case "message_not_found":
+		return "Message not found. It may have been deleted"
+	default:
+		return "Error " + action + " message: " + err.Error()
+	}
+}
+
+// escape closes the file viewer or search results, or cancels the edit or snippet in progress, if any, or else closes the open thread.
+func escape(g *gocui.Gui, v *gocui.View, state *guiState) error {
+	if state.viewer != nil {
+		return closeFileViewer(g, v, state)
+	}
+	if state.search != nil && g.CurrentView() != nil && g.CurrentView().Name() == "search" {
+		return closeSearch(g, state)
+	}
+	if state.pins != nil && g.CurrentView() != nil && g.CurrentView().Name() == "pins" {
+		return closePins(g, state)
+	}
+	if state.editing != nil {
+		return endEdit(g, state)
+	}
+	if state.snippet != nil {
+		return endSnippet(g, state)
+	}
+	if g.CurrentView() != nil && g.CurrentView().Name() == "input" && strings.HasPrefix(g.CurrentView().Buffer(), "/") {
+		clearInput(g.CurrentView()) // cancel a command started by a key, e.g. d
+		return nil
+	}
+	return closeThread(g, v, state)
+}
+
+func setEditKeybindings(g *gocui.Gui, env commandEnv) {
+	if err := g.SetKeybinding("input", gocui.KeyArrowUp, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return editLastMessage(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 'e', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return editSelectedMessage(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 'd', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return startDelete(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyEsc, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return escape(g, v, env.state)
+	}); err != nil {
+		log.Panicln(err)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	downloadCommand = "/download"
+	viewCommand     = "/view"
+)
+
+// maxViewSize is the largest file which may be viewed. Larger files must be downloaded.
+const maxViewSize = 256 * 1024
+
+const fileViewerTitle = " (arrows scroll, q or Esc closes)"
+
+// fileViewer is a text file shown in the file view.
+type fileViewer struct {
+	Title   string
+	Content string
+}
+
+var errTooLarge = errors.New("file is too large to view")
+
+// limitedBuffer is a buffer which fails writes past its limit.
+// It doesn't embed bytes.Buffer, whose ReadFrom would let io.Copy bypass the limit.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.limit {
+		return 0, errTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
+
+// formatSize returns the size in bytes as a short human readable string, e.g. 12 KB.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 3; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.0f %cB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+// fileInfoText returns the file's name, size, type, and uploader, to show in its message.
+func fileInfoText(file TermFile) string {
+	info := []string{"file: " + fileName(file.SlackFile)}
+	if file.Size > 0 {
+		info = append(info, formatSize(file.Size))
+	}
+	if file.Mimetype != "" {
+		info = append(info, file.Mimetype)
+	}
+	if file.UserName != "" {
+		info = append(info, "by "+file.UserName)
+	}
+	return strings.Join(info, " ")
+}
+
+// isTextFile returns whether the file is text, which may be viewed.
+func isTextFile(file SlackFile) bool {
+	if file.Mode == "snippet" || strings.HasPrefix(file.Mimetype, "text/") {
+		return true
+	}
+	switch file.Mimetype {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+// selectedFiles returns the files of the selected message, and shows a status if there are none.
+func selectedFiles(g *gocui.Gui, state *guiState) []TermFile {
+	msg, ok := selectedMessage(g, state)
+	if !ok {
+		setStatus(g, "Select a message in the messages view first")
+		return nil
+	}
+	if len(msg.Files) == 0 {
+		setStatus(g, "The selected message has no files")
+		return nil
+	}
+	return msg.Files
+}
+
+// createDownloadFile creates a new file named name in dir, adding a number to the name if it already exists.
+func createDownloadFile(dir, name string) (*os.File, error) {
+	name = filepath.Base(name) // the name is from Slack, and mustn't be able to write outside dir
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "download"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) || i > 100 {
+			return f, err
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
+
+// downloadFiles downloads the files of the selected message to the download directory.
+func downloadFiles(g *gocui.Gui, env commandEnv, args string) error {
+	files := selectedFiles(g, env.state)
+	if len(files) == 0 {
+		return nil
+	}
+	dir := env.config.DownloadDir
+	if args != "" {
+		dir = expandHome(args)
+	}
+	go func() {
+		for _, file := range files {
+			name := fileName(file.SlackFile)
+			if file.Name != "" {
+				name = file.Name
+			}
+			setStatus(g, "Downloading "+name)
+			f, err := createDownloadFile(dir, name)
+			if err != nil {
+				setStatus(g, "Error downloading "+name+": "+err.Error())
+				return
+			}
+			_, err = env.client.DownloadSlackFile(env.ctx, file.SlackFile, f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				log.Println("error downloading " + file.Id + ": " + err.Error())
+				os.Remove(f.Name())
+				setStatus(g, "Error downloading "+name+": "+err.Error())
+				return
+			}
+			setStatus(g, "Downloaded "+f.Name())
+		}
+	}()
+	return nil
+}
+
+// viewFile shows the selected message's text file in the file view. args is the number of the file to view, if the
+// message has more than one, starting at 1.
+func viewFile(g *gocui.Gui, env commandEnv, args string) error {
+	files := selectedFiles(g, env.state)
+	if len(files) == 0 {
+		return nil
+	}
+	file := files[0]
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > len(files) {
+			setStatus(g, fmt.Sprintf("Usage: %s [1-%d]", viewCommand, len(files)))
+			return nil
+		}
+		file = files[n-1]
+	}
+	name := fileName(file.SlackFile)
+	if !isTextFile(file.SlackFile) {
+		setStatus(g, name+" isn't a text file. Use "+downloadCommand+" to save it")
+		return nil
+	}
+	if file.Size > maxViewSize {
+		setStatus(g, name+" is too large to view. Use "+downloadCommand+" to save it")
+		return nil
+	}
+	go func() {
+		content := &limitedBuffer{limit: maxViewSize}
+		if _, err := env.client.DownloadSlackFile(env.ctx, file.SlackFile, content); err != nil {
+			log.Println("error viewing " + file.Id + ": " + err.Error())
+			setStatus(g, "Error getting "+name+": "+err.Error())
+			return
+		}
+		g.Execute(func(g *gocui.Gui) error {
+			env.state.viewer = &fileViewer{Title: name, Content: content.String()}
+			return openFileViewer(g, env.state)
+		})
+	}()
+	return nil
+}
+
+func layoutFileViewer(g *gocui.Gui, state *guiState, x0, y0, x1, y1 int) error {
+	if state.viewer == nil {
+		if err := g.DeleteView("file"); err != nil && err != gocui.ErrUnknownView {
+			return err
+		}
+		return nil
+	}
+	if v, err := g.SetView("file", x0, y0, x1, y1); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Wrap = true
+	}
+	return nil
+}
+
+func openFileViewer(g *gocui.Gui, state *guiState) error {
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	v, err := g.View("file")
+	if err != nil {
+		return err
+	}
+	v.Clear()
+	v.SetOrigin(0, 0)
+	v.Title = state.viewer.Title + fileViewerTitle
+	fmt.Fprint(v, strings.Replace(state.viewer.Content, "\t", "    ", -1))
+	g.Cursor = false
+	return g.SetCurrentView("file")
+}
+
+func closeFileViewer(g *gocui.Gui, v *gocui.View, state *guiState) error {
+	if state.viewer == nil {
+		return nil
+	}
+	state.viewer = nil
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	if err := g.SetCurrentView("messages"); err != nil {
+		return err
+	}
+	g.CurrentView().Highlight = true
+	return nil
+}
+
+// scrollFileViewer scrolls the file view by the given number of lines.
+func scrollFileViewer(v *gocui.View, lines int) error {
+	ox, oy := v.Origin()
+	oy += lines
+	if oy < 0 {
+		oy = 0
+	}
+	if max := strings.Count(v.Buffer(), "\n") - 1; oy > max && max >= 0 {
+		oy = max
+	}
+	return v.SetOrigin(ox, oy)
+}
+
+func setFileKeybindings(g *gocui.Gui, env commandEnv) {
+	scroll := func(lines int) func(g *gocui.Gui, v *gocui.View) error {
+		return func(g *gocui.Gui, v *gocui.View) error { return scrollFileViewer(v, lines) }
+	}
+	for key, lines := range map[gocui.Key]int{gocui.KeyArrowDown: 1, gocui.KeyCtrlN: 1, gocui.KeyArrowUp: -1, gocui.KeyCtrlP: -1} {
+		if err := g.SetKeybinding("file", key, gocui.ModNone, scroll(lines)); err != nil {
+			log.Panicln(err)
+		}
+	}
+	if err := g.SetKeybinding("file", gocui.KeyPgdn, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		_, height := v.Size()
+		return scrollFileViewer(v, height)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("file", gocui.KeyPgup, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		_, height := v.Size()
+		return scrollFileViewer(v, -height)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("file", 'q', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return closeFileViewer(g, v, env.state)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 'v', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return viewFile(g, env, "")
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 's', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return downloadFiles(g, env, "")
+	}); err != nil {
+		log.Panicln(err)
+	}
+}
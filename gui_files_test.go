@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateDownloadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackterm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the name is kept in the directory, and not overwritten
+	for i := 0; i < 2; i++ {
+		out, err := createDownloadFile(dir, "../../a.txt")
+		if err != nil || !strings.HasPrefix(out.Name(), dir) {
+			t.Fatalf("expected a file in %s, got %v", dir, err)
+		}
+		out.Close()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a (1).txt")); err != nil {
+		t.Fatalf("expected the second download numbered, got %v", err)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for size, want := range map[int64]string{5: "5 B", 12 * 1024: "12 KB", 3 * 1024 * 1024: "3 MB"} {
+		if got := formatSize(size); got != want {
+			t.Fatalf("expected %s for %d, got %s", want, size, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/jroimartin/gocui"
+	"log"
+)
+
+// failedMessages returns the outbox ids of every message in the channel and thread, if it's not nil, which failed. It
+// must not be called on the GUI goroutine, because the messages may be fetched from Slack.
+func failedMessages(env commandEnv, channelId string, thread *openThread) []int {
+	var msgs []TermMsg
+	if channelId != "" {
+		channelMsgs, err := GetMessages(channelId, env.chans.GetMessages)
+		if err == nil {
+			msgs = append(msgs, channelMsgs...)
+		}
+	}
+	if thread != nil {
+		replies, err := GetThread(thread.ChannelId, thread.ThreadTs, env.chans.GetThread)
+		if err == nil {
+			msgs = append(msgs, replies...)
+		}
+	}
+	var ids []int
+	found := make(map[int]bool)
+	for _, msg := range msgs {
+		if msg.SendError != "" && !found[msg.OutboxId] { // broadcast replies are in both the channel and thread
+			ids = append(ids, msg.OutboxId)
+			found[msg.OutboxId] = true
+		}
+	}
+	return ids
+}
+
+// retryMessages sends the selected message again, if sending it failed, or else every failed message in the shown
+// channel and open thread, which are looked up in the background.
+func retryMessages(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	if msg, ok := selectedLine(g, env.state); ok && msg.SendError != "" {
+		env.chans.RetryMsg <- OutboxRetry{Id: msg.OutboxId}
+		return nil
+	}
+	channelId, thread := env.state.channelId, env.state.thread
+	go func() {
+		ids := failedMessages(env, channelId, thread)
+		if len(ids) == 0 {
+			setStatus(g, "No unsent messages to retry")
+			return
+		}
+		for _, id := range ids {
+			env.chans.RetryMsg <- OutboxRetry{Id: id}
+		}
+	}()
+	return nil
+}
+
+// discardUnsent removes msg, which is unacked, if sending it failed.
+func discardUnsent(g *gocui.Gui, env commandEnv, msg TermMsg) error {
+	if msg.Sending {
+		setStatus(g, "That message is still being sent")
+		return nil
+	}
+	env.chans.RetryMsg <- OutboxRetry{Id: msg.OutboxId, Discard: true}
+	setStatus(g, "Discarded the unsent message")
+	return nil
+}
+
+func setOutboxKeybindings(g *gocui.Gui, env commandEnv) {
+	if err := g.SetKeybinding("messages", 'r', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return retryMessages(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+}
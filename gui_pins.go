@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"log"
+	"strings"
+)
+
+const (
+	pinsCommand  = "/pins"
+	pinCommand   = "/pin"
+	unpinCommand = "/unpin"
+)
+
+// pinnedMessages are the pinned messages of a channel, shown in the pins view.
+type pinnedMessages struct {
+	ChannelId   string
+	ChannelName string
+	Msgs        []TermMsg // most recently pinned first
+}
+
+func pinsTitle(pins *pinnedMessages) string {
+	return fmt.Sprintf("Pinned in %s: %d (Enter jumps, u unpins, q or Esc closes)", pins.ChannelName, len(pins.Msgs))
+}
+
+// pinText returns the line shown for a pinned message: its author, time, and text.
+func pinText(msg TermMsg) string {
+	text := strings.Replace(strings.TrimSpace(messageBody(msg)), "\n", " ", -1)
+	return fmt.Sprintf("%-16s %s %s", msg.UserName, faint(tsTime(msg.Time).Format("2006-01-02 15:04")), text)
+}
+
+// showPins shows the pinned messages of the selected channel in the pins view.
+func showPins(g *gocui.Gui, env commandEnv, args string) error {
+	channelId := env.state.channelId
+	if channelId == "" {
+		setStatus(g, "Select a channel first")
+		return nil
+	}
+	setStatus(g, "Loading pins")
+	loadPins(g, env, channelId, GetChannelName(channelId, env.chans.GetChannelName), false)
+	return nil
+}
+
+// loadPins gets the channel's pinned messages, and shows them in the pins view. If reload, they're only shown if the
+// pins view is still showing the channel's pins, which they replace.
+func loadPins(g *gocui.Gui, env commandEnv, channelId, channelName string, reload bool) {
+	go func() {
+		msgs, err := env.client.GetSlackPins(env.ctx, channelId)
+		if err != nil {
+			log.Println("error getting pins " + channelId + ": " + err.Error())
+			setStatus(g, pinErrorText(err))
+			return
+		}
+		pins := &pinnedMessages{ChannelId: channelId, ChannelName: channelName, Msgs: slackMessagesToTermMsgs(msgs, env.chans.GetUserName)}
+		g.Execute(func(g *gocui.Gui) error {
+			if reload && (env.state.pins == nil || env.state.pins.ChannelId != channelId) {
+				return nil // closed, or showing another channel's pins, while loading
+			}
+			env.state.pins = pins
+			return openPins(g, env.state, reload)
+		})
+	}()
+}
+
+func layoutPins(g *gocui.Gui, state *guiState, x0, y0, x1, y1 int) error {
+	if state.pins == nil {
+		if err := g.DeleteView("pins"); err != nil && err != gocui.ErrUnknownView {
+			return err
+		}
+		return nil
+	}
+	if v, err := g.SetView("pins", x0, y0, x1, y1); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.SelFgColor = gocui.AttrReverse
+		v.SelBgColor = gocui.AttrReverse
+	}
+	return nil
+}
+
+// openPins renders the pins view. If reload, the cursor is kept, and the pins view isn't made current, so a pin
+// change from another client doesn't take the focus.
+func openPins(g *gocui.Gui, state *guiState, reload bool) error {
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	v, err := g.View("pins")
+	if err != nil {
+		return err
+	}
+	v.Clear()
+	v.Title = pinsTitle(state.pins)
+	if len(state.pins.Msgs) == 0 {
+		fmt.Fprintln(v, "No pinned messages.")
+	}
+	for _, msg := range state.pins.Msgs {
+		fmt.Fprintln(v, pinText(msg))
+	}
+	if reload {
+		if i, ok := selectedPin(v, state); !ok && i > 0 && len(state.pins.Msgs) > 0 {
+			v.SetOrigin(0, 0)
+			v.SetCursor(0, len(state.pins.Msgs)-1) // the last pin was removed
+		}
+		return nil
+	}
+	v.SetOrigin(0, 0)
+	v.SetCursor(0, 0)
+	g.Cursor = false
+	return g.SetCurrentView("pins")
+}
+
+func closePins(g *gocui.Gui, state *guiState) error {
+	if state.pins == nil {
+		return nil
+	}
+	state.pins = nil
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	if err := g.SetCurrentView("messages"); err != nil {
+		return err
+	}
+	g.CurrentView().Highlight = true
+	return nil
+}
+
+// selectedPin returns the index of the pinned message under the pins view's cursor.
+func selectedPin(v *gocui.View, state *guiState) (int, bool) {
+	_, oy := v.Origin()
+	_, cy := v.Cursor()
+	i := oy + cy
+	if state.pins == nil || i < 0 || i >= len(state.pins.Msgs) {
+		return i, false
+	}
+	return i, true
+}
+
+// jumpToPin closes the pins view, and shows the messages around the selected pinned message.
+func jumpToPin(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	i, ok := selectedPin(v, env.state)
+	if !ok {
+		return nil
+	}
+	pins, msg := env.state.pins, env.state.pins.Msgs[i]
+	if err := closePins(g, env.state); err != nil {
+		return err
+	}
+	return jumpToMessage(g, env, pins.ChannelId, pins.ChannelName, msg.Time)
+}
+
+// unpinSelectedPin unpins the pinned message under the pins view's cursor.
+// The pins view is updated when Slack sends the pin_removed RTM event.
+func unpinSelectedPin(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	i, ok := selectedPin(v, env.state)
+	if !ok {
+		return nil
+	}
+	changePin(g, env, env.state.pins.ChannelId, env.state.pins.Msgs[i].Time, false)
+	return nil
+}
+
+func pin(g *gocui.Gui, env commandEnv, args string) error {
+	return pinSelected(g, env, true)
+}
+
+func unpin(g *gocui.Gui, env commandEnv, args string) error {
+	return pinSelected(g, env, false)
+}
+
+// togglePin pins the selected message, or unpins it if it's pinned.
+func togglePin(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		return nil
+	}
+	changePin(g, env, env.state.channelId, msg.Time, !msg.Pinned)
+	return nil
+}
+
+// pinSelected pins or unpins the selected message.
+func pinSelected(g *gocui.Gui, env commandEnv, add bool) error {
+	msg, ok := selectedMessage(g, env.state)
+	if !ok {
+		setStatus(g, "Select a message in the messages view first")
+		return nil
+	}
+	changePin(g, env, env.state.channelId, msg.Time, add)
+	return nil
+}
+
+// changePin pins or unpins the message with the given ts.
+// The message itself is updated when Slack sends the pin_added or pin_removed RTM event.
+func changePin(g *gocui.Gui, env commandEnv, channelId, ts string, add bool) {
+	go func() {
+		var err error
+		if add {
+			err = env.client.AddSlackPin(env.ctx, channelId, ts)
+		} else {
+			err = env.client.RemoveSlackPin(env.ctx, channelId, ts)
+		}
+		if err != nil {
+			log.Println("error changing pin on " + channelId + " " + ts + ": " + err.Error())
+			setStatus(g, pinErrorText(err))
+			return
+		}
+		if add {
+			setStatus(g, "Pinned the message")
+		} else {
+			setStatus(g, "Unpinned the message")
+		}
+	}()
+}
+
+func pinErrorText(err error) string {
+	switch SlackErrorCode(err) {
+	case "already_pinned":
+		return "That message is already pinned"
+	case "no_pin":
+		return "That message isn't pinned"
+	case "not_pinnable":
+		return "That message can't be pinned"
+	case "too_many_pins":
+		return "This channel has too many pins"
+	case "not_in_channel", "channel_not_found":
+		return "You are not a member of this channel"
+	case "restricted_action", "permission_denied":
+		return "You don't have permission to change pins in this channel"
+	default:
+		return "Error with pins: " + err.Error()
+	}
+}
+
+func setPinKeybindings(g *gocui.Gui, env commandEnv) {
+	for _, key := range []gocui.Key{gocui.KeyArrowDown, gocui.KeyCtrlN} {
+		if err := g.SetKeybinding("pins", key, gocui.ModNone, cursorDown); err != nil {
+			log.Panicln(err)
+		}
+	}
+	for _, key := range []gocui.Key{gocui.KeyArrowUp, gocui.KeyCtrlP} {
+		if err := g.SetKeybinding("pins", key, gocui.ModNone, cursorUp); err != nil {
+			log.Panicln(err)
+		}
+	}
+	if err := g.SetKeybinding("pins", gocui.KeyEnter, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return jumpToPin(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("pins", 'u', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return unpinSelectedPin(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("pins", 'q', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return closePins(g, env.state)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 'p', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return togglePin(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	for _, view := range []string{"channels", "messages"} {
+		if err := g.SetKeybinding(view, 'P', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+			return showPins(g, env, "")
+		}); err != nil {
+			log.Panicln(err)
+		}
+	}
+}
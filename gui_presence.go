@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"github.com/jroimartin/gocui"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	awayCommand   = "/away"
+	autoCommand   = "/auto"
+	statusCommand = "/status"
+)
+
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// textWidth returns the number of columns text is printed in, ignoring ANSI escapes. Every rune is assumed to be one column.
+func textWidth(text string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(text, ""))
+}
+
+// userStatusText returns the text shown after a user's name: a dot if they're active, a circle if they're away, and
+// their status emoji, and text if withText. It's the empty string if none are known.
+func userStatusText(status UserStatus, withText bool) string {
+	text := ""
+	switch status.Presence {
+	case PresenceActive:
+		text += " \033[32m●\033[0m"
+	case PresenceAway:
+		text += " " + faint("○")
+	}
+	if status.Emoji != "" {
+		text += " " + status.Emoji
+	}
+	if withText && status.Text != "" {
+		text += " " + faint(status.Text)
+	}
+	return text
+}
+
+// parseDuration parses a duration like time.ParseDuration, which also accepts whole days, e.g. 2d.
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errors.New("invalid duration " + s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid duration " + s)
+	}
+	return d, nil
+}
+
+// isEmojiName returns whether s is an emoji name surrounded by colons, e.g. :palm_tree:.
+func isEmojiName(s string) bool {
+	return len(s) > 2 && strings.HasPrefix(s, ":") && strings.HasSuffix(s, ":")
+}
+
+// parseStatus parses the args of the status command: an optional duration after which the status is cleared, an
+// optional emoji, and text, e.g. "2h :spiral_calendar_pad: In a meeting". Empty args clear the status.
+func parseStatus(args string, now time.Time) SlackStatus {
+	var status SlackStatus
+	fields := strings.Fields(args)
+	if len(fields) > 0 {
+		if d, err := parseDuration(fields[0]); err == nil {
+			status.Expiration = now.Add(d)
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 0 && isEmojiName(fields[0]) {
+		status.Emoji = fields[0]
+		fields = fields[1:]
+	}
+	status.Text = strings.Join(fields, " ")
+	if status.Text == "" && status.Emoji == "" {
+		return SlackStatus{}
+	}
+	return status
+}
+
+func setAway(g *gocui.Gui, env commandEnv, args string) error {
+	return setPresence(g, env, PresenceAway)
+}
+
+func setAuto(g *gocui.Gui, env commandEnv, args string) error {
+	return setPresence(g, env, PresenceAuto)
+}
+
+// setPresence sets the token's user's presence. Slack sends a presence_change event, which updates the names shown.
+func setPresence(g *gocui.Gui, env commandEnv, presence string) error {
+	go func() {
+		if err := env.client.SetSlackPresence(env.ctx, presence); err != nil {
+			log.Println("error setting presence " + presence + ": " + err.Error())
+			setStatus(g, profileErrorText("presence", err))
+			return
+		}
+		if presence == PresenceAway {
+			setStatus(g, "You are away")
+		} else {
+			setStatus(g, "You are active when Slack thinks you are")
+		}
+	}()
+	return nil
+}
+
+// setUserStatus sets the token's user's custom status from the command args. Slack sends a user_change event, which
+// updates the names shown.
+func setUserStatus(g *gocui.Gui, env commandEnv, args string) error {
+	status := parseStatus(args, time.Now())
+	go func() {
+		if err := env.client.SetSlackStatus(env.ctx, status); err != nil {
+			log.Println("error setting status: " + err.Error())
+			setStatus(g, profileErrorText("status", err))
+			return
+		}
+		switch {
+		case status.Text == "" && status.Emoji == "":
+			setStatus(g, "Cleared your status")
+		case status.Expiration.IsZero():
+			setStatus(g, "Set your status")
+		default:
+			setStatus(g, "Set your status until "+status.Expiration.Format("Mon 15:04"))
+		}
+	}()
+	return nil
+}
+
+func profileErrorText(what string, err error) string {
+	switch SlackErrorCode(err) {
+	case "missing_scope", "not_allowed_token_type":
+		return "Your Slack token doesn't have permission to change your " + what
+	case "invalid_presence":
+		return "Presence must be away or auto"
+	case "too_long":
+		return "That status is too long"
+	default:
+		return "Error changing your " + what + ": " + err.Error()
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStatus(t *testing.T) {
+	now := time.Unix(0, 0)
+	for args, want := range map[string]SlackStatus{
+		"":                                       {},
+		"lunch":                                  {Text: "lunch"},
+		":taco:":                                 {Emoji: ":taco:"},
+		"2d":                                     {}, // no status, so nothing to expire
+		"1x :a: b":                               {Text: "1x :a: b"},
+		"2d :palm_tree:":                         {Emoji: ":palm_tree:", Expiration: now.Add(48 * time.Hour)},
+		"1h :spiral_calendar_pad: In a  meeting": {Emoji: ":spiral_calendar_pad:", Text: "In a meeting", Expiration: now.Add(time.Hour)},
+	} {
+		if got := parseStatus(args, now); got.Emoji != want.Emoji || got.Text != want.Text || !got.Expiration.Equal(want.Expiration) {
+			t.Errorf("expected %+v for %q, got %+v", want, args, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const searchCommand = "/search"
+
+// searchContextWindow is how far before and after a search result, or pin, messages are shown, when jumping to it.
+const searchContextWindow = time.Hour
+
+// searchContextNewer is the most messages newer than a search result, or pin, shown when jumping to it, so it's on screen.
+const searchContextNewer = 5
+
+// searchResults are the results shown in the search view.
+type searchResults struct {
+	Query   string
+	Total   int
+	Matches []SlackSearchMatch
+	Names   []string // the name of each match's conversation, as shown in the channels view
+}
+
+func searchTitle(results *searchResults) string {
+	return fmt.Sprintf("Search: %s (%d of %d, Enter jumps, q or Esc closes)", results.Query, len(results.Matches), results.Total)
+}
+
+// searchResultText returns the line shown for a search match: its channel, author, time, and text.
+func searchResultText(match SlackSearchMatch, channelName string) string {
+	text := strings.Replace(strings.TrimSpace(match.Text), "\n", " ", -1)
+	return fmt.Sprintf("%-20s %-16s %s %s", channelName, match.Username, faint(tsTime(match.Time).Format("2006-01-02 15:04")), text)
+}
+
+// tsTime returns the time of a Slack ts, e.g. 1400000000.000100.
+func tsTime(ts string) time.Time {
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), int64((seconds-float64(int64(seconds)))*1e9))
+}
+
+// addTs returns the Slack ts d after ts.
+func addTs(ts string, d time.Duration) string {
+	seconds, err := strconv.ParseFloat(ts, 64)
+	if err != nil {
+		return ts
+	}
+	return strconv.FormatFloat(seconds+d.Seconds(), 'f', 6, 64)
+}
+
+// search searches for messages matching the query in args, and shows the results in the search view.
+func search(g *gocui.Gui, env commandEnv, args string) error {
+	if args == "" {
+		setStatus(g, "Usage: "+searchCommand+" query, e.g. "+searchCommand+" deploy in:#ops from:@alice before:2024-01-31")
+		return nil
+	}
+	setStatus(g, "Searching for "+args)
+	go func() {
+		found, err := env.client.SearchSlackMessages(env.ctx, args)
+		if err != nil {
+			log.Println("error searching for " + args + ": " + err.Error())
+			setStatus(g, searchErrorText(err))
+			return
+		}
+		results := &searchResults{Query: args, Total: found.Total, Matches: found.Matches}
+		for _, match := range found.Matches {
+			results.Names = append(results.Names, searchChannelName(match.Channel, env.chans))
+		}
+		g.Execute(func(g *gocui.Gui) error {
+			env.state.search = results
+			return openSearch(g, env.state)
+		})
+	}()
+	return nil
+}
+
+// searchChannelName returns the name of the match's conversation as shown in the channels view, e.g. @alice for IMs.
+func searchChannelName(channel SlackSearchChannel, chans GuiChans) string {
+	if name := GetChannelName(channel.Id, chans.GetChannelName); name != "" {
+		return name
+	}
+	return channel.Name
+}
+
+func searchErrorText(err error) string {
+	switch SlackErrorCode(err) {
+	case "not_allowed_token_type":
+		return "Search needs a user token. Bot tokens can't search messages"
+	case "missing_scope":
+		return "Your Slack token doesn't have permission to search"
+	default:
+		return "Error searching: " + err.Error()
+	}
+}
+
+func layoutSearch(g *gocui.Gui, state *guiState, x0, y0, x1, y1 int) error {
+	if state.search == nil {
+		if err := g.DeleteView("search"); err != nil && err != gocui.ErrUnknownView {
+			return err
+		}
+		return nil
+	}
+	if v, err := g.SetView("search", x0, y0, x1, y1); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Highlight = true
+		v.SelFgColor = gocui.AttrReverse
+		v.SelBgColor = gocui.AttrReverse
+	}
+	return nil
+}
+
+func openSearch(g *gocui.Gui, state *guiState) error {
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	v, err := g.View("search")
+	if err != nil {
+		return err
+	}
+	v.Clear()
+	v.SetOrigin(0, 0)
+	v.SetCursor(0, 0)
+	v.Title = searchTitle(state.search)
+	if len(state.search.Matches) == 0 {
+		fmt.Fprintln(v, "No messages found.")
+	}
+	for i, match := range state.search.Matches {
+		fmt.Fprintln(v, searchResultText(match, state.search.Names[i]))
+	}
+	g.Cursor = false
+	return g.SetCurrentView("search")
+}
+
+func closeSearch(g *gocui.Gui, state *guiState) error {
+	if state.search == nil {
+		return nil
+	}
+	state.search = nil
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	if err := g.SetCurrentView("messages"); err != nil {
+		return err
+	}
+	g.CurrentView().Highlight = true
+	return nil
+}
+
+// selectedSearchMatch returns the index of the match under the search view's cursor.
+func selectedSearchMatch(v *gocui.View, state *guiState) (int, bool) {
+	_, oy := v.Origin()
+	_, cy := v.Cursor()
+	i := oy + cy
+	if state.search == nil || i < 0 || i >= len(state.search.Matches) {
+		return 0, false
+	}
+	return i, true
+}
+
+// selectChannelLine moves the channels view cursor to the channel with the given id, and returns whether it was found.
+func selectChannelLine(g *gocui.Gui, state *guiState, channelId string) bool {
+	v, err := g.View("channels")
+	if err != nil {
+		return false
+	}
+	_, height := v.Size()
+	for i, channel := range state.channels {
+		if channel.Id != channelId {
+			continue
+		}
+		if i < height {
+			v.SetOrigin(0, 0)
+			v.SetCursor(0, i)
+		} else {
+			v.SetOrigin(0, i-height+1)
+			v.SetCursor(0, height-1)
+		}
+		return true
+	}
+	return false
+}
+
+// jumpToSearchMatch closes the search view, and shows the messages around the selected match.
+func jumpToSearchMatch(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	i, ok := selectedSearchMatch(v, env.state)
+	if !ok {
+		return nil
+	}
+	match, name := env.state.search.Matches[i], env.state.search.Names[i]
+	if err := closeSearch(g, env.state); err != nil {
+		return err
+	}
+	return jumpToMessage(g, env, match.Channel.Id, name, match.Time)
+}
+
+// jumpToMessage selects the channel with the given id and name, and shows the messages around the message with the
+// given ts, with it selected. The newest messages are shown again when the channel is selected.
+func jumpToMessage(g *gocui.Gui, env commandEnv, channelId, channelName, ts string) error {
+	if !selectChannelLine(g, env.state, channelId) {
+		setStatus(g, "Channel "+channelName+" isn't in the channel list")
+		return nil
+	}
+	setStatus(g, "Loading messages around the message")
+	go func() {
+		msgs, err := env.client.GetSlackMessages(env.ctx, channelId, addTs(ts, -searchContextWindow), addTs(ts, searchContextWindow))
+		if err != nil {
+			log.Println("error getting message context " + channelId + " " + ts + ": " + err.Error())
+			setStatus(g, messagesErrorText(err))
+			return
+		}
+		termMsgs := slackMessagesToTermMsgs(msgs, env.chans.GetUserName)
+		for i, msg := range termMsgs { // newest first, so skip all but the nearest newer messages
+			if msg.Time == ts {
+				if i > searchContextNewer {
+					termMsgs = termMsgs[i-searchContextNewer:]
+				}
+				break
+			}
+		}
+		g.Execute(func(g *gocui.Gui) error {
+			return showMessageContext(g, channelId, ts, termMsgs, env.chans, env.state)
+		})
+	}()
+	return nil
+}
+
+// showMessageContext shows msgs in the messages view, with the message with the given ts selected.
+func showMessageContext(g *gocui.Gui, channelId, ts string, msgs []TermMsg, chans GuiChans, state *guiState) error {
+	v, err := g.View("messages")
+	if err != nil {
+		return err
+	}
+	vn, err := g.View("messages-names")
+	if err != nil {
+		return err
+	}
+	state.channelId = channelId
+	state.contextTs = ts
+	renderMessages(v, vn, msgs, chans, state)
+	if err := renderTyping(g, state); err != nil {
+		return err
+	}
+	for y, msg := range state.msgLines {
+		if msg.Time == ts {
+			v.SetCursor(0, y)
+			break
+		}
+	}
+	if err := g.SetCurrentView("messages"); err != nil {
+		return err
+	}
+	v.Highlight = true
+	setStatus(g, "Showing the messages around the message. Enter in the channels view shows the newest")
+	return nil
+}
+
+func setSearchKeybindings(g *gocui.Gui, env commandEnv) {
+	for _, key := range []gocui.Key{gocui.KeyArrowDown, gocui.KeyCtrlN} {
+		if err := g.SetKeybinding("search", key, gocui.ModNone, cursorDown); err != nil {
+			log.Panicln(err)
+		}
+	}
+	for _, key := range []gocui.Key{gocui.KeyArrowUp, gocui.KeyCtrlP} {
+		if err := g.SetKeybinding("search", key, gocui.ModNone, cursorUp); err != nil {
+			log.Panicln(err)
+		}
+	}
+	if err := g.SetKeybinding("search", gocui.KeyEnter, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return jumpToSearchMatch(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("search", 'q', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return closeSearch(g, env.state)
+	}); err != nil {
+		log.Panicln(err)
+	}
+	for _, view := range []string{"channels", "messages"} {
+		if err := g.SetKeybinding(view, '/', gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+			return startInput(g, v, searchCommand+" ")
+		}); err != nil {
+			log.Panicln(err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jroimartin/gocui"
+)
+
+// renderedColors writes text to a gocui view, and returns the foreground attribute gocui gives each rune it prints.
+func renderedColors(t *testing.T, text string) []gocui.Attribute {
+	t.Helper()
+	v, err := gocui.NewGui().SetView("test", 0, 0, 100, 10)
+	if err != gocui.ErrUnknownView {
+		t.Fatal(err)
+	}
+	if _, err := v.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	// gocui doesn't export the cells it draws, so read them reflectively
+	colors := []gocui.Attribute{}
+	lines := reflect.ValueOf(v).Elem().FieldByName("lines")
+	for y := 0; y < lines.Len(); y++ {
+		for x := 0; x < lines.Index(y).Len(); x++ {
+			colors = append(colors, gocui.Attribute(lines.Index(y).Index(x).FieldByName("fgColor").Uint()))
+		}
+	}
+	return colors
+}
+
+func TestMessageBodyFaint(t *testing.T) {
+	for _, c := range []struct {
+		msg   TermMsg
+		faint bool
+	}{
+		{TermMsg{Text: "hello"}, false},
+		{TermMsg{Text: "<@U1> has joined the channel", Subtype: "channel_join"}, true},
+		{TermMsg{Text: "set the topic", Subtype: "channel_topic", Files: []TermFile{{SlackFile: SlackFile{Name: "a.txt"}}}}, true},
+		{TermMsg{Text: "on its way", Sending: true, Files: []TermFile{{SlackFile: SlackFile{Name: "a.txt"}}}}, true},
+	} {
+		body := messageBody(c.msg)
+		colors := renderedColors(t, body)
+		if len(colors) != textWidth(body) {
+			t.Fatalf("expected %q printed in %d cells, got %d", body, textWidth(body), len(colors))
+		}
+		for i, color := range colors {
+			if isFaint := color == gocui.ColorBlue; isFaint != c.faint {
+				t.Fatalf("expected %q faint %v, got rune %d color %v", body, c.faint, i, color)
+			}
+		}
+	}
+}
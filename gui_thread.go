@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"log"
+	"strings"
+)
+
+// threadWidthPercent is the percent of the message area the thread view takes, when a thread is open.
+const threadWidthPercent = 40
+
+const threadTitle = "Thread (Esc closes, " + broadcastCommand + "also sends to channel)"
+
+func layoutThread(g *gocui.Gui, x0, y0, x1, y1 int) error {
+	if v, err := g.SetView("thread", x0, y0, x1, y1); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = threadTitle
+		v.Wrap = true
+		v.Autoscroll = true
+	}
+	return nil
+}
+
+// populateThread writes the open thread's parent and replies to the thread view. The thread is loaded in the
+// background, because it may not be cached, and is rendered if it's still open, and no newer load started.
+func populateThread(g *gocui.Gui, chans GuiChans, state *guiState) error {
+	if state.thread == nil {
+		return nil
+	}
+	thread := *state.thread
+	state.threadLoad++
+	load := state.threadLoad
+	go func() {
+		msgs, err := GetThread(thread.ChannelId, thread.ThreadTs, chans.GetThread)
+		g.Execute(func(g *gocui.Gui) error {
+			if load != state.threadLoad || state.thread == nil || *state.thread != thread {
+				return nil
+			}
+			v, verr := g.View("thread")
+			if verr != nil {
+				return verr
+			}
+			v.Clear()
+			if err != nil {
+				fmt.Fprintln(v, "Error loading thread: "+err.Error())
+				return nil
+			}
+			for i, msg := range msgs {
+				fmt.Fprintln(v, consistentHashColorName(displayName(msg))+": "+messageBody(msg))
+				if reactions := reactionsText(msg.Reactions); reactions != "" {
+					fmt.Fprintln(v, reactions)
+				}
+				if i == 0 && len(msgs) > 1 {
+					fmt.Fprintln(v, faint(strings.Repeat("-", 10)))
+				}
+			}
+			return nil
+		})
+	}()
+	return nil
+}
+
+// openSelectedThread opens the thread view for the selected message, starting a new thread if it has no replies.
+// Messages typed into the input are then sent as replies, until the thread is closed.
+func openSelectedThread(g *gocui.Gui, v *gocui.View, chans GuiChans, state *guiState) error {
+	msg, ok := selectedMessage(g, state)
+	if !ok {
+		return nil
+	}
+	threadTs := msg.ThreadTs
+	if threadTs == "" {
+		threadTs = msg.Time
+	}
+	log.Println("openSelectedThread " + state.channelId + " " + threadTs)
+	state.thread = &openThread{ChannelId: state.channelId, ThreadTs: threadTs}
+	if err := layout(g, state); err != nil {
+		return err
+	}
+	tv, err := g.View("thread")
+	if err != nil {
+		return err
+	}
+	tv.Clear()
+	fmt.Fprintln(tv, "Loading Thread...")
+	return populateThread(g, chans, state)
+}
+
+func closeThread(g *gocui.Gui, v *gocui.View, state *guiState) error {
+	if state.thread == nil {
+		return nil
+	}
+	state.thread = nil
+	return layout(g, state)
+}
+
+func setThreadKeybindings(g *gocui.Gui, chans GuiChans, state *guiState) {
+	openThread := func(g *gocui.Gui, v *gocui.View) error {
+		return openSelectedThread(g, v, chans, state)
+	}
+	if err := g.SetKeybinding("messages", gocui.KeyEnter, gocui.ModNone, openThread); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("messages", 't', gocui.ModNone, openThread); err != nil {
+		log.Panicln(err)
+	}
+}
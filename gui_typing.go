@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"sort"
+	"strings"
+	"time"
+)
+
+// typingDuration is how long a user is shown typing after their last user_typing event. Slack sends them every few
+// seconds while the user types.
+const typingDuration = 5 * time.Second
+
+type typingKey struct {
+	ChannelId string
+	UserId    string
+}
+
+// typingUser is a user shown typing in a channel.
+type typingUser struct {
+	Name  string
+	Until time.Time // when the user is no longer shown typing
+}
+
+// typingText returns the line shown above the input while the users with the given names are typing, or the empty
+// string if none are.
+func typingText(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return faint(names[0] + " is typing…")
+	case 2:
+		return faint(names[0] + " and " + names[1] + " are typing…")
+	default:
+		return faint("Several people are typing…")
+	}
+}
+
+// showTyping shows the user of the user_typing event, whose name is given, typing, until typingDuration passes
+// without another.
+func showTyping(g *gocui.Gui, env commandEnv, typing SlackRtmUserTyping, name string) error {
+	if env.state.typing == nil {
+		env.state.typing = make(map[typingKey]typingUser)
+	}
+	env.state.typing[typingKey{typing.ChannelId, typing.UserId}] = typingUser{Name: name, Until: time.Now().Add(typingDuration)}
+	time.AfterFunc(typingDuration, func() {
+		g.Execute(func(g *gocui.Gui) error {
+			return renderTyping(g, env.state)
+		})
+	})
+	return renderTyping(g, env.state)
+}
+
+// renderTyping writes who is typing in the shown channel to the typing view, forgetting those who stopped.
+func renderTyping(g *gocui.Gui, state *guiState) error {
+	v, err := g.View("typing")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var names []string
+	for key, user := range state.typing {
+		if now.After(user.Until) {
+			delete(state.typing, key)
+			continue
+		}
+		if key.ChannelId == state.channelId {
+			names = append(names, user.Name)
+		}
+	}
+	sort.Strings(names)
+	v.Clear()
+	fmt.Fprint(v, typingText(names))
+	return nil
+}
+
+// typingEditor returns an editor which edits like gocui's, and tells the RTM handler when a message is typed into the
+// input, so Slack shows the user typing in the shown channel. Commands, edits, and snippets aren't announced, nor are
+// keys which don't change the text, like arrows.
+func typingEditor(env commandEnv) gocui.Editor {
+	return gocui.EditorFunc(func(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+		before := v.Buffer()
+		gocui.DefaultEditor.Edit(v, key, ch, mod)
+		state := env.state
+		if v.Name() != "input" || key == gocui.KeyEnter || state.editing != nil || state.snippet != nil {
+			return
+		}
+		buffer := v.Buffer()
+		if buffer == before {
+			return
+		}
+		text := strings.TrimSpace(buffer)
+		if text == "" || strings.HasPrefix(text, "/") && !strings.HasPrefix(text, broadcastCommand) {
+			return
+		}
+		channelId := state.channelId
+		if state.thread != nil {
+			channelId = state.thread.ChannelId
+		}
+		if channelId == "" {
+			return
+		}
+		select {
+		case env.chans.Typing <- channelId:
+		default: // the RTM handler is busy, and typing is sent every few seconds anyway
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jroimartin/gocui"
+)
+
+func TestTypingText(t *testing.T) {
+	for _, c := range []struct {
+		names []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"alice"}, "alice is typing…"},
+		{[]string{"alice", "bob"}, "alice and bob are typing…"},
+		{[]string{"alice", "bob", "carol"}, "Several people are typing…"},
+	} {
+		if got := ansiEscape.ReplaceAllString(typingText(c.names), ""); got != c.want {
+			t.Fatalf("expected %v to be %q, got %q", c.names, c.want, got)
+		}
+	}
+}
+
+func TestRenderTyping(t *testing.T) {
+	g := gocui.NewGui()
+	v, err := g.SetView("typing", 0, 0, 100, 2)
+	if err != gocui.ErrUnknownView {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	state := &guiState{channelId: "C1", typing: map[typingKey]typingUser{
+		{"C1", "U1"}: {Name: "alice", Until: now.Add(time.Minute)},
+		{"C1", "U2"}: {Name: "bob", Until: now.Add(-time.Second)},
+		{"C2", "U3"}: {Name: "carol", Until: now.Add(time.Minute)},
+	}}
+	if err := renderTyping(g, state); err != nil {
+		t.Fatal(err)
+	}
+	if text := strings.TrimSpace(v.Buffer()); text != "alice is typing…" {
+		t.Fatalf("expected only alice typing in the shown channel, got %q", text)
+	}
+	if _, ok := state.typing[typingKey{"C1", "U2"}]; ok || len(state.typing) != 2 {
+		t.Fatalf("expected bob, who stopped typing, forgotten, got %v", state.typing)
+	}
+}
+
+func TestTypingEditor(t *testing.T) {
+	v, err := gocui.NewGui().SetView("input", 0, 0, 100, 2)
+	if err != gocui.ErrUnknownView {
+		t.Fatal(err)
+	}
+	v.Editable = true
+	typing := make(chan string, 10)
+	editor := typingEditor(commandEnv{chans: GuiChans{Typing: typing}, state: &guiState{channelId: "C1"}})
+
+	editor.Edit(v, 0, 'h', gocui.ModNone)
+	editor.Edit(v, gocui.KeyArrowLeft, 0, gocui.ModNone)
+	editor.Edit(v, gocui.KeyArrowRight, 0, gocui.ModNone)
+	editor.Edit(v, 0, 'i', gocui.ModNone)
+	if len(typing) != 2 || <-typing != "C1" {
+		t.Fatalf("expected typing sent for each change of the text, not cursor moves, got %d", len(typing))
+	}
+}
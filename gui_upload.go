@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jroimartin/gocui"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	uploadCommand  = "/upload"
+	snippetCommand = "/snippet"
+)
+
+// snippetHeight is the height of the input while a snippet is being written.
+const snippetHeight = 12
+
+const snippetTitle = "Snippet (Enter adds a line, C-s uploads, Esc cancels)"
+
+// snippet is a snippet being written in the input.
+type snippet struct {
+	Title string
+}
+
+// uploadTarget returns the conversation and thread files are shared in: the open thread, or else the selected channel.
+func uploadTarget(state *guiState) (channelId string, threadTs string) {
+	if state.thread != nil {
+		return state.thread.ChannelId, state.thread.ThreadTs
+	}
+	return state.channelId, ""
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// uploadFile uploads the file at the path in args, followed by an optional comment, e.g. "/upload ~/crash.log the crash".
+func uploadFile(g *gocui.Gui, env commandEnv, args string) error {
+	if args == "" {
+		setStatus(g, "Usage: "+uploadCommand+" path [comment]")
+		return nil
+	}
+	path, comment := args, ""
+	if i := strings.Index(args, " "); i >= 0 {
+		path, comment = args[:i], strings.TrimSpace(args[i+1:])
+	}
+	channelId, threadTs := uploadTarget(env.state)
+	if channelId == "" {
+		setStatus(g, "Select a channel first")
+		return nil
+	}
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		setStatus(g, "Error opening file: "+err.Error())
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		setStatus(g, "Error opening file: "+err.Error())
+		return nil
+	}
+	if info.IsDir() {
+		f.Close()
+		setStatus(g, path+" is a directory")
+		return nil
+	}
+	upload := SlackUpload{
+		ChannelId: channelId,
+		ThreadTs:  threadTs,
+		Filename:  filepath.Base(path),
+		Comment:   comment,
+		Content:   f,
+		Size:      info.Size(),
+	}
+	go func() {
+		defer f.Close()
+		startUpload(g, env, upload)
+	}()
+	return nil
+}
+
+// startSnippet makes the input a multi-line editor, whose text is uploaded as a snippet with C-s.
+func startSnippet(g *gocui.Gui, env commandEnv, args string) error {
+	if channelId, _ := uploadTarget(env.state); channelId == "" {
+		setStatus(g, "Select a channel first")
+		return nil
+	}
+	env.state.snippet = &snippet{Title: args}
+	if err := layout(g, env.state); err != nil {
+		return err
+	}
+	v, err := g.View("input")
+	if err != nil {
+		return err
+	}
+	v.Title = snippetTitle
+	return nil
+}
+
+// endSnippet returns the input to a single line, clearing it.
+func endSnippet(g *gocui.Gui, state *guiState) error {
+	state.snippet = nil
+	v, err := g.View("input")
+	if err != nil {
+		return err
+	}
+	if v.Title == snippetTitle {
+		v.Title = ""
+	}
+	clearInput(v)
+	return layout(g, state)
+}
+
+// uploadSnippet uploads the snippet in the input.
+func uploadSnippet(g *gocui.Gui, v *gocui.View, env commandEnv) error {
+	if env.state.snippet == nil {
+		return nil
+	}
+	title := env.state.snippet.Title
+	content := strings.TrimRight(v.Buffer(), " \n\t") + "\n"
+	if err := endSnippet(g, env.state); err != nil {
+		return err
+	}
+	if strings.TrimSpace(content) == "" {
+		setStatus(g, "Snippet is empty")
+		return nil
+	}
+	channelId, threadTs := uploadTarget(env.state)
+	filename := "snippet.txt"
+	if title != "" {
+		filename = title
+	}
+	upload := SlackUpload{
+		ChannelId:   channelId,
+		ThreadTs:    threadTs,
+		Filename:    filename,
+		Title:       title,
+		SnippetType: "text",
+		Content:     strings.NewReader(content),
+		Size:        int64(len(content)),
+	}
+	go startUpload(g, env, upload)
+	return nil
+}
+
+// startUpload uploads the file, showing its progress and result in the status. It must be called in a new goroutine.
+func startUpload(g *gocui.Gui, env commandEnv, upload SlackUpload) {
+	var mutex sync.Mutex
+	lastPercent := -1
+	upload.Progress = func(sent int64) {
+		percent := 100
+		if upload.Size > 0 {
+			percent = int(sent * 100 / upload.Size)
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if percent/10 == lastPercent/10 {
+			return // only show every 10%, to not flood the GUI
+		}
+		lastPercent = percent
+		setStatus(g, fmt.Sprintf("Uploading %s: %d%%", upload.Filename, percent))
+	}
+	setStatus(g, "Uploading "+upload.Filename)
+	file, err := env.client.UploadSlackFile(env.ctx, upload)
+	if err != nil {
+		log.Println("error uploading " + upload.Filename + ": " + err.Error())
+		setStatus(g, uploadErrorText(err))
+		return
+	}
+	setStatus(g, "Uploaded "+fileName(file))
+}
+
+func uploadErrorText(err error) string {
+	switch SlackErrorCode(err) {
+	case "not_in_channel":
+		return "You are not a member of this channel"
+	case "file_too_large", "request_too_large":
+		return "The file is too large to upload"
+	case "missing_scope":
+		return "Your Slack token doesn't have permission to upload files"
+	default:
+		return "Error uploading: " + err.Error()
+	}
+}
+
+func setUploadKeybindings(g *gocui.Gui, env commandEnv) {
+	if err := g.SetKeybinding("input", gocui.KeyCtrlS, gocui.ModNone, func(g *gocui.Gui, v *gocui.View) error {
+		return uploadSnippet(g, v, env)
+	}); err != nil {
+		log.Panicln(err)
+	}
+}
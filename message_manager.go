@@ -1,82 +1,531 @@
 package main
 
 import (
+	"context"
 	//	"fmt"
 	"log"
+	"strconv"
 )
 
 type TermMsg struct {
-	UserName string
-	Text     string
+	Time       string // the Slack ts, which identifies the message within its channel
+	UserId     string
+	UserName   string
+	Text       string
+	ThreadTs   string // the ts of the thread parent, if this is a thread parent or reply
+	ReplyCount int    // the number of replies, if this is a thread parent
+	Reactions  []SlackReaction
+	Edited     bool
+	Subtype    string // e.g. channel_join or bot_message, or the empty string for normal messages
+	Icon       string // the emoji name of the bot's icon, if this is a bot message
+	Files      []TermFile
+	Pinned     bool
+	Starred    bool
+	OutboxId   int    // the outbox id of a message sent from user input which isn't acked yet, and so has no ts
+	Sending    bool   // whether the unacked message is being sent
+	SendError  string // why sending the unacked message failed, if it did
+}
+
+// TermFile is a file shared in a message.
+type TermFile struct {
+	SlackFile
+	UserName string // the name of the user who uploaded the file
+}
+
+// MessageChange changes the stored message with the given ts, in its channel and any loaded thread, or deletes it.
+// Change must not modify slices in the message it's given, which may have been sent to the GUI, only replace them.
+type MessageChange struct {
+	ChannelId string
+	Time      string
+	Change    func(msg *TermMsg)
+	Delete    bool
+	ThreadTs  string // the ts of the thread parent, if a deleted message is a thread reply, whose reply count is decremented
 }
 
 type MessageRequest struct {
 	ChannelId string
-	Reply     chan<- []TermMsg
+	Reply     chan<- MessagesReply
 }
 
-func GetMessages(channelId string, getChan chan<- MessageRequest) []TermMsg {
-	replyChan := make(chan []TermMsg)
-	getChan <- MessageRequest{channelId, replyChan}
+// ThreadRequest requests a thread parent and its replies, oldest first.
+type ThreadRequest struct {
+	ChannelId string
+	ThreadTs  string
+	Reply     chan<- MessagesReply
+}
+
+// MessagesReply is the reply to a MessageRequest or ThreadRequest. If the messages couldn't be loaded, Err is the error, typically an *ApiError.
+type MessagesReply struct {
+	Msgs []TermMsg
+	Err  error
+}
+
+// NewestMessagesRequest requests the ts of the newest message of each loaded channel, by channel id. Channels loaded
+// without messages have the empty string.
+type NewestMessagesRequest struct {
+	Reply chan<- map[string]string
+}
+
+// ChannelHistory is messages from the history of a channel, e.g. those missed while the RTM websocket was
+// disconnected, which are added to the stored messages. Messages already stored are skipped, except that thread
+// parents get the history's reply count.
+type ChannelHistory struct {
+	ChannelId string
+	Msgs      []SlackMessage
+}
+
+// OutboxMsg is a message sent from user input, which is shown while it's sent, and if sending it failed. Unacked
+// messages are identified by Id, and stored before the newest message of the channel, or after the newest reply of
+// the thread. Once Time is set, Slack acked it, and it's stored like any other message.
+type OutboxMsg struct {
+	Id int
+	PutRtmMsg
+	UserId  string
+	Time    string // the ts Slack gave the message, once it's acked
+	Err     string // why sending failed, if it did
+	Discard bool   // whether the failed message was discarded, and is removed
+}
+
+// rtmMessage returns the acked message as if it were received from RTM.
+func (o OutboxMsg) rtmMessage() SlackRtmMessage {
+	msg := SlackRtmMessage{Type: "message", ChannelId: o.ChannelId, UserId: o.UserId, Text: o.Msg, Time: o.Time, ThreadTs: o.ThreadTs}
+	if o.Broadcast {
+		msg.Subtype = `thread_broadcast`
+	}
+	return msg
+}
+
+// GetNewestMessages returns the ts of the newest message of each loaded channel.
+func GetNewestMessages(getNewestChan chan<- NewestMessagesRequest) map[string]string {
+	replyChan := make(chan map[string]string)
+	getNewestChan <- NewestMessagesRequest{replyChan}
 	return <-replyChan
 }
 
+func GetMessages(channelId string, getChan chan<- MessageRequest) ([]TermMsg, error) {
+	replyChan := make(chan MessagesReply)
+	getChan <- MessageRequest{channelId, replyChan}
+	reply := <-replyChan
+	return reply.Msgs, reply.Err
+}
+
+// GetThread returns the parent of the given thread, followed by its replies.
+func GetThread(channelId, threadTs string, getThreadChan chan<- ThreadRequest) ([]TermMsg, error) {
+	replyChan := make(chan MessagesReply)
+	getThreadChan <- ThreadRequest{channelId, threadTs, replyChan}
+	reply := <-replyChan
+	return reply.Msgs, reply.Err
+}
+
 // TODO(Remove and write to chan directly?
 func PutMessage(msg SlackRtmMessage, putChan chan<- SlackRtmMessage) {
 	putChan <- msg
 }
 
+// messageUserName returns the name shown for a message: the bot's name if it's from a bot, else the user's name.
+func messageUserName(userId string, bot SlackBotInfo, getUserNameChan chan<- UserNameRequest) string {
+	if name := bot.BotName(); name != "" && (userId == "" || bot.Username != "") {
+		return name
+	}
+	if userId == "" {
+		return ""
+	}
+	return GetUserName(userId, getUserNameChan)
+}
+
+func slackMessageToTermMsg(msg SlackMessage, getUserNameChan chan<- UserNameRequest) TermMsg {
+	return TermMsg{
+		Time:       msg.Time,
+		UserId:     msg.User,
+		UserName:   messageUserName(msg.User, msg.SlackBotInfo, getUserNameChan),
+		Text:       msg.Text,
+		ThreadTs:   msg.ThreadTs,
+		ReplyCount: msg.ReplyCount,
+		Reactions:  msg.Reactions,
+		Edited:     msg.Edited != nil,
+		Subtype:    msg.Subtype,
+		Icon:       msg.BotIcon(),
+		Files:      slackFilesToTermFiles(msg.Files, getUserNameChan),
+		Pinned:     len(msg.PinnedTo) > 0,
+		Starred:    msg.Starred,
+	}
+}
+
+func slackFilesToTermFiles(files []SlackFile, getUserNameChan chan<- UserNameRequest) []TermFile {
+	var termFiles []TermFile
+	for _, file := range files {
+		termFile := TermFile{SlackFile: file}
+		if file.User != "" {
+			termFile.UserName = GetUserName(file.User, getUserNameChan)
+		}
+		termFiles = append(termFiles, termFile)
+	}
+	return termFiles
+}
+
 // TODO(generic map pattern?)
 func slackMessagesToTermMsgs(msgs []SlackMessage, getUserNameChan chan<- UserNameRequest) []TermMsg {
 	var newmsgs []TermMsg
 	for _, msg := range msgs {
-		newmsgs = append(newmsgs, TermMsg{UserName: GetUserName(msg.User, getUserNameChan), Text: msg.Text})
+		newmsgs = append(newmsgs, slackMessageToTermMsg(msg, getUserNameChan))
 	}
 	return newmsgs
 }
 
-func messagesManager(token string, get <-chan MessageRequest, put <-chan SlackRtmMessage, getUserNameChan chan<- UserNameRequest) {
+func rtmMessageToTermMsg(msg SlackRtmMessage, getUserNameChan chan<- UserNameRequest) TermMsg {
+	return TermMsg{
+		Time:     msg.Time,
+		UserId:   msg.UserId,
+		UserName: messageUserName(msg.UserId, msg.SlackBotInfo, getUserNameChan),
+		Text:     msg.Text,
+		ThreadTs: msg.ThreadTs,
+		Edited:   msg.Edited != nil,
+		Subtype:  msg.Subtype,
+		Icon:     msg.BotIcon(),
+		Files:    slackFilesToTermFiles(msg.Files, getUserNameChan),
+	}
+}
+
+func outboxMsgToTermMsg(o OutboxMsg, getUserNameChan chan<- UserNameRequest) TermMsg {
+	msg := rtmMessageToTermMsg(o.rtmMessage(), getUserNameChan)
+	msg.OutboxId = o.Id
+	msg.Sending = o.Err == ""
+	msg.SendError = o.Err
+	return msg
+}
+
+// updateMessage returns a copy of msgs with update applied to the message with the given ts, and whether it was found.
+// msgs is copied, rather than modified, because it may have been sent to the GUI.
+func updateMessage(msgs []TermMsg, ts string, update func(msg *TermMsg)) ([]TermMsg, bool) {
+	for i := range msgs {
+		if msgs[i].Time != ts {
+			continue
+		}
+		newmsgs := append([]TermMsg(nil), msgs...)
+		update(&newmsgs[i])
+		return newmsgs, true
+	}
+	return msgs, false
+}
+
+// tsLess returns whether the Slack ts a is before b.
+func tsLess(a, b string) bool {
+	af, _ := strconv.ParseFloat(a, 64)
+	bf, _ := strconv.ParseFloat(b, 64)
+	return af < bf
+}
+
+// hasMessage returns whether msgs has the message with the given ts.
+func hasMessage(msgs []TermMsg, ts string) bool {
+	for _, msg := range msgs {
+		if msg.Time == ts {
+			return true
+		}
+	}
+	return false
+}
+
+// insertMessage returns a copy of msgs, which are newest first, with msg inserted in order, after any unacked messages.
+func insertMessage(msgs []TermMsg, msg TermMsg) []TermMsg {
+	i := 0
+	for i < len(msgs) && (msgs[i].OutboxId != 0 || tsLess(msg.Time, msgs[i].Time)) {
+		i++
+	}
+	newmsgs := make([]TermMsg, 0, len(msgs)+1)
+	newmsgs = append(append(append(newmsgs, msgs[:i]...), msg), msgs[i:]...)
+	return newmsgs
+}
+
+// appendReply returns a copy of replies, which are oldest first, with msg added after the newest, before any unacked
+// replies.
+func appendReply(replies []TermMsg, msg TermMsg) []TermMsg {
+	i := len(replies)
+	for i > 0 && replies[i-1].OutboxId != 0 {
+		i--
+	}
+	newreplies := make([]TermMsg, 0, len(replies)+1)
+	return append(append(append(newreplies, replies[:i]...), msg), replies[i:]...)
+}
+
+// deleteMessage returns a copy of msgs without the message with the given ts, and whether it was found.
+func deleteMessage(msgs []TermMsg, ts string) ([]TermMsg, bool) {
+	for i := range msgs {
+		if msgs[i].Time != ts {
+			continue
+		}
+		newmsgs := append([]TermMsg(nil), msgs[:i]...)
+		return append(newmsgs, msgs[i+1:]...), true
+	}
+	return msgs, false
+}
+
+// putOutboxMessage returns a copy of msgs with the unacked message msg replacing the one with its outbox id, or added
+// first if newestFirst, else last.
+func putOutboxMessage(msgs []TermMsg, msg TermMsg, newestFirst bool) []TermMsg {
+	for i := range msgs {
+		if msgs[i].OutboxId == msg.OutboxId {
+			newmsgs := append([]TermMsg(nil), msgs...)
+			newmsgs[i] = msg
+			return newmsgs
+		}
+	}
+	if newestFirst {
+		return append([]TermMsg{msg}, msgs...)
+	}
+	return append(append([]TermMsg(nil), msgs...), msg)
+}
+
+// removeOutboxMessage returns a copy of msgs without the unacked message with the given outbox id, and whether it was found.
+func removeOutboxMessage(msgs []TermMsg, id int) ([]TermMsg, bool) {
+	for i := range msgs {
+		if msgs[i].OutboxId != id {
+			continue
+		}
+		newmsgs := append([]TermMsg(nil), msgs[:i]...)
+		return append(newmsgs, msgs[i+1:]...), true
+	}
+	return msgs, false
+}
+
+// addReaction returns a copy of reactions with the given user's named reaction added.
+func addReaction(reactions []SlackReaction, name, userId string) []SlackReaction {
+	for i, r := range reactions {
+		if r.Name != name {
+			continue
+		}
+		for _, u := range r.Users {
+			if u == userId {
+				return reactions
+			}
+		}
+		newreactions := append([]SlackReaction(nil), reactions...)
+		newreactions[i].Count++
+		newreactions[i].Users = append(append([]string(nil), r.Users...), userId)
+		return newreactions
+	}
+	return append(append([]SlackReaction(nil), reactions...), SlackReaction{Name: name, Count: 1, Users: []string{userId}})
+}
+
+// removeReaction returns a copy of reactions with the given user's named reaction removed.
+func removeReaction(reactions []SlackReaction, name, userId string) []SlackReaction {
+	newreactions := []SlackReaction{}
+	for _, r := range reactions {
+		if r.Name == name {
+			users := []string{}
+			for _, u := range r.Users {
+				if u != userId {
+					users = append(users, u)
+				}
+			}
+			r.Count -= len(r.Users) - len(users)
+			r.Users = users
+			if r.Count <= 0 {
+				continue
+			}
+		}
+		newreactions = append(newreactions, r)
+	}
+	return newreactions
+}
+
+type threadKey struct {
+	ChannelId string
+	ThreadTs  string
+}
+
+// messagesManager stores the messages of each channel, getting them from Slack the first time they're requested.
+// Channel messages are stored newest first, and threads oldest first, as Slack returns them.
+// Messages put which are already stored, e.g. our own sent message echoed back, or one put again by a backfill, are skipped.
+// Replies put are counted once in their parent's ReplyCount, even if their thread isn't loaded. The count is replaced
+// with Slack's when the thread is got, or the parent is in history put.
+// Messages sent from user input are stored unacked, without a ts, until Slack acks them.
+// It returns when ctx is done.
+func messagesManager(ctx context.Context, client *SlackClient, get <-chan MessageRequest, getThread <-chan ThreadRequest, put <-chan SlackRtmMessage, change <-chan MessageChange, getNewest <-chan NewestMessagesRequest, putHistory <-chan ChannelHistory, putOutbox <-chan OutboxMsg, getUserNameChan chan<- UserNameRequest) {
 	messages := make(map[string][]TermMsg)
+	threads := make(map[threadKey][]TermMsg)
+	counted := make(map[threadKey]map[string]bool) // the ts of the replies put, which were counted in their parent's ReplyCount
+	putMsg := func(p SlackRtmMessage) {
+		log.Println("messageManager put " + p.ChannelId)
+		if _, ok := messages[p.ChannelId]; !ok {
+			log.Println("messageManager put getting1 " + p.ChannelId)
+			msgs, err := client.GetSlackMessagesUntil(ctx, p.ChannelId, p.Time)
+			log.Println("messageManager get got1 " + p.ChannelId)
+			if err != nil {
+				log.Println("messageManager error getting messages for " + p.ChannelId + ": " + err.Error())
+			}
+			log.Println("messageManager creating term msgs1 " + p.ChannelId)
+			messages[p.ChannelId] = slackMessagesToTermMsgs(msgs, getUserNameChan)
+		}
+		msg := rtmMessageToTermMsg(p, getUserNameChan)
+		if p.IsThreadReply() {
+			key := threadKey{p.ChannelId, p.ThreadTs}
+			if replies, ok := threads[key]; ok {
+				if hasMessage(replies, p.Time) {
+					return
+				}
+				threads[key] = appendReply(replies, msg)
+			}
+			if counted[key][p.Time] {
+				return // e.g. our own reply, put when it's acked, and again when it's echoed back
+			}
+			if counted[key] == nil {
+				counted[key] = make(map[string]bool)
+			}
+			counted[key][p.Time] = true
+			messages[p.ChannelId], _ = updateMessage(messages[p.ChannelId], p.ThreadTs, func(parent *TermMsg) {
+				parent.ThreadTs = p.ThreadTs
+				parent.ReplyCount++
+			})
+			if p.Subtype != `thread_broadcast` {
+				return // replies are only shown in the channel if they were also sent to it
+			}
+		}
+		if hasMessage(messages[p.ChannelId], p.Time) {
+			return
+		}
+		log.Println("messageManager putting " + p.ChannelId + " " + p.Text)
+		log.Printf("messageManager put len %d\n", len(messages[p.ChannelId]))
+		messages[p.ChannelId] = insertMessage(messages[p.ChannelId], msg) // this is horribly inefficient, and could be made more efficient if necessary
+		log.Printf("messageManager put new len %d\n", len(messages[p.ChannelId]))
+	}
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case g := <-get:
 			log.Println("messageManager get " + g.ChannelId)
 			if _, ok := messages[g.ChannelId]; !ok {
 				log.Println("messageManager get getting0 " + g.ChannelId)
-				msgs, err := GetAllSlackMessages(token, g.ChannelId)
+				msgs, err := client.GetAllSlackMessages(ctx, g.ChannelId)
 				log.Println("messageManager get got0 " + g.ChannelId)
 				if err != nil {
-					log.Panicln(err) // TODO(return error)
+					log.Println("messageManager error getting messages for " + g.ChannelId + ": " + err.Error())
+					g.Reply <- MessagesReply{Err: err} // don't store, so the next get retries
+					continue
 				}
 				log.Println("messageManager creating term msgs0 " + g.ChannelId)
 				messages[g.ChannelId] = slackMessagesToTermMsgs(msgs, getUserNameChan)
 			}
 			log.Printf("messageManager get len %d\n", len(messages[g.ChannelId]))
-			g.Reply <- messages[g.ChannelId]
-		case p := <-put:
-			log.Println("messageManager put " + p.ChannelId)
-			if _, ok := messages[p.ChannelId]; !ok {
-				log.Println("messageManager put getting1 " + p.ChannelId)
-				msgs, err := GetSlackMessagesUntil(token, p.ChannelId, p.Time)
-				log.Println("messageManager get got1 " + p.ChannelId)
+			g.Reply <- MessagesReply{Msgs: messages[g.ChannelId]}
+		case gt := <-getThread:
+			key := threadKey{gt.ChannelId, gt.ThreadTs}
+			if _, ok := threads[key]; !ok {
+				msgs, err := client.GetSlackReplies(ctx, gt.ChannelId, gt.ThreadTs)
 				if err != nil {
-					log.Panicln(err) // TODO(return error)
+					log.Println("messageManager error getting thread " + gt.ThreadTs + " in " + gt.ChannelId + ": " + err.Error())
+					gt.Reply <- MessagesReply{Err: err}
+					continue
+				}
+				threads[key] = slackMessagesToTermMsgs(msgs, getUserNameChan)
+				if channelMsgs, ok := messages[gt.ChannelId]; ok && len(msgs) > 0 {
+					messages[gt.ChannelId], _ = updateMessage(channelMsgs, gt.ThreadTs, func(parent *TermMsg) {
+						parent.ReplyCount = msgs[0].ReplyCount // replies may have been missed while disconnected
+					})
+				}
+			}
+			gt.Reply <- MessagesReply{Msgs: threads[key]}
+		case p := <-put:
+			putMsg(p)
+		case o := <-putOutbox:
+			key := threadKey{o.ChannelId, o.ThreadTs}
+			if o.Time != "" || o.Discard {
+				if msgs, ok := messages[o.ChannelId]; ok {
+					messages[o.ChannelId], _ = removeOutboxMessage(msgs, o.Id)
+				}
+				if replies, ok := threads[key]; ok {
+					threads[key], _ = removeOutboxMessage(replies, o.Id)
+				}
+				if o.Time != "" {
+					putMsg(o.rtmMessage()) // skipped if our message was already echoed back
+				}
+				continue
+			}
+			msg := outboxMsgToTermMsg(o, getUserNameChan)
+			if replies, ok := threads[key]; ok && o.ThreadTs != "" {
+				threads[key] = putOutboxMessage(replies, msg, false)
+			}
+			if msgs, ok := messages[o.ChannelId]; ok && (o.ThreadTs == "" || o.Broadcast) {
+				messages[o.ChannelId] = putOutboxMessage(msgs, msg, true)
+			}
+		case gn := <-getNewest:
+			newest := make(map[string]string, len(messages))
+			for channelId, msgs := range messages {
+				newest[channelId] = ""
+				for _, msg := range msgs {
+					if msg.OutboxId == 0 {
+						newest[channelId] = msg.Time
+						break
+					}
+				}
+			}
+			gn.Reply <- newest
+		case h := <-putHistory:
+			msgs, ok := messages[h.ChannelId]
+			if !ok {
+				continue // it'll be current when it's loaded
+			}
+			for _, msg := range h.Msgs {
+				if !hasMessage(msgs, msg.Time) {
+					msgs = insertMessage(msgs, slackMessageToTermMsg(msg, getUserNameChan))
+					continue
+				}
+				if msg.ReplyCount > 0 {
+					msgs, _ = updateMessage(msgs, msg.Time, func(parent *TermMsg) {
+						parent.ThreadTs = msg.ThreadTs
+						parent.ReplyCount = msg.ReplyCount // replies may have been missed, which Slack counted
+					})
+				}
+			}
+			messages[h.ChannelId] = msgs
+			for key := range threads {
+				if key.ChannelId == h.ChannelId {
+					delete(threads, key) // replies may have been missed too, so get the thread again
+				}
+			}
+		case c := <-change:
+			// changes to channels which aren't loaded yet are dropped, because they'll be current when they're loaded
+			if !c.Delete {
+				if msgs, ok := messages[c.ChannelId]; ok {
+					messages[c.ChannelId], _ = updateMessage(msgs, c.Time, c.Change)
+				}
+				for key, replies := range threads {
+					if key.ChannelId == c.ChannelId {
+						threads[key], _ = updateMessage(replies, c.Time, c.Change)
+					}
+				}
+				continue
+			}
+			if msgs, ok := messages[c.ChannelId]; ok {
+				messages[c.ChannelId], _ = deleteMessage(msgs, c.Time)
+				if c.ThreadTs != "" && c.ThreadTs != c.Time {
+					messages[c.ChannelId], _ = updateMessage(messages[c.ChannelId], c.ThreadTs, func(parent *TermMsg) {
+						parent.ReplyCount--
+					})
+				}
+			}
+			for key, replies := range threads {
+				if key.ChannelId != c.ChannelId {
+					continue
+				}
+				if key.ThreadTs == c.Time {
+					delete(threads, key) // the parent was deleted, so get the thread again if it's opened
+					continue
 				}
-				log.Println("messageManager creating term msgs1 " + p.ChannelId)
-				messages[p.ChannelId] = slackMessagesToTermMsgs(msgs, getUserNameChan)
+				threads[key], _ = deleteMessage(replies, c.Time)
 			}
-			// TODO(check time of new messages vs newest in map, to fix race condition potentially causing duplicate messages)
-			log.Println("messageManager putting " + p.ChannelId + " " + p.Text)
-			log.Printf("messageManager put len %d\n", len(messages[p.ChannelId]))
-			messages[p.ChannelId] = append([]TermMsg{TermMsg{UserName: GetUserName(p.UserId, getUserNameChan), Text: p.Text}}, messages[p.ChannelId]...) // this is horribly inefficient, and could be made more efficient if necessary
-			log.Printf("messageManager put new len %d\n", len(messages[p.ChannelId]))
 		}
 	}
 }
 
-func StartMessagesManager(token string, getUserNameChan chan<- UserNameRequest) (chan<- MessageRequest, chan<- SlackRtmMessage) {
+func StartMessagesManager(ctx context.Context, client *SlackClient, getUserNameChan chan<- UserNameRequest) (chan<- MessageRequest, chan<- ThreadRequest, chan<- SlackRtmMessage, chan<- MessageChange, chan<- NewestMessagesRequest, chan<- ChannelHistory, chan<- OutboxMsg) {
 	getChan := make(chan MessageRequest)
+	getThreadChan := make(chan ThreadRequest)
 	putChan := make(chan SlackRtmMessage)
-	go messagesManager(token, getChan, putChan, getUserNameChan)
-	return getChan, putChan
+	changeChan := make(chan MessageChange)
+	getNewestChan := make(chan NewestMessagesRequest)
+	putHistoryChan := make(chan ChannelHistory)
+	putOutboxChan := make(chan OutboxMsg)
+	go messagesManager(ctx, client, getChan, getThreadChan, putChan, changeChan, getNewestChan, putHistoryChan, putOutboxChan, getUserNameChan)
+	return getChan, getThreadChan, putChan, changeChan, getNewestChan, putHistoryChan, putOutboxChan
 }
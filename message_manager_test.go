@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jroimartin/gocui"
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestThreads(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	p := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "parent"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "r1", ThreadTs: p.Ts})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "plain"})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	msgs, err := GetMessages("C1", pipe.getMsgs)
+	if err != nil || len(msgs) != 2 || msgs[1].ReplyCount != 1 {
+		t.Fatalf("expected the parent with 1 reply, and the reply not in the channel, got %v %v", msgs, err)
+	}
+	thread, err := GetThread("C1", p.Ts, pipe.getThread)
+	if err != nil || len(thread) != 2 || thread[1].Text != "r1" {
+		t.Fatalf("expected the parent and its reply, got %v %v", thread, err)
+	}
+	waitConns(t, s)
+
+	if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "r2", ThreadTs: p.Ts}); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	thread, _ = GetThread("C1", p.Ts, pipe.getThread)
+	if len(msgs) != 2 || msgs[1].ReplyCount != 2 || len(thread) != 3 {
+		t.Fatalf("expected the pushed reply in the thread only, got %v %v", msgs, thread)
+	}
+
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "r3", ThreadTs: p.Ts, Broadcast: true}
+	<-pipe.updateMsgs // shown pending
+	if sent := <-s.Sent(); sent.ThreadTs != p.Ts || !sent.ReplyBroadcast {
+		t.Fatalf("expected a broadcast reply, got %v", sent)
+	}
+	<-pipe.updateMsgs // acked
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	thread, _ = GetThread("C1", p.Ts, pipe.getThread)
+	if len(msgs) != 3 || msgs[0].Text != "r3" || msgs[2].ReplyCount != 3 || len(thread) != 4 {
+		t.Fatalf("expected the broadcast reply in the channel and thread, got %v %v", msgs, thread)
+	}
+}
+
+func TestReplyCountedOnce(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	p := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "parent"})
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	getUser, _, _, _, _ := StartUserManager(ctx, client)
+	getMsgs, _, put, _, _, _, _ := StartMessagesManager(ctx, client, getUser)
+	if _, err := GetMessages("C1", getMsgs); err != nil {
+		t.Fatal(err)
+	}
+
+	// our own reply is put when it's acked, and again when it's echoed back, while its thread isn't loaded
+	reply := SlackRtmMessage{Type: "message", ChannelId: "C1", UserId: "U0SELF", Text: "mine", Time: "2000000000.000100", ThreadTs: p.Ts}
+	put <- reply
+	put <- reply
+	msgs, _ := GetMessages("C1", getMsgs)
+	if len(msgs) != 1 || msgs[0].ReplyCount != 1 {
+		t.Fatalf("expected the parent with 1 reply, got %v", msgs)
+	}
+}
+
+func TestOutboxThread(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	p := s.AddMessage("C1", slacktest.Message{User: "U0SELF", Text: "parent"})
+	s.AddMessage("C1", slacktest.Message{User: "U0SELF", Text: "r1", ThreadTs: p.Ts})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	go func() {
+		for range pipe.updateMsgs {
+		}
+	}()
+	if _, err := GetMessages("C1", pipe.getMsgs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetThread("C1", p.Ts, pipe.getThread); err != nil {
+		t.Fatal(err)
+	}
+	waitConns(t, s)
+
+	// a pending broadcast reply is in the channel and the thread, but isn't counted until it's acked
+	s.IgnoreSends(true)
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "r2", ThreadTs: p.Ts, Broadcast: true}
+	<-s.Sent()
+	msgs, _ := GetMessages("C1", pipe.getMsgs)
+	thread, _ := GetThread("C1", p.Ts, pipe.getThread)
+	if len(msgs) != 2 || !msgs[0].Sending || msgs[1].ReplyCount != 1 || len(thread) != 3 || !thread[2].Sending {
+		t.Fatalf("expected r2 pending in the channel and thread, got %v %v", msgs, thread)
+	}
+
+	// an acked reply goes before the pending one
+	s.IgnoreSends(false)
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "r3", ThreadTs: p.Ts}
+	<-s.Sent()
+	msgs = waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[len(m)-1].ReplyCount == 2 })
+	thread, _ = GetThread("C1", p.Ts, pipe.getThread)
+	if len(msgs) != 2 || !msgs[0].Sending || len(thread) != 4 || thread[2].Text != "r3" || thread[2].Time == "" || !thread[3].Sending {
+		t.Fatalf("expected r3 acked before r2 in the thread, got %v %v", msgs, thread)
+	}
+	if newest := GetNewestMessages(pipe.getNewest); newest["C1"] != p.Ts {
+		t.Fatalf("expected the newest acked message to be the parent, got %v", newest)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	m := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "hi", Reactions: []slacktest.Reaction{{Name: "eyes", Count: 1, Users: []string{"U1"}}}})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	msgs, err := GetMessages("C1", pipe.getMsgs)
+	if err != nil || len(msgs[0].Reactions) != 1 {
+		t.Fatalf("expected the history's reaction, got %v %v", msgs, err)
+	}
+	if text := reactionsText(msgs[0].Reactions); text != faint(":eyes: 1") {
+		t.Fatalf("expected :eyes: 1 faint, got %q", text)
+	}
+	waitConns(t, s)
+
+	if err := pipe.client.AddSlackReaction(pipe.ctx, "C1", m.Ts, "+1"); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); len(msgs[0].Reactions) != 2 || msgs[0].Reactions[1].Name != "+1" {
+		t.Fatalf("expected the added reaction, got %v", msgs)
+	}
+	if err := pipe.client.AddSlackReaction(pipe.ctx, "C1", m.Ts, "+1"); SlackErrorCode(err) != "already_reacted" {
+		t.Fatalf("expected already_reacted, got %v", err)
+	}
+	if err := pipe.client.RemoveSlackReaction(pipe.ctx, "C1", m.Ts, "eyes"); SlackErrorCode(err) != "no_reaction" {
+		t.Fatalf("expected no_reaction for another user's reaction, got %v", err)
+	}
+	if err := pipe.client.RemoveSlackReaction(pipe.ctx, "C1", m.Ts, "+1"); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); len(msgs[0].Reactions) != 1 {
+		t.Fatalf("expected the reaction removed, got %v", msgs)
+	}
+}
+
+func TestAddRemoveReaction(t *testing.T) {
+	reactions := addReaction(nil, "x", "U1")
+	reactions = addReaction(reactions, "x", "U2")
+	reactions = addReaction(reactions, "x", "U2") // already reacted
+	if reactions[0].Count != 2 {
+		t.Fatalf("expected 2, got %v", reactions)
+	}
+	removed := removeReaction(reactions, "x", "U1")
+	if reactions[0].Count != 2 || removed[0].Count != 1 {
+		t.Fatalf("expected a copy with 1, and the original unchanged, got %v %v", removed, reactions)
+	}
+	if removed = removeReaction(removed, "x", "U2"); len(removed) != 0 {
+		t.Fatalf("expected the reaction removed with its last user, got %v", removed)
+	}
+	if name := reactionName(":+1:"); name != "+1" {
+		t.Fatalf("expected +1, got %q", name)
+	}
+}
+
+func TestEditDelete(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(slacktest.User{Id: "U0SELF", Name: "me"})
+	other := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "theirs"})
+	p := s.AddMessage("C1", slacktest.Message{User: "U0SELF", Text: "mine"})
+	r := s.AddMessage("C1", slacktest.Message{User: "U0SELF", Text: "reply", ThreadTs: p.Ts})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	if auth, err := pipe.client.GetSlackAuth(pipe.ctx); err != nil || auth.UserId != "U0SELF" {
+		t.Fatalf("expected the token's user, got %v %v", auth, err)
+	}
+	if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 2 || msgs[0].ReplyCount != 1 {
+		t.Fatalf("expected the parent with 1 reply, got %v", msgs)
+	}
+	if _, err := GetThread("C1", p.Ts, pipe.getThread); err != nil {
+		t.Fatal(err)
+	}
+	waitConns(t, s)
+
+	// edited, in the channel and the loaded thread
+	if err := pipe.client.UpdateSlackMessage(pipe.ctx, "C1", other.Ts, "x"); SlackErrorCode(err) != "cant_update_message" {
+		t.Fatalf("expected cant_update_message for another user's message, got %v", err)
+	}
+	if err := pipe.client.UpdateSlackMessage(pipe.ctx, "C1", p.Ts, "fixed"); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	msgs, _ := GetMessages("C1", pipe.getMsgs)
+	thread, _ := GetThread("C1", p.Ts, pipe.getThread)
+	if msgs[0].Text != "fixed" || !msgs[0].Edited || thread[0].Text != "fixed" {
+		t.Fatalf("expected the parent edited, got %v %v", msgs, thread)
+	}
+
+	// a deleted reply is uncounted
+	if err := pipe.client.DeleteSlackMessage(pipe.ctx, "C1", r.Ts); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	thread, _ = GetThread("C1", p.Ts, pipe.getThread)
+	if len(msgs) != 2 || msgs[0].ReplyCount != 0 || len(thread) != 1 {
+		t.Fatalf("expected the reply deleted, got %v %v", msgs, thread)
+	}
+
+	// a sent message is ours, so it may be deleted
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "new"}
+	<-pipe.updateMsgs // shown pending
+	<-s.Sent()
+	<-pipe.updateMsgs // acked
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); msgs[0].UserId != "U0SELF" || msgs[0].UserName != "me" {
+		t.Fatalf("expected the sent message from me, got %v", msgs)
+	}
+	if err := pipe.client.DeleteSlackMessage(pipe.ctx, "C1", msgs[0].Time); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); len(msgs) != 2 {
+		t.Fatalf("expected the sent message deleted, got %v", msgs)
+	}
+}
+
+func TestSubtypes(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddMessage("C1", slacktest.Message{Subtype: "bot_message", BotId: "B1", Username: "jenkins", Icons: &slacktest.Icons{Emoji: ":robot_face:"}, Text: "build ok"})
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 1 || displayName(msgs[0]) != ":robot_face: jenkins" {
+		t.Fatalf("expected the bot's icon and name, got %v", msgs)
+	}
+	waitConns(t, s)
+
+	// system messages and file shares are stored, and hidden messages aren't
+	if _, err := s.PushMessage("C1", slacktest.Message{Subtype: "channel_join", User: "U1", Text: "<@U1> has joined the channel"}); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	if _, err := s.PushMessage("C1", slacktest.Message{Subtype: "file_share", User: "U1", Files: []slacktest.File{{Id: "F1", Name: "log.txt"}}}); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	s.Push(map[string]interface{}{"type": "message", "subtype": "message_changed", "hidden": true, "channel": "C1", "ts": "9.9"})
+	s.Push(map[string]interface{}{"type": "message", "subtype": "channel_marked", "hidden": true, "channel": "C1", "ts": "9.9"})
+	if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	msgs, _ := GetMessages("C1", pipe.getMsgs)
+	if len(msgs) != 4 || msgs[1].Files[0].Name != "log.txt" || !strings.Contains(messageText(msgs[1]), "[file: log.txt]") {
+		t.Fatalf("expected the file share, and no hidden messages, got %v", msgs)
+	}
+	if colors := renderedColors(t, messageText(msgs[2])); colors[0] != gocui.ColorBlue || msgs[2].UserName != "alice" {
+		t.Fatalf("expected the join faint, from alice, got %v colored %v", msgs[2], colors)
+	}
+}
+
+func TestPins(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	m := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "runbook link"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "other"})
+	pinsChanged := make(chan string, 10)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{PinsChanged: pinsChanged})
+	if msgs, err := GetMessages("C1", pipe.getMsgs); err != nil || msgs[1].Pinned {
+		t.Fatalf("expected nothing pinned, got %v %v", msgs, err)
+	}
+	waitConns(t, s)
+
+	if err := pipe.client.AddSlackPin(pipe.ctx, "C1", m.Ts); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	if id := <-pinsChanged; id != "C1" {
+		t.Fatalf("expected C1's pins changed, got %s", id)
+	}
+	msgs, _ := GetMessages("C1", pipe.getMsgs)
+	if !msgs[1].Pinned || msgs[0].Pinned || !strings.Contains(messageText(msgs[1]), "[pinned]") {
+		t.Fatalf("expected the message pinned, got %v", msgs)
+	}
+	pins, err := pipe.client.GetSlackPins(pipe.ctx, "C1")
+	if err != nil || len(pins) != 1 || pins[0].Text != "runbook link" || len(pins[0].PinnedTo) != 1 {
+		t.Fatalf("expected the pinned message, got %v %v", pins, err)
+	}
+	if msg := slackMessageToTermMsg(pins[0], pipe.getUser); !msg.Pinned || msg.UserName != "alice" {
+		t.Fatalf("expected the pin from alice, got %v", msg)
+	}
+	if err := pipe.client.AddSlackPin(pipe.ctx, "C1", m.Ts); SlackErrorCode(err) != "already_pinned" {
+		t.Fatalf("expected already_pinned, got %v", err)
+	}
+
+	if err := pipe.client.RemoveSlackPin(pipe.ctx, "C1", m.Ts); err != nil {
+		t.Fatal(err)
+	}
+	<-pipe.updateMsgs
+	<-pinsChanged
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); msgs[1].Pinned {
+		t.Fatalf("expected the message unpinned, got %v", msgs)
+	}
+	if err := pipe.client.RemoveSlackPin(pipe.ctx, "C1", m.Ts); SlackErrorCode(err) != "no_pin" {
+		t.Fatalf("expected no_pin, got %v", err)
+	}
+	if pins, err := pipe.client.GetSlackPins(pipe.ctx, "C1"); err != nil || len(pins) != 0 {
+		t.Fatalf("expected no pins, got %v %v", pins, err)
+	}
+	if _, err := pipe.client.GetSlackPins(pipe.ctx, "C404"); SlackErrorCode(err) != "channel_not_found" {
+		t.Fatalf("expected channel_not_found, got %v", err)
+	}
+}
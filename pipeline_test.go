@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+// testClient returns a client of the fake server, which is closed when the test ends.
+func testClient(t *testing.T, s *slacktest.Server) *SlackClient {
+	t.Helper()
+	t.Cleanup(s.Close)
+	client := NewSlackClient(s.Token)
+	client.BaseUrl = s.ApiUrl()
+	return client
+}
+
+// waitConns waits for a client to connect to the fake server's websocket.
+func waitConns(t *testing.T, s *slacktest.Server) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.NumConns() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitMsgs gets the channel's messages until ok returns true for them, and returns them, failing after a few seconds.
+func waitMsgs(t *testing.T, channelId string, getMsgs chan<- MessageRequest, ok func([]TermMsg) bool) []TermMsg {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		msgs, _ := GetMessages(channelId, getMsgs)
+		if ok(msgs) {
+			return msgs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the messages, got %v", msgs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pipeline is the user, channel, and messages managers, and RTM handler, of a test, connected to a fake server.
+type pipeline struct {
+	ctx               context.Context // done when the test ends
+	client            *SlackClient
+	getUser           chan<- UserNameRequest
+	getStatus         chan<- UserStatusRequest
+	putChannel        chan<- SlackChannel
+	getChannelId      chan<- ChannelIdRequest
+	getChannelName    chan<- ChannelNameRequest
+	getChannels       chan<- ChannelListRequest
+	getMsgs           chan<- MessageRequest
+	getThread         chan<- ThreadRequest
+	put               chan<- SlackRtmMessage
+	getNewest         chan<- NewestMessagesRequest
+	updateMsgs        <-chan string
+	send              chan<- PutRtmMsg
+	retry             chan<- OutboxRetry
+	typing            chan<- string
+	subscribePresence chan<- []string
+}
+
+// startPipeline starts a pipeline with a client of the fake server, and its RTM websocket. chans are the chans the
+// RTM handler writes to which the managers don't provide, e.g. Status. It's stopped when the test ends.
+func startPipeline(t *testing.T, s *slacktest.Server, config RtmConfig, chans RtmChans) *pipeline {
+	t.Helper()
+	client := testClient(t, s)
+	client.Scheduler.Tiers = map[string]SlackTier{"rtm.connect": Tier4} // so reconnects don't wait for the tier
+	return startPipelineOn(t, client, NewRtmTransport(client), config, chans)
+}
+
+// startPipelineOn starts a pipeline with the given client and transport, which is stopped when the test ends.
+func startPipelineOn(t *testing.T, client *SlackClient, transport RealtimeTransport, config RtmConfig, chans RtmChans) *pipeline {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p := &pipeline{ctx: ctx, client: client}
+	p.getUser, _, p.getStatus, chans.PutUser, chans.PutPresence = StartUserManager(ctx, client)
+	p.putChannel, chans.ChangeChannel, p.getChannelId, p.getChannelName, p.getChannels = StartChannelIdManager(ctx, client, p.getUser, nil)
+	p.getMsgs, p.getThread, p.put, chans.ChangeMsg, p.getNewest, chans.PutHistory, chans.PutOutbox = StartMessagesManager(ctx, client, p.getUser)
+	chans.PutMsg, chans.GetUserName, chans.GetNewest, chans.PutChannel = p.put, p.getUser, p.getNewest, p.putChannel
+	p.updateMsgs, p.send, p.retry, p.typing, p.subscribePresence = StartSlackRtmHandler(ctx, client, transport, config, chans)
+	return p
+}
+
+func TestPipeline(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.MaxPageSize = 2
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	for i := 0; i < 5; i++ {
+		s.AddMessage("C1", slacktest.Message{User: "U1", Text: "hi"})
+	}
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{})
+	msgs, err := GetMessages("C1", pipe.getMsgs)
+	if err != nil || len(msgs) != 5 {
+		t.Fatalf("expected the 5 messages over 3 pages, got %v %v", msgs, err)
+	}
+	waitConns(t, s)
+
+	if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "pushed"}); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-pipe.updateMsgs; id != "C1" {
+		t.Fatalf("expected an update of C1, got %s", id)
+	}
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	if len(msgs) != 6 || msgs[0].Text != "pushed" || msgs[0].UserName != "alice" {
+		t.Fatalf("expected the pushed message first, from alice, got %v", msgs)
+	}
+
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "mine"}
+	<-pipe.updateMsgs // shown pending
+	if sent := <-s.Sent(); sent.Text != "mine" || sent.ChannelId != "C1" {
+		t.Fatalf("expected mine sent to C1, got %v", sent)
+	}
+	<-pipe.updateMsgs // acked
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	if len(msgs) != 7 || msgs[0].Text != "mine" || msgs[0].Sending || msgs[0].Time == "" || msgs[0].UserId != "U0SELF" {
+		t.Fatalf("expected the acked message first, got %v", msgs)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+const (
+	PresenceActive = "active"
+	PresenceAway   = "away"
+	PresenceAuto   = "auto" // set to let Slack decide whether the user is active or away
+)
+
+// SlackStatus is a user's custom status.
+type SlackStatus struct {
+	Text       string
+	Emoji      string    // e.g. :palm_tree:
+	Expiration time.Time // when Slack clears the status, or the zero time if it doesn't
+}
+
+// SetSlackPresence sets the token's user's presence, to PresenceAway or PresenceAuto.
+func (c *SlackClient) SetSlackPresence(ctx context.Context, presence string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `users.setPresence`, url.Values{"presence": {presence}}, &response)
+}
+
+// SetSlackStatus sets the token's user's custom status. The empty SlackStatus clears it.
+func (c *SlackClient) SetSlackStatus(ctx context.Context, status SlackStatus) error {
+	var expiration int64
+	if !status.Expiration.IsZero() {
+		expiration = status.Expiration.Unix()
+	}
+	profile, err := json.Marshal(map[string]interface{}{
+		"status_text":       status.Text,
+		"status_emoji":      status.Emoji,
+		"status_expiration": expiration,
+	})
+	if err != nil {
+		return err
+	}
+	var response slackResponse
+	return c.apiPost(ctx, `users.profile.set`, url.Values{"profile": {string(profile)}}, &response)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestPresence(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice", Profile: slacktest.Profile{StatusEmoji: ":palm_tree:", StatusText: "Vacation"}})
+	s.AddUser(slacktest.User{Id: "U2", Name: "bob", Profile: slacktest.Profile{StatusEmoji: ":x:", StatusExpiration: 1}})
+	s.AddUser(s.Self)
+	s.SetPresence("U1", "active")
+	usersChanged := make(chan []string, 10)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{UsersChanged: usersChanged})
+	if st := GetUserStatus("U1", pipe.getStatus); st.Presence != "" || st.Emoji != ":palm_tree:" || st.Text != "Vacation" {
+		t.Fatalf("expected alice's status, and no presence before subscribing, got %+v", st)
+	}
+	if st := GetUserStatus("U2", pipe.getStatus); st.Emoji != "" {
+		t.Fatalf("expected bob's expired status not shown, got %+v", st)
+	}
+
+	// subscribing gets the presence of each user
+	pipe.subscribePresence <- []string{"U0SELF", "U1", "U2"}
+	changed := map[string]bool{}
+	for len(changed) < 3 {
+		for _, id := range <-usersChanged {
+			changed[id] = true
+		}
+	}
+	if st := GetUserStatus("U1", pipe.getStatus); st.Presence != PresenceActive {
+		t.Fatalf("expected alice active, got %+v", st)
+	}
+	if st := GetUserStatus("U2", pipe.getStatus); st.Presence != PresenceAway {
+		t.Fatalf("expected bob away, got %+v", st)
+	}
+	if text := userStatusText(GetUserStatus("U1", pipe.getStatus), true); text != " \033[32m●\033[0m :palm_tree: "+faint("Vacation") {
+		t.Fatalf("expected alice's presence, emoji, and text, got %q", text)
+	}
+	if text := userStatusText(GetUserStatus("U1", pipe.getStatus), false); textWidth(text) != len(" x :palm_tree:") {
+		t.Fatalf("expected alice's presence and emoji, got %q", text)
+	}
+
+	if err := pipe.client.SetSlackPresence(pipe.ctx, PresenceAway); err != nil {
+		t.Fatal(err)
+	}
+	if ids := <-usersChanged; len(ids) != 1 || ids[0] != "U0SELF" {
+		t.Fatalf("expected my presence changed, got %v", ids)
+	}
+	if st := GetUserStatus("U0SELF", pipe.getStatus); st.Presence != PresenceAway {
+		t.Fatalf("expected me away, got %+v", st)
+	}
+	if err := pipe.client.SetSlackPresence(pipe.ctx, "busy"); SlackErrorCode(err) != "invalid_presence" {
+		t.Fatalf("expected invalid_presence, got %v", err)
+	}
+
+	status := parseStatus("1h :spiral_calendar_pad: In a  meeting", time.Now())
+	if err := pipe.client.SetSlackStatus(pipe.ctx, status); err != nil {
+		t.Fatal(err)
+	}
+	<-usersChanged
+	if st := GetUserStatus("U0SELF", pipe.getStatus); st.Text != "In a meeting" || st.Emoji != ":spiral_calendar_pad:" || st.Expiration.Unix() != status.Expiration.Unix() {
+		t.Fatalf("expected my status set, got %+v", st)
+	}
+	if err := pipe.client.SetSlackStatus(pipe.ctx, parseStatus("", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	<-usersChanged
+	if st := GetUserStatus("U0SELF", pipe.getStatus); st.Text != "" || st.Emoji != "" || st.Presence != PresenceAway {
+		t.Fatalf("expected my status cleared, got %+v", st)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SlackTier is a Slack Web API rate limit tier. See https://api.slack.com/docs/rate-limits
+type SlackTier int
+
+const (
+	Tier1 SlackTier = iota + 1
+	Tier2
+	Tier3
+	Tier4
+)
+
+// RequestsPerMinute returns the number of requests per minute each method of the tier may make.
+func (t SlackTier) RequestsPerMinute() float64 {
+	switch t {
+	case Tier1:
+		return 1
+	case Tier2:
+		return 20
+	case Tier4:
+		return 100
+	default:
+		return 50
+	}
+}
+
+// methodTiers is the rate limit tier of each Slack method slackterm calls. Methods not listed are assumed to be Tier3.
+var methodTiers = map[string]SlackTier{
+	`conversations.list`:           Tier2,
+	`conversations.info`:           Tier3,
+	`conversations.history`:        Tier3,
+	`conversations.members`:        Tier4,
+	`conversations.replies`:        Tier3,
+	`conversations.join`:           Tier3,
+	`conversations.leave`:          Tier3,
+	`conversations.create`:         Tier2,
+	`conversations.archive`:        Tier2,
+	`conversations.setTopic`:       Tier3,
+	`conversations.setPurpose`:     Tier3,
+	`conversations.invite`:         Tier3,
+	`conversations.kick`:           Tier3,
+	`users.list`:                   Tier2,
+	`users.info`:                   Tier4,
+	`users.setPresence`:            Tier2,
+	`users.profile.set`:            Tier3,
+	`reactions.add`:                Tier3,
+	`reactions.remove`:             Tier2,
+	`chat.postMessage`:             Tier3,
+	`chat.update`:                  Tier3,
+	`chat.delete`:                  Tier3,
+	`auth.test`:                    Tier4,
+	`search.messages`:              Tier2,
+	`pins.list`:                    Tier2,
+	`pins.add`:                     Tier2,
+	`pins.remove`:                  Tier2,
+	`files.getUploadURLExternal`:   Tier4,
+	`files.completeUploadExternal`: Tier4,
+	`rtm.start`:                    Tier1,
+	`rtm.connect`:                  Tier1,
+	`apps.connections.open`:        Tier1,
+}
+
+// tier returns the rate limit tier of method, from s.Tiers, or else methodTiers.
+func (s *RequestScheduler) tier(method string) SlackTier {
+	if tier, ok := s.Tiers[method]; ok {
+		return tier
+	}
+	if tier, ok := methodTiers[method]; ok {
+		return tier
+	}
+	return Tier3
+}
+
+const DefaultMaxRetries = 3
+
+// DefaultRetryAfter is how long to wait after a 429 without a valid Retry-After header.
+const DefaultRetryAfter = 30 * time.Second
+
+// minReportedWait is the shortest wait which is reported as throttling.
+const minReportedWait = time.Second
+
+// ThrottleInfo reports that requests to Method are waiting for Wait, because of Slack rate limits.
+type ThrottleInfo struct {
+	Method  string
+	Wait    time.Duration
+	Limited bool // whether Slack returned 429 Too Many Requests, rather than the request being queued to avoid it
+}
+
+// tierBucket is a token bucket, allowing bursts of a tier's requests per minute, refilled at the tier rate.
+type tierBucket struct {
+	tokens       float64
+	perMinute    float64
+	last         time.Time
+	blockedUntil time.Time // from a 429 Retry-After
+	throttled    bool      // whether throttling was logged, since the last request which didn't wait
+}
+
+// RequestScheduler queues requests to each Slack method, so each method stays within its tier's rate limit,
+// and delays all requests to a method after Slack returns 429 Too Many Requests for it.
+type RequestScheduler struct {
+	MaxRetries int                  // the number of times a request which gets a 429 is retried
+	Throttled  chan<- ThrottleInfo  // if not nil, throttling is reported here. Sends don't block, and are dropped if nobody is listening.
+	Tiers      map[string]SlackTier // if not nil, the tiers of methods which replace those in methodTiers
+
+	mutex   sync.Mutex
+	buckets map[string]*tierBucket // map[method]bucket
+}
+
+func NewRequestScheduler() *RequestScheduler {
+	return &RequestScheduler{MaxRetries: DefaultMaxRetries, buckets: make(map[string]*tierBucket)}
+}
+
+// reserve takes a request slot for method, and returns how long the caller must wait before making the request.
+// Throttling is logged when it starts, not for every request which waits.
+func (s *RequestScheduler) reserve(method string, now time.Time) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.buckets[method]
+	if !ok {
+		perMinute := s.tier(method).RequestsPerMinute()
+		b = &tierBucket{tokens: perMinute, perMinute: perMinute, last: now}
+		s.buckets[method] = b
+	}
+
+	b.tokens = math.Min(b.perMinute, b.tokens+now.Sub(b.last).Minutes()*b.perMinute)
+	b.last = now
+	b.tokens--
+
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.perMinute * float64(time.Minute))
+	}
+	if blocked := b.blockedUntil.Sub(now); blocked > wait {
+		wait = blocked
+	}
+	switch {
+	case wait <= 0:
+		b.throttled = false
+	case wait >= minReportedWait && !b.throttled:
+		b.throttled = true
+		log.Printf("RequestScheduler throttling %s, waiting %v\n", method, wait)
+	}
+	return wait
+}
+
+// Wait blocks until a request to method may be made, or ctx is done, in which case ctx's error is returned.
+func (s *RequestScheduler) Wait(ctx context.Context, method string) error {
+	wait := s.reserve(method, time.Now())
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	if wait >= minReportedWait {
+		s.report(ThrottleInfo{Method: method, Wait: wait})
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryAfter blocks requests to method for the given duration, after Slack returned 429 Too Many Requests.
+func (s *RequestScheduler) RetryAfter(method string, wait time.Duration) {
+	s.mutex.Lock()
+	if b, ok := s.buckets[method]; ok {
+		b.blockedUntil = time.Now().Add(wait)
+	}
+	s.mutex.Unlock()
+	log.Printf("RequestScheduler %s rate limited by Slack, retrying after %v\n", method, wait)
+	s.report(ThrottleInfo{Method: method, Wait: wait, Limited: true})
+}
+
+func (s *RequestScheduler) report(info ThrottleInfo) {
+	if s.Throttled == nil {
+		return
+	}
+	select {
+	case s.Throttled <- info:
+	default:
+	}
+}
+
+// retryAfter returns the Retry-After duration of a 429 response.
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return DefaultRetryAfter
+	}
+	return time.Duration(seconds) * time.Second
+}
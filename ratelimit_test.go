@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestRequestSchedulerTiers(t *testing.T) {
+	s := NewRequestScheduler()
+	now := time.Now()
+	if wait := s.reserve("rtm.start", now); wait != 0 {
+		t.Fatalf("expected the first Tier1 request not to wait, got %v", wait)
+	}
+	if wait := s.reserve("rtm.start", now); wait != time.Minute {
+		t.Fatalf("expected the second Tier1 request to wait a minute, got %v", wait)
+	}
+	for i := 0; i < 20; i++ {
+		if wait := s.reserve("users.list", now); wait != 0 {
+			t.Fatalf("expected a burst of 20 Tier2 requests, request %d waited %v", i, wait)
+		}
+	}
+	if wait := s.reserve("users.list", now); wait != 3*time.Second {
+		t.Fatalf("expected the 21st Tier2 request to wait 3s, got %v", wait)
+	}
+	if wait := s.reserve("users.list", now.Add(time.Minute)); wait != 0 {
+		t.Fatalf("expected the bucket refilled after a minute, got %v", wait)
+	}
+	if wait := s.reserve("conversations.history", now); wait != 0 {
+		t.Fatalf("expected methods to have separate buckets, got %v", wait)
+	}
+
+	// a tier overridden for the scheduler
+	s = NewRequestScheduler()
+	s.Tiers = map[string]SlackTier{"rtm.start": Tier4}
+	for i := 0; i < 100; i++ {
+		if wait := s.reserve("rtm.start", now); wait != 0 {
+			t.Fatalf("expected a burst of 100 requests to the method overridden to Tier4, request %d waited %v", i, wait)
+		}
+	}
+}
+
+func TestRequestSchedulerLogsThrottlingOnce(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewRequestScheduler()
+	now := time.Now()
+	for i := 0; i < 25; i++ {
+		s.reserve("users.list", now)
+	}
+	if n := strings.Count(logged.String(), "throttling users.list"); n != 1 {
+		t.Fatalf("expected throttling logged once, got %d: %s", n, logged.String())
+	}
+	s.reserve("users.list", now.Add(time.Hour))
+	for i := 0; i < 25; i++ {
+		s.reserve("users.list", now.Add(time.Hour))
+	}
+	if n := strings.Count(logged.String(), "throttling users.list"); n != 2 {
+		t.Fatalf("expected throttling logged again after it stopped, got %d: %s", n, logged.String())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	client := testClient(t, s)
+	throttled := make(chan ThrottleInfo, 10)
+	client.Scheduler.Throttled = throttled
+
+	s.RateLimit("users.list", 1, 1)
+	start := time.Now()
+	users, err := client.GetSlackUsers(context.Background())
+	if err != nil || len(users) != 1 {
+		t.Fatalf("expected the retry to succeed, got %v %v", users, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("expected the retry to wait for Retry-After, waited %v", elapsed)
+	}
+	if info := <-throttled; !info.Limited || info.Method != "users.list" || info.Wait != time.Second {
+		t.Fatalf("expected the 429 reported, got %+v", info)
+	}
+
+	client.Scheduler.MaxRetries = 2
+	s.RateLimit("users.info", 5, 0)
+	if _, err := client.GetSlackUser(context.Background(), "U1"); SlackErrorCode(err) != "ratelimited" {
+		t.Fatalf("expected ratelimited after the retries, got %v", err)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	for header, want := range map[string]time.Duration{
+		"5":    5 * time.Second,
+		"0":    0,
+		"":     DefaultRetryAfter,
+		"soon": DefaultRetryAfter,
+		"-1":   DefaultRetryAfter,
+	} {
+		response := &http.Response{Header: http.Header{}}
+		if header != "" {
+			response.Header.Set("Retry-After", header)
+		}
+		if got := retryAfter(response); got != want {
+			t.Errorf("expected Retry-After %q to wait %v, got %v", header, want, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// RealtimeTransport is a connection to Slack on which events are received as they happen, and messages are sent.
+// RtmTransport speaks the RTM API, and SocketModeTransport Socket Mode, which Slack apps created now must use.
+//
+// The RTM handler calls Connect, then Receive until it fails, then Connect again to reconnect. Send and Close are
+// called by the send handler while Receive blocks, but never during Connect.
+type RealtimeTransport interface {
+	// Connect connects, closing the previous connection, if any. The connection is closed when ctx is done.
+	Connect(ctx context.Context) error
+	// Receive blocks until the next event is received, and returns it as the JSON of an RTM event, e.g. a message, a
+	// reaction_added, or the reply_to of a sent message. It returns an error when the connection is lost or closed.
+	Receive() ([]byte, error)
+	// Send sends a SlackRtmSendMessage, SlackRtmPing, SlackRtmTyping, or SlackRtmPresenceSub. Replies to messages and
+	// pings are received by Receive.
+	Send(event interface{}) error
+	// Close closes the connection, so Receive fails.
+	Close() error
+}
+
+var errNotConnected = errors.New("not connected to Slack")
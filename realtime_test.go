@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestTransports(t *testing.T) {
+	transports := map[string]func(s *slacktest.Server, client *SlackClient) RealtimeTransport{
+		"rtm": func(s *slacktest.Server, client *SlackClient) RealtimeTransport { return NewRtmTransport(client) },
+		"socketmode": func(s *slacktest.Server, client *SlackClient) RealtimeTransport {
+			app := NewSlackClient(s.AppToken)
+			app.BaseUrl = s.ApiUrl()
+			app.Scheduler.Tiers = map[string]SlackTier{"apps.connections.open": Tier4}
+			return NewSocketModeTransport(app, client)
+		},
+	}
+	for name, newTransport := range transports {
+		t.Run(name, func(t *testing.T) {
+			s := slacktest.NewServer("xoxp-test")
+			s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+			s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+			s.AddMessage("C1", slacktest.Message{User: "U1", Text: "one"})
+			client := testClient(t, s)
+			client.Scheduler.Tiers = map[string]SlackTier{"rtm.connect": Tier4} // so reconnects don't wait for the tier
+			status := make(chan RtmStatus, 100)
+			pipe := startPipelineOn(t, client, newTransport(s, client), RtmConfig{SelfId: "U0SELF", PingInterval: 50 * time.Millisecond}, RtmChans{Status: status})
+			go func() {
+				for range pipe.updateMsgs {
+				}
+			}()
+			if _, err := GetMessages("C1", pipe.getMsgs); err != nil {
+				t.Fatal(err)
+			}
+			if st := <-status; !st.Connected {
+				t.Fatalf("expected connected, got %+v", st)
+			}
+			waitConns(t, s)
+
+			// received, sent, and failed
+			if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "two"}); err != nil {
+				t.Fatal(err)
+			}
+			waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return len(m) == 2 && m[0].Text == "two" })
+			pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "three"}
+			waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].Text == "three" && m[0].Time != "" })
+			time.Sleep(50 * time.Millisecond)
+			if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 3 {
+				t.Fatalf("expected three once, got %v", msgs)
+			}
+			s.FailSends(1, "msg_too_long")
+			pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "four"}
+			waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].SendError == "msg_too_long" })
+			if n := s.Unacked(); n != 0 {
+				t.Fatalf("expected every envelope acked, got %d unacked", n)
+			}
+
+			// pings are answered by Slack, with a latency
+			for st := <-status; st.Latency <= 0; st = <-status {
+				if !st.Connected {
+					t.Fatalf("expected connected, got %+v", st)
+				}
+			}
+
+			// the connection is half-open, so pongs stop, and it's declared dead and reconnected
+			s.IgnorePings(true)
+			for st := <-status; st.Connected; st = <-status {
+			}
+			s.IgnorePings(false)
+			for st := <-status; !st.Connected; st = <-status {
+			}
+
+			// Slack closes the connection
+			if name == "socketmode" {
+				s.Disconnect("refresh_requested")
+			} else {
+				s.DropConns()
+			}
+			for st := <-status; st.Connected; st = <-status {
+			}
+			for st := <-status; !st.Connected; st = <-status {
+			}
+			for deadline := time.Now().Add(5 * time.Second); s.NumConns() != 1; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for the old connections to close, got %d", s.NumConns())
+				}
+			}
+			if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "five"}); err != nil {
+				t.Fatal(err)
+			}
+			waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[1].Text == "five" })
+		})
+	}
+}
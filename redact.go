@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+)
+
+// tokenRegexp matches Slack tokens, e.g. xoxb-, xoxp-, refreshed xoxe.xoxp- tokens, and xapp- app tokens.
+var tokenRegexp = regexp.MustCompile(`(xox[a-zA-Z](\.xox[a-zA-Z])?|xapp)-[A-Za-z0-9-]+`)
+
+// redactedToken replaces everything after the type prefix of a token, so logs still show which kind of token was used.
+func redactedToken(token []byte) []byte {
+	prefixEnd := bytes.IndexByte(token, '-')
+	return append(append([]byte{}, token[:prefixEnd+1]...), []byte("REDACTED")...)
+}
+
+// RedactTokens returns s with any Slack tokens in it redacted.
+func RedactTokens(s string) string {
+	return string(tokenRegexp.ReplaceAllFunc([]byte(s), redactedToken))
+}
+
+// redactingWriter redacts Slack tokens from everything written to it, before writing to the underlying writer.
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer which scrubs Slack tokens from everything written, before writing to w.
+// This is intended for log output, and assumes each write is a complete line, as the log package writes.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w}
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(tokenRegexp.ReplaceAllFunc(p, redactedToken)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sanitizeError removes the query from URLs in HTTP errors, and redacts any tokens in the URL,
+// so errors may be safely logged and shown.
+func sanitizeError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	sanitized := *urlErr
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+		u.RawQuery = ""
+		u.User = nil
+		sanitized.URL = u.String()
+	}
+	sanitized.URL = RedactTokens(sanitized.URL)
+	return &sanitized
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	//	"fmt" // debug
 	"golang.org/x/net/websocket"
 	"log"
-	"net/http"
+	"math/rand"
+	"net"
+	"time"
 )
 
 type SlackRtmUserInfo struct {
@@ -52,68 +56,401 @@ type SlackRtmType struct {
 }
 
 type SlackRtmMessage struct {
-	Type      string `json:"type"` // TODO(Remove? Unnecessary?)
-	ChannelId string `json:"channel"`
-	UserId    string `json:"user"`
-	Text      string `json:"text"`
-	Time      string `json:"ts"`
-}
+	Type      string       `json:"type"` // TODO(Remove? Unnecessary?)
+	Subtype   string       `json:"subtype"`
+	ChannelId string       `json:"channel"`
+	UserId    string       `json:"user"`
+	Text      string       `json:"text"`
+	Time      string       `json:"ts"`
+	ThreadTs  string       `json:"thread_ts"`
+	Edited    *SlackEdited `json:"edited"`
+	Files     []SlackFile  `json:"files"`
+	Hidden    bool         `json:"hidden"` // hidden messages are changes to other messages, and aren't shown
+	PinnedTo  []string     `json:"pinned_to"`
+	SlackBotInfo
 
-func slackRtmStart(token string) (SlackRtmStart, error) {
-	response, err := http.Get(`https://slack.com/api/rtm.start?token=` + token)
-	if err != nil {
-		return SlackRtmStart{}, err
-	}
+	Message         *SlackRtmMessage `json:"message"`          // the changed message, if this is a message_changed event
+	PreviousMessage *SlackRtmMessage `json:"previous_message"` // the message before it changed or was deleted
+	DeletedTs       string           `json:"deleted_ts"`       // the ts of the deleted message, if this is a message_deleted event
+}
 
-	if response.Status != `200 OK` {
-		return SlackRtmStart{}, errors.New("Unexpected Response: " + response.Status)
-	}
+// IsThreadReply returns whether the message is a reply in a thread, rather than a thread parent or unthreaded message.
+func (m SlackRtmMessage) IsThreadReply() bool {
+	return m.ThreadTs != "" && m.ThreadTs != m.Time
+}
 
+func (c *SlackClient) slackRtmStart(ctx context.Context) (SlackRtmStart, error) {
 	var slackRtmStart SlackRtmStart
-	if err = json.NewDecoder(response.Body).Decode(&slackRtmStart); err != nil {
+	if err := c.apiGet(ctx, `rtm.start`, nil, &slackRtmStart); err != nil {
 		return SlackRtmStart{}, err
 	}
-	if !slackRtmStart.Ok {
-		return SlackRtmStart{}, errors.New("Slack Rtm Start response not ok")
-	}
 	return slackRtmStart, nil
 }
 
+// SlackRtmConnect is the response of rtm.connect, which only has the websocket URL, and the user and team.
+type SlackRtmConnect struct {
+	Ok   bool             `json:"ok"`
+	Url  string           `json:"url"`
+	Self SlackRtmUserInfo `json:"self"`
+	Team SlackRtmTeamInfo `json:"team"`
+}
+
+func (c *SlackClient) slackRtmConnect(ctx context.Context) (SlackRtmConnect, error) {
+	var slackRtmConnect SlackRtmConnect
+	if err := c.apiGet(ctx, `rtm.connect`, nil, &slackRtmConnect); err != nil {
+		return SlackRtmConnect{}, err
+	}
+	return slackRtmConnect, nil
+}
+
 type SlackRtmHello struct {
 	Type string `json:"type"`
 }
 
 type SlackRtmReplytoMsg struct {
-	Ok      bool   `json:"ok"`
-	ReplyTo *int   `json:"reply_to"`
-	Time    string `json:"ts"`
-	Text    string `json:"text"`
+	Ok      bool           `json:"ok"`
+	ReplyTo *int           `json:"reply_to"`
+	Time    string         `json:"ts"`
+	Text    string         `json:"text"`
+	Error   *SlackRtmError `json:"error"` // why the message wasn't sent, if not Ok
 }
 
-func ConnectToSlackRtm(token string) (*websocket.Conn, error) {
-	startmsg, err := slackRtmStart(token)
+type SlackRtmError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// ConnectToSlackRtm starts an RTM session and connects its websocket. The websocket is closed when ctx is done.
+func ConnectToSlackRtm(ctx context.Context, client *SlackClient) (*websocket.Conn, error) {
+	startmsg, err := client.slackRtmStart(ctx)
 	if err != nil {
 		return nil, err
 	}
 	if !startmsg.Ok {
 		return nil, errors.New("Slack Rtm Start Not Ok!")
 	}
+	return dialSlackWebsocket(ctx, client, startmsg.Url, nil)
+}
 
-	origin := "http://localhost/"
-	ws, err := websocket.Dial(startmsg.Url, "", origin)
+// ReconnectToSlackRtm connects a new RTM websocket via rtm.connect, which is lighter than rtm.start, and rate limited
+// separately, so reconnecting doesn't wait for the rate limit of the first connection.
+// The websocket is closed when ctx is done.
+func ReconnectToSlackRtm(ctx context.Context, client *SlackClient) (*websocket.Conn, error) {
+	connectmsg, err := client.slackRtmConnect(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return dialSlackWebsocket(ctx, client, connectmsg.Url, nil)
+}
+
+// dialSlackWebsocket connects the RTM or Socket Mode websocket at url. The websocket is closed when ctx is done.
+// If onRead isn't nil, it's called whenever anything is read from the websocket, including the pings and pongs which
+// x/net/websocket handles itself, without returning them.
+func dialSlackWebsocket(ctx context.Context, client *SlackClient, url string, onRead func()) (*websocket.Conn, error) {
+	origin := "http://localhost/"
+	config, err := websocket.NewConfig(url, origin)
+	if err != nil {
+		return nil, errors.New(RedactTokens(err.Error()))
+	}
+	addr := config.Location.Host
+	if config.Location.Port() == "" {
+		port := "80"
+		if config.Location.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(config.Location.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: client.Timeout}
+	var conn net.Conn
+	if config.Location.Scheme == "wss" {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: config.TlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.New(RedactTokens(err.Error()))
+	}
+	if onRead != nil {
+		conn = readNotifyingConn{Conn: conn, onRead: onRead}
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.New(RedactTokens(err.Error()))
+	}
 
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
 	return ws, nil
 }
 
-// TODO(handle sent message ack [which requires storing the msg and id somewhere])
-func handleSlackRtmMessage(type_ string, data []byte, putChan chan<- SlackRtmMessage, updateMsgsChan chan<- string, replyHandlerReceivedMsg chan<- SlackRtmReplytoMsg) {
+// readNotifyingConn is a connection which calls onRead after each read of at least a byte.
+type readNotifyingConn struct {
+	net.Conn
+	onRead func()
+}
+
+func (c readNotifyingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.onRead()
+	}
+	return n, err
+}
+
+// RtmTransport is the RealtimeTransport of the RTM API. The first connection starts a session with rtm.start, and
+// reconnecting uses rtm.connect.
+type RtmTransport struct {
+	client  *SlackClient
+	started bool
+	ws      *websocket.Conn
+}
+
+func NewRtmTransport(client *SlackClient) *RtmTransport {
+	return &RtmTransport{client: client}
+}
+
+func (t *RtmTransport) Connect(ctx context.Context) error {
+	connect := ConnectToSlackRtm
+	if t.started {
+		connect = ReconnectToSlackRtm
+	}
+	ws, err := connect(ctx, t.client)
+	if err != nil {
+		return err
+	}
+	if t.ws != nil {
+		t.ws.Close()
+	}
+	t.ws = ws
+	t.started = true
+	return nil
+}
+
+func (t *RtmTransport) Receive() ([]byte, error) {
+	if t.ws == nil {
+		return nil, errNotConnected
+	}
+	var data []byte
+	err := websocket.Message.Receive(t.ws, &data)
+	return data, err
+}
+
+func (t *RtmTransport) Send(event interface{}) error {
+	if t.ws == nil {
+		return errNotConnected
+	}
+	return websocket.JSON.Send(t.ws, event)
+}
+
+func (t *RtmTransport) Close() error {
+	if t.ws == nil {
+		return nil
+	}
+	return t.ws.Close()
+}
+
+// RtmConfig is the configuration of the RTM handler. Zero intervals are the defaults.
+type RtmConfig struct {
+	SelfId         string        // the id of the token's user, as whom messages sent from user input are put
+	PingInterval   time.Duration // how often a ping is sent, to check the connection is alive
+	TypingInterval time.Duration // the least time between typing events sent for a channel, however often the user types
+}
+
+func (c RtmConfig) pingInterval() time.Duration {
+	if c.PingInterval > 0 {
+		return c.PingInterval
+	}
+	return DefaultRtmPingInterval
+}
+
+func (c RtmConfig) typingInterval() time.Duration {
+	if c.TypingInterval > 0 {
+		return c.TypingInterval
+	}
+	return DefaultRtmTypingInterval
+}
+
+// RtmChans are the chans the RTM handler writes received events to, and gets user names from.
+type RtmChans struct {
+	PutMsg          chan<- SlackRtmMessage
+	ChangeMsg       chan<- MessageChange
+	GetUserName     chan<- UserNameRequest
+	UpdateMsgs      chan<- string // the ids of channels whose messages changed. Created by StartSlackRtmHandler.
+	PinsChanged     chan<- string // the ids of channels whose pins changed
+	PutUser         chan<- SlackUser
+	PutPresence     chan<- UserPresence
+	UsersChanged    chan<- []string // the ids of users whose presence or status changed
+	PutChannel      chan<- SlackChannel
+	ChangeChannel   chan<- ChannelChange
+	ChannelsChanged chan<- string // the ids of conversations added to, changed in, or removed from the channel list
+	GetNewest       chan<- NewestMessagesRequest
+	PutHistory      chan<- ChannelHistory
+	PutOutbox       chan<- OutboxMsg
+	Status          chan<- RtmStatus          // if not nil, the transport connecting and disconnecting is reported here
+	UserTyping      chan<- SlackRtmUserTyping // if not nil, user_typing events are written here
+}
+
+// RtmStatus is the state of the RTM websocket. If it isn't Connected, Err is why, and RetryIn is when it's
+// reconnected. If Err is an auth error, e.g. the token was revoked, it isn't reconnected.
+type RtmStatus struct {
+	Connected bool
+	Latency   time.Duration // the round trip time of the last ping, if Connected and a ping was answered
+	Err       error
+	RetryIn   time.Duration
+}
+
+// DefaultRtmPingInterval is how often a ping is sent on the RTM websocket, to check the connection is alive.
+const DefaultRtmPingInterval = 30 * time.Second
+
+// rtmMaxMissedPongs is how many pings may be unanswered before the connection is declared dead, and reconnected.
+const rtmMaxMissedPongs = 2
+
+// SlackRtmPing is a ping sent on the RTM websocket. Slack answers with a pong with the ping's id in reply_to.
+type SlackRtmPing struct {
+	Id   int    `json:"id"`
+	Type string `json:"type"`
+}
+
+type SlackRtmPong struct {
+	Type    string `json:"type"`
+	ReplyTo int    `json:"reply_to"`
+}
+
+const (
+	// rtmMinBackoff is how long the RTM handler waits to reconnect after the first failure.
+	rtmMinBackoff = time.Second
+	// rtmMaxBackoff is the longest the RTM handler waits to reconnect, however many times it failed.
+	rtmMaxBackoff = 2 * time.Minute
+	// rtmStableTime is how long a connection must last for the next disconnect to be retried after rtmMinBackoff again.
+	rtmStableTime = 30 * time.Second
+)
+
+// rtmBackoff returns how long to wait before the given reconnection attempt, starting at 0: exponential from
+// rtmMinBackoff up to rtmMaxBackoff, with jitter, so clients disconnected together don't reconnect together.
+func rtmBackoff(attempt int) time.Duration {
+	d := rtmMinBackoff
+	for i := 0; i < attempt && d < rtmMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > rtmMaxBackoff {
+		d = rtmMaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isRtmAuthError returns whether err means the token is no good, so reconnecting won't help.
+func isRtmAuthError(err error) bool {
+	switch SlackErrorCode(err) {
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "token_expired", "missing_scope", "not_allowed_token_type":
+		return true
+	}
+	return false
+}
+
+// SlackRtmItem is the item of a reaction or pin event. Only message items are handled.
+type SlackRtmItem struct {
+	Type      string           `json:"type"`
+	ChannelId string           `json:"channel"`
+	Time      string           `json:"ts"`      // the message's ts, in reaction events
+	Message   *SlackRtmMessage `json:"message"` // the message, in pin events
+}
+
+// MessageTime returns the ts of the item's message.
+func (i SlackRtmItem) MessageTime() string {
+	if i.Message != nil {
+		return i.Message.Time
+	}
+	return i.Time
+}
+
+type SlackRtmReaction struct {
+	Type     string       `json:"type"`
+	UserId   string       `json:"user"`
+	Reaction string       `json:"reaction"`
+	Item     SlackRtmItem `json:"item"`
+}
+
+type SlackRtmPin struct {
+	Type      string       `json:"type"`
+	UserId    string       `json:"user"`
+	ChannelId string       `json:"channel_id"`
+	Item      SlackRtmItem `json:"item"`
+}
+
+// SlackRtmPresence is a presence_change event. Batched events have Users, rather than User.
+type SlackRtmPresence struct {
+	Type     string   `json:"type"`
+	UserId   string   `json:"user"`
+	UserIds  []string `json:"users"`
+	Presence string   `json:"presence"`
+}
+
+// SlackRtmUserChange is a user_change event, sent when a user's profile, including their status, changes.
+type SlackRtmUserChange struct {
+	Type string    `json:"type"`
+	User SlackUser `json:"user"`
+}
+
+// SlackRtmChannelObject is a channel event with the channel object, e.g. channel_joined, channel_created, or
+// channel_rename. Only channel_joined and group_joined have the whole object, the others only have the id and name.
+type SlackRtmChannelObject struct {
+	Type    string       `json:"type"`
+	UserId  string       `json:"user"` // the other user, if this is an im_created
+	Channel SlackChannel `json:"channel"`
+}
+
+// SlackRtmChannelId is a channel event with the channel id, e.g. channel_left or channel_archive.
+type SlackRtmChannelId struct {
+	Type      string `json:"type"`
+	ChannelId string `json:"channel"`
+	UserId    string `json:"user"`
+}
+
+// rtmChannelChanges are the changes the channel events with a channel id make to the channel.
+var rtmChannelChanges = map[string]func(c *ChannelInfo){
+	`channel_left`:      func(c *ChannelInfo) { c.Member = false },
+	`group_left`:        func(c *ChannelInfo) { c.Member = false },
+	`channel_archive`:   func(c *ChannelInfo) { c.Archived = true },
+	`group_archive`:     func(c *ChannelInfo) { c.Archived = true },
+	`channel_unarchive`: func(c *ChannelInfo) { c.Archived = false },
+	`group_unarchive`:   func(c *ChannelInfo) { c.Archived = false },
+	`channel_deleted`:   func(c *ChannelInfo) { c.Deleted = true },
+	`group_deleted`:     func(c *ChannelInfo) { c.Deleted = true },
+}
+
+// SlackRtmUserTyping is a user_typing event, sent every few seconds while a user types a message in a channel.
+type SlackRtmUserTyping struct {
+	Type      string `json:"type"`
+	ChannelId string `json:"channel"`
+	UserId    string `json:"user"`
+}
+
+// SlackRtmTyping tells Slack the token's user is typing a message in the channel.
+type SlackRtmTyping struct {
+	Id        int    `json:"id"`
+	Type      string `json:"type"`
+	ChannelId string `json:"channel"`
+}
+
+// DefaultRtmTypingInterval is the least time between typing events sent for a channel, however often the user types.
+const DefaultRtmTypingInterval = 3 * time.Second
+
+// SlackRtmPresenceSub subscribes to the presence_change events of the given users, replacing any previous subscription.
+type SlackRtmPresenceSub struct {
+	Type string   `json:"type"`
+	Ids  []string `json:"ids"`
+}
+
+// handleSlackRtmMessage handles the RTM event of the given type. Events which can't be decoded are logged and dropped.
+func handleSlackRtmMessage(type_ string, data []byte, chans RtmChans, replyHandlerReceivedMsg chan<- SlackRtmReplytoMsg, pongs chan<- SlackRtmPong) {
 	tryHandleReplyto := func() bool {
 		var replyMsg SlackRtmReplytoMsg
 		if err := json.Unmarshal(data, &replyMsg); err != nil {
-			log.Panicln(err) // TODO(return error)
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return true // dropped
 		}
 		if replyMsg.ReplyTo == nil {
 			return false
@@ -127,15 +464,141 @@ func handleSlackRtmMessage(type_ string, data []byte, putChan chan<- SlackRtmMes
 	switch type_ {
 	//	case `hello`:
 	//		fmt.Printf("Received Hello: %s\n", string(data))
+	case `pong`:
+		var pong SlackRtmPong
+		if err := json.Unmarshal(data, &pong); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		pongs <- pong
 	case `message`:
 		var msg SlackRtmMessage
 		if err := json.Unmarshal(data, &msg); err != nil {
-			log.Panicln(err) // TODO(return error)
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		switch msg.Subtype {
+		case `message_replied`:
+			return // the parent of a new reply changed. The reply is its own message event, which updates the parent's reply count.
+		case `message_changed`:
+			if msg.Message == nil {
+				return
+			}
+			changed := *msg.Message
+			files := slackFilesToTermFiles(changed.Files, chans.GetUserName)
+			chans.ChangeMsg <- MessageChange{ChannelId: msg.ChannelId, Time: changed.Time, Change: func(m *TermMsg) {
+				m.Text = changed.Text
+				m.Edited = changed.Edited != nil
+				m.Subtype = changed.Subtype // e.g. tombstone, if a thread parent was deleted
+				m.Files = files
+				m.Pinned = len(changed.PinnedTo) > 0
+			}}
+			chans.UpdateMsgs <- msg.ChannelId
+			return
+		case `message_deleted`:
+			change := MessageChange{ChannelId: msg.ChannelId, Time: msg.DeletedTs, Delete: true}
+			if msg.PreviousMessage != nil {
+				change.ThreadTs = msg.PreviousMessage.ThreadTs
+			}
+			chans.ChangeMsg <- change
+			chans.UpdateMsgs <- msg.ChannelId
+			return
 		}
-		PutMessage(msg, putChan)
+		if msg.Hidden {
+			log.Println("handleSlackRtmMessage ignoring hidden message " + msg.Subtype)
+			return
+		}
+		PutMessage(msg, chans.PutMsg)
 		log.Println("handleSlackRtmMessage sending update msg " + string(data))
-		updateMsgsChan <- msg.ChannelId
+		chans.UpdateMsgs <- msg.ChannelId
 		log.Println("handleSlackRtmMessage sent update msg")
+	case `reaction_added`, `reaction_removed`:
+		var reaction SlackRtmReaction
+		if err := json.Unmarshal(data, &reaction); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		if reaction.Item.Type != `message` {
+			return
+		}
+		change := func(msg *TermMsg) { msg.Reactions = addReaction(msg.Reactions, reaction.Reaction, reaction.UserId) }
+		if type_ == `reaction_removed` {
+			change = func(msg *TermMsg) { msg.Reactions = removeReaction(msg.Reactions, reaction.Reaction, reaction.UserId) }
+		}
+		chans.ChangeMsg <- MessageChange{ChannelId: reaction.Item.ChannelId, Time: reaction.Item.MessageTime(), Change: change}
+		chans.UpdateMsgs <- reaction.Item.ChannelId
+	case `pin_added`, `pin_removed`:
+		var pin SlackRtmPin
+		if err := json.Unmarshal(data, &pin); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		if pin.Item.Type != `message` {
+			return
+		}
+		pinned := type_ == `pin_added`
+		chans.ChangeMsg <- MessageChange{ChannelId: pin.ChannelId, Time: pin.Item.MessageTime(), Change: func(msg *TermMsg) { msg.Pinned = pinned }}
+		chans.UpdateMsgs <- pin.ChannelId
+		chans.PinsChanged <- pin.ChannelId
+	case `presence_change`:
+		var presence SlackRtmPresence
+		if err := json.Unmarshal(data, &presence); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		ids := presence.UserIds
+		if presence.UserId != "" {
+			ids = append(ids, presence.UserId)
+		}
+		for _, id := range ids {
+			chans.PutPresence <- UserPresence{Id: id, Presence: presence.Presence}
+		}
+		chans.UsersChanged <- ids
+	case `channel_joined`, `channel_created`, `group_joined`, `im_created`, `channel_rename`, `group_rename`:
+		var event SlackRtmChannelObject
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		channel := event.Channel
+		switch type_ {
+		case `channel_rename`, `group_rename`:
+			chans.ChangeChannel <- ChannelChange{Id: channel.Id, Change: func(c *ChannelInfo) { c.Name = channel.Name }}
+		case `im_created`:
+			channel.IsIm = true
+			if channel.User == "" {
+				channel.User = event.UserId
+			}
+			chans.PutChannel <- channel
+		default:
+			chans.PutChannel <- channel
+		}
+		chans.ChannelsChanged <- channel.Id
+	case `channel_left`, `group_left`, `channel_archive`, `group_archive`, `channel_unarchive`, `group_unarchive`, `channel_deleted`, `group_deleted`:
+		var event SlackRtmChannelId
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		chans.ChangeChannel <- ChannelChange{Id: event.ChannelId, Change: rtmChannelChanges[type_]}
+		chans.ChannelsChanged <- event.ChannelId
+	case `user_typing`:
+		var typing SlackRtmUserTyping
+		if err := json.Unmarshal(data, &typing); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		if chans.UserTyping != nil {
+			chans.UserTyping <- typing
+		}
+	case `user_change`:
+		var change SlackRtmUserChange
+		if err := json.Unmarshal(data, &change); err != nil {
+			log.Println("handleSlackRtmMessage error decoding " + type_ + ": " + err.Error())
+			return
+		}
+		chans.PutUser <- change.User
+		chans.UsersChanged <- []string{change.User.Id}
 	default:
 		if tryHandleReplyto() {
 			return
@@ -147,91 +610,386 @@ func handleSlackRtmMessage(type_ string, data []byte, putChan chan<- SlackRtmMes
 type PutRtmMsg struct {
 	ChannelId string
 	Msg       string
+	ThreadTs  string // if not empty, the message is a reply to this thread
+	Broadcast bool   // whether a thread reply is also sent to the channel
 }
 
+// OutboxRetry retries sending a message from user input which failed, or discards it.
+type OutboxRetry struct {
+	Id      int // the outbox id of the message
+	Discard bool
+}
+
+// rtmUnconfirmedError is why a message fails if the websocket is disconnected before Slack acks it. It may or may not
+// have been sent, so it isn't sent again unless it's retried.
+const rtmUnconfirmedError = "disconnected before Slack confirmed it"
+
 type SlackRtmSendMessage struct {
-	Id        int    `json:"id"`
-	Type      string `json:"type"`
-	ChannelId string `json:"channel"`
-	Text      string `json:"text"`
+	Id             int    `json:"id"`
+	Type           string `json:"type"`
+	ChannelId      string `json:"channel"`
+	Text           string `json:"text"`
+	ThreadTs       string `json:"thread_ts,omitempty"`
+	ReplyBroadcast bool   `json:"reply_broadcast,omitempty"`
 }
 
-func SlackRtmSendHandler(ws *websocket.Conn, put <-chan PutRtmMsg, replyHandlerSentMsg chan<- SlackRtmSendMessage) {
-	nextid := 0
+// SlackRtmSendHandler sends messages and presence subscriptions on the transport most recently received from conns,
+// which is nil while disconnected.
+// It's the outbox of messages from user input, which are put as from config.SelfId to chans.PutOutbox as soon as they're
+// put, and again when Slack acks them, or sending them fails. Messages wait while there's no connection, and those
+// sent on a connection which is lost before they're acked fail, because they may have been sent. Failed messages
+// are sent again, or discarded, when they're retried.
+// The presence subscription is sent again on each new connection.
+// The ids of channels the user is typing in are received from typing, and a typing event is sent for each at most
+// every config.TypingInterval.
+// It also pings Slack every config.PingInterval, reporting the latency of each pong to chans.Status, and closes the
+// connection if rtmMaxMissedPongs pings in a row are unanswered, so the RTM handler reconnects.
+func SlackRtmSendHandler(ctx context.Context, config RtmConfig, conns <-chan RealtimeTransport, put <-chan PutRtmMsg, retry <-chan OutboxRetry, typing <-chan string, subscribePresence <-chan []string, pongs <-chan SlackRtmPong, replies <-chan SlackRtmReplytoMsg, chans RtmChans) {
+	nextid := 0 // ids aren't reused on a new connection, so late replies can't be mistaken for replies to new messages
+	var conn RealtimeTransport
+	var presenceIds []string
+	pings := make(map[int]time.Time) // map[id]sent, of the unanswered pings on conn
+	ticker := time.NewTicker(config.pingInterval())
+	defer ticker.Stop()
+	subscribe := func() {
+		if conn == nil || presenceIds == nil {
+			return
+		}
+		if err := conn.Send(SlackRtmPresenceSub{Type: "presence_sub", Ids: presenceIds}); err != nil {
+			log.Println("error subscribing to presence: " + err.Error())
+		}
+	}
+
+	typed := make(map[string]time.Time) // map[channelId]sent, of the last typing event sent for each channel
+
+	nextOutboxId := 1
+	outbox := make(map[int]OutboxMsg) // map[outboxId]msg, of the messages which aren't acked
+	var queue []int                   // the outbox ids of the messages waiting to be sent, oldest first
+	sent := make(map[int]int)         // map[id]outboxId, of the messages sent on conn and not acked
+	putOutbox := func(o OutboxMsg) {
+		chans.PutOutbox <- o
+		chans.UpdateMsgs <- o.ChannelId
+	}
+	send := func() {
+		for conn != nil && len(queue) > 0 {
+			o := outbox[queue[0]]
+			sendmsg := SlackRtmSendMessage{Id: nextid, Type: "message", ChannelId: o.ChannelId, Text: o.Msg, ThreadTs: o.ThreadTs, ReplyBroadcast: o.Broadcast}
+			if err := conn.Send(sendmsg); err != nil {
+				log.Println("error sending message: " + err.Error())
+				return // it's sent on the next connection
+			}
+			sent[nextid] = o.Id
+			nextid++
+			queue = queue[1:]
+		}
+	}
+	fail := func(id int, err string) {
+		o := outbox[id]
+		o.Err = err
+		outbox[id] = o
+		putOutbox(o)
+	}
+
 	for {
 		select {
-		case p := <-put:
-			// this could be sent off in a goroutine, for performance
-			sendmsg := SlackRtmSendMessage{Id: nextid, Type: "message", ChannelId: p.ChannelId, Text: p.Msg}
-			websocket.JSON.Send(ws, sendmsg)
-			replyHandlerSentMsg <- sendmsg
+		case <-ctx.Done():
+			return
+		case conn = <-conns:
+			pings = make(map[int]time.Time)
+			for _, id := range sent {
+				fail(id, rtmUnconfirmedError)
+			}
+			sent = make(map[int]int)
+			subscribe()
+			send()
+		case presenceIds = <-subscribePresence:
+			subscribe()
+		case channelId := <-typing:
+			if conn == nil || time.Since(typed[channelId]) < config.typingInterval() {
+				continue
+			}
+			if err := conn.Send(SlackRtmTyping{Id: nextid, Type: "typing", ChannelId: channelId}); err != nil {
+				log.Println("error sending typing: " + err.Error())
+			}
+			typed[channelId] = time.Now()
 			nextid++
+		case <-ticker.C:
+			if conn == nil {
+				continue
+			}
+			if len(pings) >= rtmMaxMissedPongs {
+				log.Printf("SlackRtmSendHandler %d pings unanswered, closing the connection\n", len(pings))
+				conn.Close() // the receive handler fails, and the RTM handler reconnects
+				conn = nil
+				continue
+			}
+			if err := conn.Send(SlackRtmPing{Id: nextid, Type: "ping"}); err != nil {
+				log.Println("error sending ping: " + err.Error())
+			}
+			pings[nextid] = time.Now()
+			nextid++
+		case pong := <-pongs:
+			sentAt, ok := pings[pong.ReplyTo]
+			if !ok {
+				continue // a pong from a previous connection
+			}
+			for id := range pings {
+				if id <= pong.ReplyTo {
+					delete(pings, id) // older pings are answered too, the connection is alive
+				}
+			}
+			sendRtmStatus(ctx, chans, RtmStatus{Connected: true, Latency: time.Since(sentAt)})
+		case p := <-put:
+			o := OutboxMsg{Id: nextOutboxId, PutRtmMsg: p, UserId: config.SelfId}
+			nextOutboxId++
+			outbox[o.Id] = o
+			queue = append(queue, o.Id)
+			putOutbox(o)
+			send()
+		case r := <-retry:
+			o, ok := outbox[r.Id]
+			if !ok || o.Err == "" {
+				continue // it was acked, or is being sent
+			}
+			if r.Discard {
+				delete(outbox, r.Id)
+				o.Discard = true
+				putOutbox(o)
+				continue
+			}
+			o.Err = ""
+			outbox[r.Id] = o
+			queue = append(queue, r.Id)
+			putOutbox(o)
+			send()
+		case r := <-replies:
+			id, ok := sent[*r.ReplyTo]
+			if !ok {
+				log.Printf("SlackRtmSendHandler got reply to unknown id: %v\n", r)
+				continue
+			}
+			delete(sent, *r.ReplyTo)
+			if !r.Ok {
+				err := "Slack didn't accept it"
+				if r.Error != nil && r.Error.Msg != "" {
+					err = r.Error.Msg
+				}
+				log.Printf("SlackRtmSendHandler message %d failed: %v\n", id, err)
+				fail(id, err)
+				continue
+			}
+			o := outbox[id]
+			delete(outbox, id)
+			o.Time = r.Time
+			putOutbox(o)
 		}
 	}
 }
 
-func SlackRtmReceiveHandler(ws *websocket.Conn, putChan chan<- SlackRtmMessage, updateMsgsChan chan<- string, replyHandlerReceivedMsg chan<- SlackRtmReplytoMsg) {
+// SlackRtmReceiveHandler handles the events received on the transport, until receiving fails, e.g. because the
+// connection was lost, or ctx is done and it was closed. It returns the error.
+// Pongs are handled as soon as they're received. Other events are queued, and handled in order by another goroutine,
+// so pongs aren't delayed while the managers are busy, e.g. fetching history, and the connection isn't declared dead.
+// It returns after the queued events are handled, or ctx is done.
+func SlackRtmReceiveHandler(ctx context.Context, transport RealtimeTransport, chans RtmChans, replyHandlerReceivedMsg chan<- SlackRtmReplytoMsg, pongs chan<- SlackRtmPong) error {
+	events := make(chan rtmEvent)
+	handled := make(chan struct{})
+	go func() {
+		for e := range queueRtmEvents(events) {
+			handleSlackRtmMessage(e.type_, e.data, chans, replyHandlerReceivedMsg, pongs)
+		}
+		close(handled)
+	}()
+	defer func() {
+		close(events)
+		select {
+		case <-handled:
+		case <-ctx.Done():
+		}
+	}()
+
 	for {
-		var data []byte
-		err := websocket.Message.Receive(ws, &data)
+		data, err := transport.Receive()
 		if err != nil {
-			log.Panicln(err) // TODO(return error)
+			return err
 		}
 		var msgType SlackRtmType
-		if err = json.Unmarshal(data, &msgType); err != nil {
-			log.Panicln(err) // TODO(return error)
+		if err := json.Unmarshal(data, &msgType); err != nil {
+			log.Println("SlackRtmReceiveHandler error decoding event: " + err.Error())
+			continue
+		}
+		switch msgType.Type {
+		case `goodbye`:
+			return errors.New("Slack closed the RTM connection")
+		case `pong`:
+			handleSlackRtmMessage(msgType.Type, data, chans, replyHandlerReceivedMsg, pongs)
+		default:
+			events <- rtmEvent{type_: msgType.Type, data: data}
 		}
-		handleSlackRtmMessage(msgType.Type, data, putChan, updateMsgsChan, replyHandlerReceivedMsg)
 	}
 }
 
-func SlackRtmSentReplyHandler(sentMsg <-chan SlackRtmSendMessage, receivedReply <-chan SlackRtmReplytoMsg, putChan chan<- SlackRtmMessage, updateMsgsChan chan<- string) {
-	sents := make(map[int]SlackRtmSendMessage)
-	for {
-		select {
-		case s := <-sentMsg:
-			if _, ok := sents[s.Id]; ok {
-				log.Printf("SlackRtmSentReplyHandler got sentMsg id which already exists: %v\n", s) // TODO(panic?)
-				continue
+// rtmEvent is a received RTM event, waiting to be handled.
+type rtmEvent struct {
+	type_ string
+	data  []byte
+}
+
+// queueRtmEvents returns a chan which receives the events sent to in, in order, without blocking the sender while
+// they aren't received. It's closed after in is closed, and every queued event is received.
+func queueRtmEvents(in <-chan rtmEvent) <-chan rtmEvent {
+	out := make(chan rtmEvent)
+	go func() {
+		defer close(out)
+		var queue []rtmEvent
+		for in != nil || len(queue) > 0 {
+			var next rtmEvent
+			var send chan<- rtmEvent // nil while the queue is empty, so it isn't selected
+			if len(queue) > 0 {
+				next, send = queue[0], out
 			}
-			sents[s.Id] = s
-		case r := <-receivedReply:
-			if r.ReplyTo == nil {
-				log.Printf("SlackRtmSentReplyHandler got nil reply: %v\n", r) // TODO(panic?)
-				continue
+			select {
+			case e, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				queue = append(queue, e)
+			case send <- next:
+				queue = queue[1:]
 			}
-			if _, ok := sents[*r.ReplyTo]; !ok {
-				log.Printf("SlackRtmSentReplyHandler got receivedReply id which does not exist: %v\n", r) // TODO(panic? Print anyway?)
-				continue
+		}
+	}()
+	return out
+}
+
+// SlackRtmHandler connects the transport, and handles its events until ctx is done. When it's disconnected, it
+// reconnects with backoff, and backfills the messages missed while disconnected with the client. If Slack rejects the
+// token, it reports the error to chans.Status, and stops reconnecting.
+func SlackRtmHandler(ctx context.Context, client *SlackClient, transport RealtimeTransport, config RtmConfig, chans RtmChans, sendMsgChan <-chan PutRtmMsg, retryMsgChan <-chan OutboxRetry, typingChan <-chan string, subscribePresenceChan <-chan []string) {
+	replies := make(chan SlackRtmReplytoMsg)
+	conns := make(chan RealtimeTransport)
+	pongs := make(chan SlackRtmPong)
+	go SlackRtmSendHandler(ctx, config, conns, sendMsgChan, retryMsgChan, typingChan, subscribePresenceChan, pongs, replies, chans)
+
+	attempt := 0
+	for connected := false; ; connected = true {
+		connCtx, cancel := context.WithCancel(ctx)
+		err := transport.Connect(connCtx)
+		for err != nil {
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if isRtmAuthError(err) {
+				log.Println("SlackRtmHandler not reconnecting, the token was rejected: " + err.Error())
+				sendRtmStatus(ctx, chans, RtmStatus{Err: err})
+				return
 			}
-			sendmsg := sents[*r.ReplyTo]
-			msg := SlackRtmMessage{Type: sendmsg.Type, ChannelId: sendmsg.ChannelId, UserId: "me", Text: sendmsg.Text, Time: r.Time}
-			PutMessage(msg, putChan)
-			updateMsgsChan <- msg.ChannelId
-			delete(sents, *r.ReplyTo)
+			wait := rtmBackoff(attempt)
+			attempt++
+			log.Printf("SlackRtmHandler error connecting, retrying in %v: %v\n", wait, err)
+			sendRtmStatus(ctx, chans, RtmStatus{Err: err, RetryIn: wait})
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			connCtx, cancel = context.WithCancel(ctx)
+			err = transport.Connect(connCtx)
+		}
+		sendRtmStatus(ctx, chans, RtmStatus{Connected: true})
+		select {
+		case conns <- transport:
+		case <-ctx.Done():
+			cancel()
+			return
 		}
+		if connected {
+			go backfillSlackRtm(connCtx, client, chans)
+		}
+
+		start := time.Now()
+		err = SlackRtmReceiveHandler(connCtx, transport, chans, replies, pongs)
+		cancel() // closes the connection, and stops the backfill
+		select {
+		case conns <- nil:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) >= rtmStableTime {
+			attempt = 0
+		}
+		log.Println("SlackRtmHandler disconnected, reconnecting: " + err.Error())
+		sendRtmStatus(ctx, chans, RtmStatus{Err: err})
 	}
 }
 
-func SlackRtmHandler(token string, putChan chan<- SlackRtmMessage, updateMsgsChan chan<- string, sendMsgChan <-chan PutRtmMsg) {
-	ws, err := ConnectToSlackRtm(token)
-	if err != nil {
-		log.Panicln(err)
+// sendRtmStatus reports the status to chans.Status, if it isn't nil.
+func sendRtmStatus(ctx context.Context, chans RtmChans, status RtmStatus) {
+	if chans.Status == nil {
+		return
+	}
+	select {
+	case chans.Status <- status:
+	case <-ctx.Done():
 	}
+}
+
+// rtmBackfillLimit is how many of the newest messages of each channel the backfill gets in one request.
+const rtmBackfillLimit = 100
 
-	replyHandlerSentMsg := make(chan SlackRtmSendMessage)
-	replyHandlerReceivedReply := make(chan SlackRtmReplytoMsg)
-	go SlackRtmSentReplyHandler(replyHandlerSentMsg, replyHandlerReceivedReply, putChan, updateMsgsChan)
-	go SlackRtmSendHandler(ws, sendMsgChan, replyHandlerSentMsg)
-	SlackRtmReceiveHandler(ws, putChan, updateMsgsChan, replyHandlerReceivedReply) // don't go, so this function doesn't return.
+// backfillSlackRtm gets the messages of every loaded channel newer than the newest stored, which were sent while the
+// websocket was disconnected, and puts them. Messages also received on the new websocket are only stored once.
+// The newest rtmBackfillLimit messages are got first, which also updates the reply counts of stored thread parents
+// among them. Only if they're all newer than the newest stored are the rest paged, so channels loaded without messages
+// don't get their whole history.
+func backfillSlackRtm(ctx context.Context, client *SlackClient, chans RtmChans) {
+	for channelId, newest := range GetNewestMessages(chans.GetNewest) {
+		msgs, err := client.GetNewestSlackMessages(ctx, channelId, rtmBackfillLimit)
+		if err == nil && newest != "" && len(msgs) == rtmBackfillLimit && tsLess(newest, msgs[len(msgs)-1].Time) {
+			msgs, err = client.GetSlackMessagesSince(ctx, channelId, newest)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("backfillSlackRtm error getting messages for " + channelId + ": " + err.Error())
+			continue
+		}
+		select {
+		case chans.PutHistory <- ChannelHistory{ChannelId: channelId, Msgs: msgs}:
+		case <-ctx.Done():
+			return
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		select {
+		case chans.UpdateMsgs <- channelId:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-// StartSlackRtmHandler starts the slack RTM handler goroutine, and returns a channel to
+// StartSlackRtmHandler starts the slack RTM handler goroutine, which connects the transport, and returns a channel to
 // which will be written the channel id of channels which recieve new messages.
-// Also returns a chan to which will be written messages to send from user input.
-func StartSlackRtmHandler(token string, putChan chan<- SlackRtmMessage) (<-chan string, chan<- PutRtmMsg) {
+// Also returns a chan to which will be written messages to send from user input, a chan to which will be written
+// failed messages to retry or discard, a chan to which will be written the ids of channels the user is typing in, and
+// a chan to which will be written the ids of the users whose presence_change events are subscribed to.
+// The UpdateMsgs member of chans is ignored, and set to the returned channel.
+// Messages sent from user input are put as from config.SelfId, the token's user.
+// The handler stops, and its connection is closed, when ctx is done.
+func StartSlackRtmHandler(ctx context.Context, client *SlackClient, transport RealtimeTransport, config RtmConfig, chans RtmChans) (<-chan string, chan<- PutRtmMsg, chan<- OutboxRetry, chan<- string, chan<- []string) {
 	updateMsgsChan := make(chan string)
 	sendMsgChan := make(chan PutRtmMsg)
-	go SlackRtmHandler(token, putChan, updateMsgsChan, sendMsgChan)
-	return updateMsgsChan, sendMsgChan
+	retryMsgChan := make(chan OutboxRetry)
+	typingChan := make(chan string)
+	subscribePresenceChan := make(chan []string)
+	chans.UpdateMsgs = updateMsgsChan
+	go SlackRtmHandler(ctx, client, transport, config, chans, sendMsgChan, retryMsgChan, typingChan, subscribePresenceChan)
+	return updateMsgsChan, sendMsgChan, retryMsgChan, typingChan, subscribePresenceChan
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestHandleUndecodableEvents(t *testing.T) {
+	// no chans are set, so handling would block or panic if an event weren't dropped
+	types := []string{`pong`, `message`, `reaction_added`, `pin_added`, `presence_change`, `channel_joined`, `channel_left`, `user_typing`, `user_change`, `unknown`}
+	for _, type_ := range types {
+		handleSlackRtmMessage(type_, []byte(`["not an object"]`), RtmChans{}, nil, nil)
+	}
+}
+
+func TestRtmBackoff(t *testing.T) {
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		for i := 0; i < 50; i++ {
+			if wait := rtmBackoff(attempt); wait < max/2 || wait > max {
+				t.Fatalf("expected attempt %d to wait between %v and %v, got %v", attempt, max/2, max, wait)
+			}
+		}
+	}
+	if wait := rtmBackoff(100); wait < rtmMaxBackoff/2 || wait > rtmMaxBackoff {
+		t.Fatalf("expected the wait capped at %v, got %v", rtmMaxBackoff, wait)
+	}
+}
+
+func TestReconnectBackfill(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "one"})
+	status := make(chan RtmStatus, 10)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{Status: status})
+	if st := <-status; !st.Connected {
+		t.Fatalf("expected connected, got %+v", st)
+	}
+	if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %v", msgs)
+	}
+
+	// messages sent while disconnected, and the first reconnect failing
+	two := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "two"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "three"})
+	s.FailRtm(1)
+	s.DropConns()
+	if st := <-status; st.Connected || st.Err == nil || st.RetryIn != 0 {
+		t.Fatalf("expected disconnected, got %+v", st)
+	}
+	if st := <-status; st.Connected || st.RetryIn == 0 {
+		t.Fatalf("expected a retry after the failed reconnect, got %+v", st)
+	}
+	if st := <-status; !st.Connected {
+		t.Fatalf("expected reconnected, got %+v", st)
+	}
+	select {
+	case id := <-pipe.updateMsgs:
+		if id != "C1" {
+			t.Fatalf("expected the backfill to update C1, got %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the backfill")
+	}
+	msgs, _ := GetMessages("C1", pipe.getMsgs)
+	if len(msgs) != 3 || msgs[0].Text != "three" || msgs[1].Text != "two" || msgs[2].Text != "one" {
+		t.Fatalf("expected the missed messages backfilled, got %v", msgs)
+	}
+
+	// a backfilled message received again, and a message sent on the new connection
+	s.Push(map[string]interface{}{"type": "message", "channel": "C1", "ts": two.Ts, "user": "U1", "text": "two"})
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "four"}
+	<-pipe.updateMsgs // the message received again
+	<-pipe.updateMsgs // shown pending
+	<-pipe.updateMsgs // acked
+	msgs, _ = GetMessages("C1", pipe.getMsgs)
+	if len(msgs) != 4 || msgs[0].Text != "four" {
+		t.Fatalf("expected the sent message stored, and the backfilled one once, got %v", msgs)
+	}
+}
+
+func TestRtmAuthError(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	client := testClient(t, s)
+	client.Token = "xoxp-revoked"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the handler returns, rather than reconnecting, and messages sent meanwhile wait in the outbox
+	status := make(chan RtmStatus, 10)
+	outbox := make(chan OutboxMsg, 10)
+	send := make(chan PutRtmMsg)
+	SlackRtmHandler(ctx, client, NewRtmTransport(client), RtmConfig{SelfId: "U0SELF"}, RtmChans{Status: status, PutOutbox: outbox}, send, nil, nil, nil)
+	if st := <-status; st.Connected || SlackErrorCode(st.Err) != "invalid_auth" || st.RetryIn != 0 {
+		t.Fatalf("expected the auth error, and no retry, got %+v", st)
+	}
+	send <- PutRtmMsg{ChannelId: "C1", Msg: "unsent"}
+	if o := <-outbox; o.Msg != "unsent" || o.Time != "" || o.Err != "" {
+		t.Fatalf("expected the message pending, got %+v", o)
+	}
+	if text := rtmStatusText(RtmStatus{Err: &ApiError{Method: "rtm.connect", Code: "invalid_auth"}}); text == rtmStatusText(RtmStatus{}) {
+		t.Fatalf("expected the auth error shown, got %q", text)
+	}
+}
+
+func TestBackfillLimitsAndReplyCounts(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddChannel(slacktest.Channel{Id: "C2", Name: "empty"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	p := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "parent"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "r1", ThreadTs: p.Ts})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "newest"})
+	status := make(chan RtmStatus, 10)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{Status: status})
+	<-status
+	for _, id := range []string{"C1", "C2"} {
+		if _, err := GetMessages(id, pipe.getMsgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a reply to an old parent, and more messages in the empty channel than one request gets, while disconnected
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "r2", ThreadTs: p.Ts})
+	for i := 0; i < rtmBackfillLimit+50; i++ {
+		s.AddMessage("C2", slacktest.Message{User: "U1", Text: "missed"})
+	}
+	s.DropConns()
+	for st := <-status; !st.Connected; st = <-status {
+	}
+	for updated := map[string]bool{}; !updated["C1"] || !updated["C2"]; {
+		select {
+		case id := <-pipe.updateMsgs:
+			updated[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the backfill, got %v", updated)
+		}
+	}
+	if msgs, _ := GetMessages("C1", pipe.getMsgs); len(msgs) != 2 || msgs[1].ReplyCount != 2 {
+		t.Fatalf("expected the parent's missed reply counted, got %v", msgs)
+	}
+	if msgs, _ := GetMessages("C2", pipe.getMsgs); len(msgs) != rtmBackfillLimit {
+		t.Fatalf("expected the empty channel to get %d messages, not its whole history, got %d", rtmBackfillLimit, len(msgs))
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	client := testClient(t, s)
+	client.Scheduler.Tiers = map[string]SlackTier{"rtm.connect": Tier4}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	status := make(chan RtmStatus, 100)
+	StartSlackRtmHandler(ctx, client, NewRtmTransport(client), RtmConfig{SelfId: "U0SELF", PingInterval: 50 * time.Millisecond}, RtmChans{Status: status})
+	if st := <-status; !st.Connected || st.Latency != 0 {
+		t.Fatalf("expected connected, without a latency yet, got %+v", st)
+	}
+	if st := <-status; !st.Connected || st.Latency <= 0 {
+		t.Fatalf("expected the latency of the first ping, got %+v", st)
+	}
+
+	// the connection is half-open, so pongs stop, and it's declared dead and reconnected
+	s.IgnorePings(true)
+	for st := range status {
+		if !st.Connected {
+			break
+		}
+	}
+	s.IgnorePings(false)
+	for st := <-status; !st.Connected; st = <-status {
+	}
+	if st := <-status; !st.Connected || st.Latency <= 0 {
+		t.Fatalf("expected the latency of a ping on the new connection, got %+v", st)
+	}
+	if title := rtmStatusTitle(RtmStatus{Connected: true, Latency: 41600 * time.Microsecond}); title != "Slack 42ms" {
+		t.Fatalf("expected Slack 42ms, got %q", title)
+	}
+}
+
+func TestKeepaliveWhileBusy(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nothing receives the message, as if the messages manager were busy fetching history
+	status := make(chan RtmStatus, 100)
+	StartSlackRtmHandler(ctx, client, NewRtmTransport(client), RtmConfig{SelfId: "U0SELF", PingInterval: 50 * time.Millisecond}, RtmChans{PutMsg: make(chan SlackRtmMessage), Status: status})
+	<-status
+	waitConns(t, s)
+	if _, err := s.PushMessage("C1", slacktest.Message{User: "U1", Text: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for pongs := 0; pongs < 5; {
+		select {
+		case st := <-status:
+			if !st.Connected {
+				t.Fatalf("expected pongs handled while events wait, got disconnected %+v", st)
+			}
+			if st.Latency > 0 {
+				pongs++
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for pongs while events wait, got %d", pongs)
+		}
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "one"})
+	status := make(chan RtmStatus, 100)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF"}, RtmChans{Status: status})
+	go func() {
+		for range pipe.updateMsgs {
+		}
+	}()
+	if _, err := GetMessages("C1", pipe.getMsgs); err != nil {
+		t.Fatal(err)
+	}
+	waitConns(t, s)
+
+	// failed, then retried
+	s.FailSends(1, "message_too_long")
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "two"}
+	msgs := waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].SendError != "" })
+	if len(msgs) != 2 || msgs[0].SendError != "message_too_long" || msgs[0].Sending || msgs[0].Text != "two" {
+		t.Fatalf("expected two failed, got %v", msgs)
+	}
+	pipe.retry <- OutboxRetry{Id: msgs[0].OutboxId}
+	msgs = waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].Time != "" && len(m) == 2 })
+	if msgs[0].Text != "two" || msgs[0].OutboxId != 0 || msgs[1].Text != "one" {
+		t.Fatalf("expected two acked, got %v", msgs)
+	}
+
+	// echoed back before the ack, and shown once
+	s.EchoSends(true)
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "three"}
+	waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].Text == "three" && m[0].Time != "" })
+	time.Sleep(50 * time.Millisecond)
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); len(msgs) != 3 {
+		t.Fatalf("expected three once, got %v", msgs)
+	}
+	s.EchoSends(false)
+
+	// unacked when the connection drops, so failed, then discarded
+	s.IgnoreSends(true)
+	for len(s.Sent()) > 0 {
+		<-s.Sent()
+	}
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "four"}
+	<-s.Sent()
+	if msgs, _ = GetMessages("C1", pipe.getMsgs); !msgs[0].Sending || msgs[0].Text != "four" || msgs[0].Time != "" {
+		t.Fatalf("expected four sending, got %v", msgs)
+	}
+	s.IgnoreSends(false)
+	s.DropConns()
+	msgs = waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].SendError == rtmUnconfirmedError })
+	pipe.retry <- OutboxRetry{Id: msgs[0].OutboxId, Discard: true}
+	waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return len(m) == 3 && m[0].Text == "three" })
+
+	// sent while disconnected, so queued until the reconnect
+	waitConns(t, s)
+	s.FailRtm(1)
+	s.DropConns()
+	for st := <-status; st.RetryIn == 0; st = <-status { // until reconnecting failed, and waits to retry
+	}
+	pipe.send <- PutRtmMsg{ChannelId: "C1", Msg: "five"}
+	waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].Sending && m[0].Text == "five" })
+	msgs = waitMsgs(t, "C1", pipe.getMsgs, func(m []TermMsg) bool { return m[0].Text == "five" && m[0].Time != "" })
+	if len(msgs) != 4 {
+		t.Fatalf("expected five sent after the reconnect, got %v", msgs)
+	}
+}
+
+func TestTyping(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	userTyping := make(chan SlackRtmUserTyping)
+	pipe := startPipeline(t, s, RtmConfig{SelfId: "U0SELF", TypingInterval: 300 * time.Millisecond}, RtmChans{UserTyping: userTyping})
+	waitConns(t, s)
+
+	// others typing
+	s.PushTyping("C1", "U1")
+	if ev := <-userTyping; ev.ChannelId != "C1" || ev.UserId != "U1" {
+		t.Fatalf("expected U1 typing in C1, got %+v", ev)
+	}
+
+	// typing is sent at most once per interval per channel
+	for start := time.Now(); time.Since(start) < 500*time.Millisecond; time.Sleep(20 * time.Millisecond) {
+		pipe.typing <- "C1"
+	}
+	pipe.typing <- "C2"
+	sent := map[string]int{}
+	for timeout := time.After(300 * time.Millisecond); ; {
+		select {
+		case m := <-s.Sent():
+			if m.Type != "typing" {
+				t.Fatalf("expected only typing sent, got %+v", m)
+			}
+			sent[m.ChannelId]++
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	if sent["C1"] != 2 || sent["C2"] != 1 {
+		t.Fatalf("expected typing sent twice to C1 and once to C2, got %v", sent)
+	}
+}
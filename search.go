@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// DefaultSearchCount is the number of search results requested.
+const DefaultSearchCount = 50
+
+// SlackSearchChannel is the conversation of a search match.
+type SlackSearchChannel struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	IsChannel bool   `json:"is_channel"`
+	IsGroup   bool   `json:"is_group"`
+	IsIm      bool   `json:"is_im"`
+	IsMpim    bool   `json:"is_mpim"`
+}
+
+// SlackSearchMatch is a message found by search.messages.
+type SlackSearchMatch struct {
+	Type      string             `json:"type"`
+	User      string             `json:"user"`
+	Username  string             `json:"username"`
+	Time      string             `json:"ts"`
+	Text      string             `json:"text"`
+	Channel   SlackSearchChannel `json:"channel"`
+	Permalink string             `json:"permalink"`
+}
+
+type SlackSearchPaging struct {
+	Count int `json:"count"`
+	Total int `json:"total"`
+	Page  int `json:"page"`
+	Pages int `json:"pages"`
+}
+
+type SlackSearchMessages struct {
+	Total   int                `json:"total"`
+	Matches []SlackSearchMatch `json:"matches"`
+	Paging  SlackSearchPaging  `json:"paging"`
+}
+
+type SlackSearch struct {
+	Ok       bool                `json:"ok"`
+	Query    string              `json:"query"`
+	Messages SlackSearchMessages `json:"messages"`
+}
+
+// SearchSlackMessages returns the newest messages matching the query, which is passed to Slack as typed, so
+// modifiers like in:#channel, from:@user and before:2024-01-31 work as they do in Slack. Only the first page of
+// results is returned, with the total number of matches.
+func (c *SlackClient) SearchSlackMessages(ctx context.Context, query string) (SlackSearchMessages, error) {
+	params := url.Values{
+		"query":    {query},
+		"count":    {strconv.Itoa(DefaultSearchCount)},
+		"sort":     {"timestamp"},
+		"sort_dir": {"desc"},
+	}
+	var search SlackSearch
+	if err := c.apiGet(ctx, `search.messages`, params, &search); err != nil {
+		return SlackSearchMessages{}, err
+	}
+	return search.Messages, nil
+}
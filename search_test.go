@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestSearch(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	s.AddChannel(slacktest.Channel{Id: "C2", Name: "ops"})
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(slacktest.User{Id: "U2", Name: "bob"})
+	s.AddMessage("C1", slacktest.Message{User: "U1", Text: "Deploy failed"})
+	s.AddMessage("C2", slacktest.Message{User: "U2", Text: "deploy ok"})
+	s.AddMessage("C2", slacktest.Message{User: "U1", Text: "other"})
+	client := testClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found, err := client.SearchSlackMessages(ctx, "deploy")
+	if err != nil || found.Total != 2 || found.Matches[0].Channel.Id != "C2" || found.Matches[0].Username != "bob" {
+		t.Fatalf("expected both deploys, newest first, got %v %v", found, err)
+	}
+	if found, _ = client.SearchSlackMessages(ctx, "deploy from:@alice"); found.Total != 1 || found.Matches[0].Channel.Name != "general" {
+		t.Fatalf("expected alice's deploy in general, got %v", found)
+	}
+	if found, _ = client.SearchSlackMessages(ctx, "in:#ops"); found.Total != 2 {
+		t.Fatalf("expected the 2 messages in ops, got %v", found)
+	}
+
+	// a result's context is the messages around it
+	match := found.Matches[1]
+	msgs, err := client.GetSlackMessages(ctx, "C2", addTs(match.Time, -time.Hour), addTs(match.Time, time.Hour))
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("expected the messages around the result, got %v %v", msgs, err)
+	}
+	if sec := tsTime("1400000001.500000").Unix(); sec != 1400000001 {
+		t.Fatalf("expected 1400000001, got %d", sec)
+	}
+}
@@ -1,28 +1,258 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const DefaultSlackApiUrl = `https://slack.com/api/`
+
+// DefaultTimeout is the default deadline of each HTTP request to Slack, not including time waiting for rate limits.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultPageSize is the number of items requested per page of list calls. Slack recommends no more than 200.
+const DefaultPageSize = 200
+
+// SlackClient makes Slack Web API calls. The base URL and HTTP client may be
+// changed, to point it at a proxy, a recorded-fixture server, or a test double.
+type SlackClient struct {
+	Token      string
+	BaseUrl    string // must end in a slash, e.g. https://slack.com/api/
+	HttpClient *http.Client
+	PageSize   int           // the number of items requested per page of list calls
+	Timeout    time.Duration // the deadline of each HTTP request, or 0 for none
+	Scheduler  *RequestScheduler
+}
+
+func NewSlackClient(token string) *SlackClient {
+	return &SlackClient{
+		Token:      token,
+		BaseUrl:    DefaultSlackApiUrl,
+		HttpClient: http.DefaultClient,
+		PageSize:   DefaultPageSize,
+		Timeout:    DefaultTimeout,
+		Scheduler:  NewRequestScheduler(),
+	}
+}
+
+// ApiError is an error response from the Slack API, either a response with ok false, or an unexpected HTTP status.
+type ApiError struct {
+	Method     string
+	Code       string // Slack's error code, e.g. not_in_channel, invalid_auth, ratelimited, channel_not_found
+	Warnings   []string
+	HttpStatus int
+}
+
+func (e *ApiError) Error() string {
+	msg := "Slack " + e.Method + " error"
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.HttpStatus != http.StatusOK {
+		msg += " (HTTP " + strconv.Itoa(e.HttpStatus) + ")"
+	}
+	if len(e.Warnings) > 0 {
+		msg += " warnings: " + strings.Join(e.Warnings, ",")
+	}
+	return msg
+}
+
+// SlackErrorCode returns the Slack error code of err, if it's an *ApiError, or the empty string if not.
+func SlackErrorCode(err error) string {
+	if apiErr, ok := err.(*ApiError); ok {
+		return apiErr.Code
+	}
+	return ""
+}
+
+// slackResponse is the part of every Slack API response which reports success or failure.
+type slackResponse struct {
+	Ok               bool   `json:"ok"`
+	Error            string `json:"error"`
+	Warning          string `json:"warning"`
+	ResponseMetadata struct {
+		Warnings []string `json:"warnings"`
+	} `json:"response_metadata"`
+}
+
+func (r slackResponse) warnings() []string {
+	warnings := r.ResponseMetadata.Warnings
+	if r.Warning != "" {
+		warnings = append(warnings, strings.Split(r.Warning, ",")...)
+	}
+	return warnings
+}
+
+// apiGet calls the given Slack API method with the given params, and decodes the JSON response into v.
+// The token is sent in the Authorization header, never in the URL, so it can't leak into logs or errors.
+// If Slack returns an error, it's returned as an *ApiError.
+func (c *SlackClient) apiGet(ctx context.Context, method string, params url.Values, v interface{}) error {
+	getUrl := c.BaseUrl + method
+	if len(params) > 0 {
+		getUrl += "?" + params.Encode()
+	}
+	request, err := http.NewRequest(http.MethodGet, getUrl, nil)
+	if err != nil {
+		return sanitizeError(err)
+	}
+	return c.call(ctx, method, request, v)
+}
+
+// apiPost calls the given Slack API method with the given params as a form body, and decodes the JSON response into v.
+// Methods which change things, e.g. reactions.add, are called with apiPost.
+func (c *SlackClient) apiPost(ctx context.Context, method string, params url.Values, v interface{}) error {
+	request, err := http.NewRequest(http.MethodPost, c.BaseUrl+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return sanitizeError(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.call(ctx, method, request, v)
+}
+
+// call makes the given request to the given method, and decodes the JSON response into v.
+// If Slack returns an error, it's returned as an *ApiError.
+func (c *SlackClient) call(ctx context.Context, method string, request *http.Request, v interface{}) error {
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+
+	response, body, err := c.do(ctx, method, request)
+	if err != nil {
+		return sanitizeError(err)
+	}
+
+	var status slackResponse
+	jsonErr := json.Unmarshal(body, &status)
+	if response.StatusCode != http.StatusOK || jsonErr != nil || !status.Ok {
+		apiErr := &ApiError{Method: method, Code: status.Error, Warnings: status.warnings(), HttpStatus: response.StatusCode}
+		if apiErr.Code == "" && response.StatusCode == http.StatusTooManyRequests {
+			apiErr.Code = "ratelimited"
+		}
+		if jsonErr != nil && response.StatusCode == http.StatusOK {
+			return jsonErr
+		}
+		return apiErr
+	}
+	if warnings := status.warnings(); len(warnings) > 0 {
+		log.Println("Slack " + method + " warnings: " + strings.Join(warnings, ","))
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// do makes the given request to the given method, when the scheduler allows it, and returns the response and its body.
+// Requests which are rate limited by Slack are retried after the Retry-After duration, up to the scheduler's MaxRetries.
+// A request with a body must have GetBody set, as http.NewRequest does for strings.Reader bodies, so it can be retried.
+func (c *SlackClient) do(ctx context.Context, method string, request *http.Request) (*http.Response, []byte, error) {
+	for retries := 0; ; retries++ {
+		if err := c.Scheduler.Wait(ctx, method); err != nil {
+			return nil, nil, err
+		}
+		response, body, err := c.doOnce(ctx, request)
+		if err != nil {
+			return nil, nil, err
+		}
+		if response.StatusCode != http.StatusTooManyRequests || retries >= c.Scheduler.MaxRetries {
+			return response, body, nil
+		}
+		c.Scheduler.RetryAfter(method, retryAfter(response))
+	}
+}
+
+// doOnce makes the given request, and reads the response body, within the client's Timeout.
+func (c *SlackClient) doOnce(ctx context.Context, request *http.Request) (*http.Response, []byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, nil, err
+		}
+		request.Body = body
+	}
+	response, err := c.HttpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	return response, body, err
+}
+
+type SlackResponseMetadata struct {
+	NextCursor string `json:"next_cursor"`
+}
+
+// SlackPaged is embedded in list responses which are paged with cursors.
+type SlackPaged struct {
+	ResponseMetadata SlackResponseMetadata `json:"response_metadata"`
+}
+
+func (p SlackPaged) nextCursor() string {
+	return p.ResponseMetadata.NextCursor
+}
+
+type slackPage interface {
+	nextCursor() string
+}
+
+// paginate calls the given list method, following response_metadata.next_cursor until there are no more pages.
+// Each page is decoded into a new value from newPage, and passed to collect.
+func (c *SlackClient) paginate(ctx context.Context, method string, params url.Values, newPage func() slackPage, collect func(slackPage)) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	if c.PageSize > 0 {
+		params.Set("limit", strconv.Itoa(c.PageSize))
+	}
+	for {
+		page := newPage()
+		if err := c.apiGet(ctx, method, params, page); err != nil {
+			return err
+		}
+		collect(page)
+		cursor := page.nextCursor()
+		if cursor == "" {
+			return nil
+		}
+		params.Set("cursor", cursor)
+	}
+}
+
+// ConversationTypes is the conversation types slackterm lists, which is every type the token can see.
+const ConversationTypes = `public_channel,private_channel,mpim,im`
+
 type SlackValue struct {
-	Value   string `json: "value"`
-	Creator string `json: "creator"`
-	LastSet int    `json: "last_set"`
+	Value   string `json:"value"`
+	Creator string `json:"creator"`
+	LastSet int    `json:"last_set"`
 }
 
+// SlackChannel is a Slack conversation: a public or private channel, a multi-person DM (mpim), or a DM (im).
 type SlackChannel struct {
-	Id         string     `json: "id"`
-	Name       string     `json: "name"`
-	Created    int64      `json: "created"`
-	Creator    string     `json: "creator"`
-	IsArchived bool       `json: "is_archived"`
-	IsMember   bool       `json: "is_member"`
-	NumMembers int        `json: "num_members"`
-	Topic      SlackValue `json: "topic"`
-	Purpose    SlackValue `json: "purpose"`
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Created    int64      `json:"created"`
+	Creator    string     `json:"creator"`
+	IsArchived bool       `json:"is_archived"`
+	IsMember   bool       `json:"is_member"`
+	IsChannel  bool       `json:"is_channel"`
+	IsGroup    bool       `json:"is_group"`
+	IsPrivate  bool       `json:"is_private"`
+	IsMpim     bool       `json:"is_mpim"`
+	IsIm       bool       `json:"is_im"`
+	User       string     `json:"user"` // the other user, if IsIm
+	NumMembers int        `json:"num_members"`
+	Topic      SlackValue `json:"topic"`
+	Purpose    SlackValue `json:"purpose"`
 }
 
 type SlackChannelRequest struct {
@@ -31,19 +261,118 @@ type SlackChannelRequest struct {
 }
 
 type SlackChannels struct {
+	SlackPaged
 	Ok       bool           `json:"ok"`
 	Channels []SlackChannel `json:"channels"`
 }
 
+type SlackMembers struct {
+	SlackPaged
+	Ok      bool     `json:"ok"`
+	Members []string `json:"members"`
+}
+
 type SlackMessage struct {
-	Type    string `json:"type"`
-	Time    string `json:"ts"`
-	User    string `json:"user"`
-	Text    string `json:"text"`
-	Starred bool   `json:"is_starred"`
+	Type       string          `json:"type"`
+	Subtype    string          `json:"subtype"`
+	Time       string          `json:"ts"`
+	User       string          `json:"user"`
+	Text       string          `json:"text"`
+	Starred    bool            `json:"is_starred"`
+	ThreadTs   string          `json:"thread_ts"`   // the ts of the thread parent, if this is a thread parent or reply
+	ReplyCount int             `json:"reply_count"` // the number of replies, if this is a thread parent
+	Reactions  []SlackReaction `json:"reactions"`
+	Edited     *SlackEdited    `json:"edited"` // nil if the message hasn't been edited
+	Files      []SlackFile     `json:"files"`
+	PinnedTo   []string        `json:"pinned_to"` // the conversations the message is pinned in
+	SlackBotInfo
+}
+
+// SlackBotInfo is the part of a message which identifies the bot which sent it, if it's a bot_message.
+type SlackBotInfo struct {
+	BotId      string           `json:"bot_id"`
+	Username   string           `json:"username"` // the name the bot posted as, which may differ from its profile
+	Icons      *SlackIcons      `json:"icons"`
+	BotProfile *SlackBotProfile `json:"bot_profile"`
+}
+
+type SlackIcons struct {
+	Emoji string `json:"emoji"` // e.g. :robot_face:, if the bot's icon is an emoji
+}
+
+type SlackBotProfile struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// BotName returns the name the bot posted as, or the empty string if the message isn't from a bot.
+func (b SlackBotInfo) BotName() string {
+	switch {
+	case b.Username != "":
+		return b.Username
+	case b.BotProfile != nil && b.BotProfile.Name != "":
+		return b.BotProfile.Name
+	default:
+		return b.BotId
+	}
+}
+
+// BotIcon returns the name of the bot's emoji icon, e.g. robot_face, or the empty string if it has none.
+func (b SlackBotInfo) BotIcon() string {
+	if b.Icons == nil {
+		return ""
+	}
+	return strings.Trim(b.Icons.Emoji, ":")
+}
+
+// SlackFile is a file shared in a message.
+type SlackFile struct {
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	Title              string `json:"title"`
+	Mimetype           string `json:"mimetype"`
+	Filetype           string `json:"filetype"`
+	Size               int64  `json:"size"`
+	User               string `json:"user"` // the id of the user who uploaded the file
+	Mode               string `json:"mode"` // e.g. hosted, or snippet for text snippets
+	UrlPrivate         string `json:"url_private"`
+	UrlPrivateDownload string `json:"url_private_download"`
+	Permalink          string `json:"permalink"`
+}
+
+type SlackEdited struct {
+	User string `json:"user"`
+	Time string `json:"ts"`
+}
+
+// SlackReaction is an emoji reaction to a message. Name is the emoji name without colons, e.g. thumbsup.
+type SlackReaction struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// IsThreadReply returns whether the message is a reply in a thread, rather than a thread parent or unthreaded message.
+func (m SlackMessage) IsThreadReply() bool {
+	return m.ThreadTs != "" && m.ThreadTs != m.Time
+}
+
+// SlackPin is a pinned item. Only message items are shown.
+type SlackPin struct {
+	Type      string        `json:"type"`
+	ChannelId string        `json:"channel"`
+	Message   *SlackMessage `json:"message"`
+	Created   int64         `json:"created"`
+	CreatedBy string        `json:"created_by"`
+}
+
+type SlackPins struct {
+	Ok    bool       `json:"ok"`
+	Items []SlackPin `json:"items"`
 }
 
 type SlackHistory struct {
+	SlackPaged
 	Ok       bool           `json:"ok"`
 	Latest   string         `json:"latest"`
 	Messages []SlackMessage `json:"messages"`
@@ -51,163 +380,216 @@ type SlackHistory struct {
 }
 
 type SlackProfile struct {
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	RealName  string `json:"real_name"`
-	Email     string `json:"email"`
-	Skype     string `json:"skype"`
-	Phone     string `json:"phone"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+	RealName         string `json:"real_name"`
+	Email            string `json:"email"`
+	Skype            string `json:"skype"`
+	Phone            string `json:"phone"`
+	StatusText       string `json:"status_text"`
+	StatusEmoji      string `json:"status_emoji"`      // e.g. :palm_tree:
+	StatusExpiration int64  `json:"status_expiration"` // the unix time the status is cleared, or 0 if it isn't
 }
 
 type SlackUser struct {
-	Id      string       `json: "id"`
-	Name    string       `json: "name"`
-	Deleted bool         `json: "deleted"`
-	Color   string       `json: "color"`
-	Profile SlackProfile `json: "profile"`
+	Id      string       `json:"id"`
+	Name    string       `json:"name"`
+	Deleted bool         `json:"deleted"`
+	Color   string       `json:"color"`
+	Profile SlackProfile `json:"profile"`
 	Admin   bool         `json:"is_admin"`
 	Owner   bool         `json:"is_owner"`
 }
 
 type SlackUsers struct {
+	SlackPaged
 	Ok      bool        `json:"ok"`
 	Members []SlackUser `json:"members"`
 }
 
+// SlackAuth is the response of auth.test, which identifies the token's user.
+type SlackAuth struct {
+	Ok     bool   `json:"ok"`
+	Url    string `json:"url"`
+	Team   string `json:"team"`
+	User   string `json:"user"`
+	TeamId string `json:"team_id"`
+	UserId string `json:"user_id"`
+}
+
 type SlackUserInfo struct {
 	Ok   bool      `json:"ok"`
 	User SlackUser `json:"user"`
 }
 
-func GetSlackChannels(token string) ([]SlackChannel, error) {
-	response, err := http.Get(`https://slack.com/api/channels.list?token=` + token)
-	if err != nil {
-		return nil, err
-	}
-
-	if response.Status != `200 OK` {
-		return nil, errors.New("Unexpected Response: " + response.Status)
-	}
-
-	var slackChannels SlackChannels
-	if err = json.NewDecoder(response.Body).Decode(&slackChannels); err != nil {
-		return nil, err
-	}
-	if !slackChannels.Ok {
-		return slackChannels.Channels, errors.New("Slack Channels response not ok")
-	}
-	return slackChannels.Channels, nil
+// GetSlackChannels gets every conversation the token can see, of all ConversationTypes.
+func (c *SlackClient) GetSlackChannels(ctx context.Context) ([]SlackChannel, error) {
+	var channels []SlackChannel
+	err := c.paginate(ctx, `conversations.list`, url.Values{"types": {ConversationTypes}}, func() slackPage { return &SlackChannels{} }, func(p slackPage) {
+		page := p.(*SlackChannels)
+		channels = append(channels, page.Channels...)
+	})
+	return channels, err
 }
 
-func GetSlackChannel(token, channelId string) (SlackChannel, error) {
-	response, err := http.Get(`https://slack.com/api/channels.info?token=` + token + `&channel=` + channelId)
-	if err != nil {
-		return SlackChannel{}, err
-	}
-
-	if response.Status != `200 OK` {
-		return SlackChannel{}, errors.New("Unexpected Response: " + response.Status)
-	}
-
+func (c *SlackClient) GetSlackChannel(ctx context.Context, channelId string) (SlackChannel, error) {
 	var slackChannel SlackChannelRequest
-	if err = json.NewDecoder(response.Body).Decode(&slackChannel); err != nil {
+	if err := c.apiGet(ctx, `conversations.info`, url.Values{"channel": {channelId}}, &slackChannel); err != nil {
 		return SlackChannel{}, err
 	}
-	if !slackChannel.Ok {
-		return slackChannel.Channel, errors.New("Slack Channel response not ok")
-	}
 	return slackChannel.Channel, nil
 }
 
+// GetSlackChannelMembers gets the user ids of the members of the given conversation.
+func (c *SlackClient) GetSlackChannelMembers(ctx context.Context, channelId string) ([]string, error) {
+	var members []string
+	err := c.paginate(ctx, `conversations.members`, url.Values{"channel": {channelId}}, func() slackPage { return &SlackMembers{} }, func(p slackPage) {
+		page := p.(*SlackMembers)
+		members = append(members, page.Members...)
+	})
+	return members, err
+}
+
 // GetSlackMessages gets all slack messages on the given channel.
-func GetAllSlackMessages(token string, channel string) ([]SlackMessage, error) {
-	return GetSlackMessages(token, channel, "", "")
+func (c *SlackClient) GetAllSlackMessages(ctx context.Context, channel string) ([]SlackMessage, error) {
+	return c.GetSlackMessages(ctx, channel, "", "")
 }
 
 // GetSlackMessagesSince gets the slack messages sent after oldest
-func GetSlackMessagesSince(token string, channel string, oldest string) ([]SlackMessage, error) {
-	return GetSlackMessages(token, channel, oldest, "")
+func (c *SlackClient) GetSlackMessagesSince(ctx context.Context, channel string, oldest string) ([]SlackMessage, error) {
+	return c.GetSlackMessages(ctx, channel, oldest, "")
 }
 
 // GetSlackMessagesUntil gets the slack messages up to latest
-func GetSlackMessagesUntil(token string, channel string, latest string) ([]SlackMessage, error) {
-	return GetSlackMessages(token, channel, "", latest)
+func (c *SlackClient) GetSlackMessagesUntil(ctx context.Context, channel string, latest string) ([]SlackMessage, error) {
+	return c.GetSlackMessages(ctx, channel, "", latest)
 }
 
-// GetSlackMessagesSince gets the slack messages sent until until
-func GetSlackMessages(token, channel, oldest, latest string) ([]SlackMessage, error) {
+// GetNewestSlackMessages gets at most limit of the newest slack messages on the given channel, newest first, in one request.
+func (c *SlackClient) GetNewestSlackMessages(ctx context.Context, channel string, limit int) ([]SlackMessage, error) {
+	history := SlackHistory{}
+	err := c.apiGet(ctx, `conversations.history`, url.Values{"channel": {channel}, "limit": {strconv.Itoa(limit)}}, &history)
+	return history.Messages, err
+}
+
+// GetSlackMessages gets the slack messages sent after oldest and before latest, newest first. Empty oldest or latest are unbounded.
+func (c *SlackClient) GetSlackMessages(ctx context.Context, channel, oldest, latest string) ([]SlackMessage, error) {
 	var messages []SlackMessage
-	for {
-		getstr := `https://slack.com/api/channels.history?token=` + token + `&channel=` + channel + `&latest=` + latest + `&oldest=` + oldest
-		log.Println("GetSlackMessages get " + latest + " to " + oldest)
-		response, err := http.Get(getstr)
-		log.Println("GetSlackMessages got")
-		if err != nil {
-			return messages, err
-		}
-		if response.Status != `200 OK` {
-			return messages, errors.New("Unexpected Response: " + response.Status)
-		}
-		var history SlackHistory
-		if err = json.NewDecoder(response.Body).Decode(&history); err != nil {
-			return messages, err
-		}
+	err := c.paginate(ctx, `conversations.history`, url.Values{"channel": {channel}, "latest": {latest}, "oldest": {oldest}}, func() slackPage { return &SlackHistory{} }, func(p slackPage) {
+		history := p.(*SlackHistory)
+		messages = append(messages, history.Messages...)
+	})
+	return messages, err
+}
 
-		if !history.Ok {
-			// TODO(fix groups [which are send via RTM as 'channel's])
-			// return messages, errors.New("Slack History response not ok: " + getstr)
-			return messages, nil
-		}
+// GetSlackReplies gets the thread parent with the given ts, followed by its replies, oldest first.
+func (c *SlackClient) GetSlackReplies(ctx context.Context, channel, threadTs string) ([]SlackMessage, error) {
+	var messages []SlackMessage
+	err := c.paginate(ctx, `conversations.replies`, url.Values{"channel": {channel}, "ts": {threadTs}}, func() slackPage { return &SlackHistory{} }, func(p slackPage) {
+		history := p.(*SlackHistory)
 		messages = append(messages, history.Messages...)
+	})
+	return messages, err
+}
 
-		if !history.HasMore {
-			break
-		}
-		if len(messages) == 0 {
-			break // TODO(log?)
-		}
-		latest = messages[len(messages)-1].Time
+func (c *SlackClient) GetSlackUsers(ctx context.Context) ([]SlackUser, error) {
+	var users []SlackUser
+	err := c.paginate(ctx, `users.list`, nil, func() slackPage { return &SlackUsers{} }, func(p slackPage) {
+		page := p.(*SlackUsers)
+		users = append(users, page.Members...)
+	})
+	return users, err
+}
+
+// GetSlackUser gets the user with the given id, via users.info.
+func (c *SlackClient) GetSlackUser(ctx context.Context, userId string) (SlackUser, error) {
+	var info SlackUserInfo
+	if err := c.apiGet(ctx, `users.info`, url.Values{"user": {userId}}, &info); err != nil {
+		return SlackUser{}, err
 	}
+	return info.User, nil
+}
 
-	return messages, nil
+// AddSlackReaction adds the named emoji reaction to the message with the given ts, as the token's user.
+func (c *SlackClient) AddSlackReaction(ctx context.Context, channelId, ts, name string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `reactions.add`, url.Values{"channel": {channelId}, "timestamp": {ts}, "name": {name}}, &response)
 }
 
-func GetSlackUsers(token string) ([]SlackUser, error) {
-	response, err := http.Get(`https://slack.com/api/users.list?token=` + token)
-	if err != nil {
-		return nil, err
-	}
+// RemoveSlackReaction removes the token's user's named emoji reaction from the message with the given ts.
+func (c *SlackClient) RemoveSlackReaction(ctx context.Context, channelId, ts, name string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `reactions.remove`, url.Values{"channel": {channelId}, "timestamp": {ts}, "name": {name}}, &response)
+}
 
-	if response.Status != `200 OK` {
-		return nil, errors.New("Unexpected Response: " + response.Status)
+// GetSlackAuth returns the user and team of the token.
+func (c *SlackClient) GetSlackAuth(ctx context.Context) (SlackAuth, error) {
+	var auth SlackAuth
+	if err := c.apiGet(ctx, `auth.test`, nil, &auth); err != nil {
+		return SlackAuth{}, err
 	}
+	return auth, nil
+}
 
-	var users SlackUsers
-	if err = json.NewDecoder(response.Body).Decode(&users); err != nil {
-		return nil, err
+// SlackPostMessage is the response of chat.postMessage.
+type SlackPostMessage struct {
+	Ok        bool   `json:"ok"`
+	ChannelId string `json:"channel"`
+	Ts        string `json:"ts"`
+}
+
+// PostSlackMessage sends a message to the channel, as a reply to the thread threadTs if it isn't empty, also sent to
+// the channel if broadcast. It returns the message's ts.
+func (c *SlackClient) PostSlackMessage(ctx context.Context, channelId, text, threadTs string, broadcast bool) (string, error) {
+	params := url.Values{"channel": {channelId}, "text": {text}}
+	if threadTs != "" {
+		params.Set("thread_ts", threadTs)
+		if broadcast {
+			params.Set("reply_broadcast", "true")
+		}
 	}
-	if !users.Ok {
-		return users.Members, errors.New("Slack Users response not ok")
+	var response SlackPostMessage
+	if err := c.apiPost(ctx, `chat.postMessage`, params, &response); err != nil {
+		return "", err
 	}
-	return users.Members, nil
+	return response.Ts, nil
 }
 
-func GetSlackUser(token string) (SlackUser, error) {
-	response, err := http.Get(`https://slack.com/api/users.list?token=` + token)
-	if err != nil {
-		return SlackUser{}, err
-	}
+// UpdateSlackMessage replaces the text of the message with the given ts. Only the token's user's messages may be updated.
+func (c *SlackClient) UpdateSlackMessage(ctx context.Context, channelId, ts, text string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `chat.update`, url.Values{"channel": {channelId}, "ts": {ts}, "text": {text}}, &response)
+}
 
-	if response.Status != `200 OK` {
-		return SlackUser{}, errors.New("Unexpected Response: " + response.Status)
-	}
+// DeleteSlackMessage deletes the message with the given ts. Only the token's user's messages may be deleted.
+func (c *SlackClient) DeleteSlackMessage(ctx context.Context, channelId, ts string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `chat.delete`, url.Values{"channel": {channelId}, "ts": {ts}}, &response)
+}
 
-	var info SlackUserInfo
-	if err = json.NewDecoder(response.Body).Decode(&info); err != nil {
-		return SlackUser{}, err
+// GetSlackPins returns the messages pinned in the channel, most recently pinned first. Pins of files are skipped.
+func (c *SlackClient) GetSlackPins(ctx context.Context, channelId string) ([]SlackMessage, error) {
+	var pins SlackPins
+	if err := c.apiGet(ctx, `pins.list`, url.Values{"channel": {channelId}}, &pins); err != nil {
+		return nil, err
 	}
-	if !info.Ok {
-		return info.User, errors.New("Slack User Info response not ok")
+	var msgs []SlackMessage
+	for _, pin := range pins.Items {
+		if pin.Type == "message" && pin.Message != nil {
+			msgs = append(msgs, *pin.Message)
+		}
 	}
-	return info.User, nil
+	return msgs, nil
+}
+
+// AddSlackPin pins the message with the given ts to its channel.
+func (c *SlackClient) AddSlackPin(ctx context.Context, channelId, ts string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `pins.add`, url.Values{"channel": {channelId}, "timestamp": {ts}}, &response)
+}
+
+// RemoveSlackPin unpins the message with the given ts from its channel.
+func (c *SlackClient) RemoveSlackPin(ctx context.Context, channelId, ts string) error {
+	var response slackResponse
+	return c.apiPost(ctx, `pins.remove`, url.Values{"channel": {channelId}, "timestamp": {ts}}, &response)
 }
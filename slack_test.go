@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+// recordingTransport records the URL of each request, before making it with http.DefaultTransport.
+type recordingTransport struct {
+	mutex sync.Mutex
+	urls  []*url.URL
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	t.urls = append(t.urls, r.URL)
+	t.mutex.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// requests returns the recorded requests of the given method, and forgets every recorded request.
+func (t *recordingTransport) requests(method string) []url.Values {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var params []url.Values
+	for _, u := range t.urls {
+		if u.Path == "/api/"+method {
+			params = append(params, u.Query())
+		}
+	}
+	t.urls = nil
+	return params
+}
+
+func TestPaginate(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	for i := 0; i < 11; i++ {
+		s.AddUser(slacktest.User{Id: fmt.Sprint("U", i), Name: fmt.Sprint("u", i)})
+		s.AddChannel(slacktest.Channel{Id: fmt.Sprint("C", i), Name: fmt.Sprint("c", i)})
+		s.AddMessage("C0", slacktest.Message{User: "U1", Text: fmt.Sprint(i)})
+	}
+	client := testClient(t, s)
+	recorder := &recordingTransport{}
+	client.HttpClient = &http.Client{Transport: recorder}
+	client.PageSize = 3
+	ctx := context.Background()
+
+	users, err := client.GetSlackUsers(ctx)
+	if err != nil || len(users) != 11 {
+		t.Fatalf("expected 11 users, got %d %v", len(users), err)
+	}
+	pages := recorder.requests("users.list")
+	if len(pages) != 4 {
+		t.Fatalf("expected 4 pages of 3, got %d", len(pages))
+	}
+	for i, page := range pages {
+		if page.Get("limit") != "3" || (i == 0) != (page.Get("cursor") == "") {
+			t.Fatalf("expected limit 3, and the cursor of the previous page, got %v", page)
+		}
+	}
+
+	channels, err := client.GetSlackChannels(ctx)
+	if err != nil || len(channels) != 11 || len(recorder.requests("conversations.list")) != 4 {
+		t.Fatalf("expected 11 channels in 4 pages, got %d %v", len(channels), err)
+	}
+
+	msgs, err := client.GetAllSlackMessages(ctx, "C0")
+	if err != nil || len(msgs) != 11 || msgs[0].Text != "10" || msgs[10].Text != "0" {
+		t.Fatalf("expected 11 messages, newest first, got %v %v", msgs, err)
+	}
+	msgs, err = client.GetSlackMessages(ctx, "C0", msgs[8].Time, msgs[1].Time)
+	if err != nil || len(msgs) != 6 || msgs[0].Text != "8" || msgs[5].Text != "3" {
+		t.Fatalf("expected the 6 messages between oldest and latest, got %v %v", msgs, err)
+	}
+}
+
+func TestPaginateError(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	for i := 0; i < 5; i++ {
+		s.AddUser(slacktest.User{Id: fmt.Sprint("U", i), Name: fmt.Sprint("u", i)})
+	}
+	client := testClient(t, s)
+	client.PageSize = 2
+	pages := 0
+	s.Handle("users.list", func(w http.ResponseWriter, params url.Values) {
+		pages++
+		if params.Get("cursor") != "" {
+			slacktest.WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_cursor"})
+			return
+		}
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": true, "members": []slacktest.User{{Id: "U0"}}, "response_metadata": map[string]string{"next_cursor": "next"}})
+	})
+	if _, err := client.GetSlackUsers(context.Background()); SlackErrorCode(err) != "invalid_cursor" || pages != 2 {
+		t.Fatalf("expected the second page's invalid_cursor, got %v after %d pages", err, pages)
+	}
+}
+
+func TestApiError(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	client := testClient(t, s)
+	ctx := context.Background()
+
+	client.Token = "xoxp-bad"
+	if _, err := client.GetSlackUsers(ctx); SlackErrorCode(err) != "invalid_auth" {
+		t.Fatalf("expected invalid_auth, got %v", err)
+	}
+	client.Token = s.Token
+
+	_, err := client.GetSlackChannel(ctx, "C9")
+	apiErr, ok := err.(*ApiError)
+	if !ok || apiErr.Code != "channel_not_found" || apiErr.Method != "conversations.info" || apiErr.HttpStatus != http.StatusOK {
+		t.Fatalf("expected an *ApiError for conversations.info, got %#v", err)
+	}
+	if got := err.Error(); got != "Slack conversations.info error channel_not_found" {
+		t.Fatalf("unexpected message %q", got)
+	}
+
+	s.Handle("users.info", func(w http.ResponseWriter, params url.Values) {
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": false, "error": "user_not_found", "warning": "superfluous_charset", "response_metadata": map[string]interface{}{"warnings": []string{"missing_charset"}}})
+	})
+	_, err = client.GetSlackUser(ctx, "U9")
+	if apiErr, ok := err.(*ApiError); !ok || len(apiErr.Warnings) != 2 || err.Error() != "Slack users.info error user_not_found warnings: missing_charset,superfluous_charset" {
+		t.Fatalf("expected the error with both warnings, got %v", err)
+	}
+
+	s.Handle("users.list", func(w http.ResponseWriter, params url.Values) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("<html>oops</html>"))
+	})
+	_, err = client.GetSlackUsers(ctx)
+	if apiErr, ok := err.(*ApiError); !ok || apiErr.Code != "" || apiErr.HttpStatus != http.StatusInternalServerError || err.Error() != "Slack users.list error (HTTP 500)" {
+		t.Fatalf("expected an HTTP 500 error without a code, got %#v", err)
+	}
+
+	client.Scheduler.MaxRetries = 0
+	s.RateLimit("conversations.list", 1, 0)
+	_, err = client.GetSlackChannels(ctx)
+	if apiErr, ok := err.(*ApiError); !ok || apiErr.Code != "ratelimited" || apiErr.HttpStatus != http.StatusTooManyRequests {
+		t.Fatalf("expected ratelimited with HTTP 429, got %#v", err)
+	}
+
+	if SlackErrorCode(context.Canceled) != "" || SlackErrorCode(nil) != "" {
+		t.Fatal("expected no code for errors which aren't *ApiError")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	client := testClient(t, s)
+	release := make(chan struct{})
+	defer close(release) // before the server is closed, which waits for its handlers
+	s.Handle("users.info", func(w http.ResponseWriter, params url.Values) { <-release })
+
+	// a request which hangs fails after the client's Timeout
+	client.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := client.GetSlackUser(context.Background(), "U1"); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("expected the request to time out, got %v after %v", err, time.Since(start))
+	}
+
+	// or when its ctx is done
+	client.Timeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := client.GetSlackUser(ctx, "U1"); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("expected the request cancelled, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestClientOverrides(t *testing.T) {
+	client := NewSlackClient("xoxp-test")
+	if client.BaseUrl != DefaultSlackApiUrl || client.HttpClient != http.DefaultClient {
+		t.Fatalf("expected the default URL and HTTP client, got %q %v", client.BaseUrl, client.HttpClient)
+	}
+
+	// requests, both GETs and POSTs, go to the BaseUrl, through the HttpClient
+	s := slacktest.NewServer("xoxp-test")
+	s.AddChannel(slacktest.Channel{Id: "C1", Name: "general"})
+	m := s.AddMessage("C1", slacktest.Message{User: "U1", Text: "hi"})
+	client = testClient(t, s)
+	recorder := &recordingTransport{}
+	client.HttpClient = &http.Client{Transport: recorder}
+	ctx := context.Background()
+	if _, err := client.GetSlackAuth(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.AddSlackReaction(ctx, "C1", m.Ts, "+1"); err != nil {
+		t.Fatal(err)
+	}
+	base, err := url.Parse(s.ApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.mutex.Lock()
+	urls := recorder.urls
+	recorder.mutex.Unlock()
+	if len(urls) != 2 {
+		t.Fatalf("expected 2 requests through the HTTP client, got %v", urls)
+	}
+	for i, method := range []string{"auth.test", "reactions.add"} {
+		if urls[i].Host != base.Host || urls[i].Path != base.Path+method {
+			t.Fatalf("expected %s requested from %s, got %v", method, base, urls[i])
+		}
+	}
+}
@@ -1,27 +1,116 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 const tokenFile = `slack_token`
+const apiUrlFile = `slack_api_url`
+const appTokenFile = `slack_app_token`
+const downloadDirFile = `slack_download_dir`
 
 func getToken() (string, error) {
 	tokenBytes, err := ioutil.ReadFile(tokenFile)
 	return strings.TrimSpace(string(tokenBytes)), err
 }
 
+// getApiUrl returns the Slack API base URL in the optional slack_api_url file, or the default Slack URL if the file doesn't exist.
+func getApiUrl() string {
+	urlBytes, err := ioutil.ReadFile(apiUrlFile)
+	if err != nil {
+		return DefaultSlackApiUrl
+	}
+	apiUrl := strings.TrimSpace(string(urlBytes))
+	if !strings.HasSuffix(apiUrl, "/") {
+		apiUrl += "/"
+	}
+	return apiUrl
+}
+
+// getAppToken returns the app-level token in the optional slack_app_token file, or the empty string if the file doesn't
+// exist, in which case RTM is used instead of Socket Mode.
+func getAppToken() string {
+	tokenBytes, err := ioutil.ReadFile(appTokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(tokenBytes))
+}
+
+// findChannelId returns the id of the conversation with the given id, or name, with or without a leading #.
+func findChannelId(ctx context.Context, client *SlackClient, name string) (string, error) {
+	channels, err := client.GetSlackChannels(ctx)
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimPrefix(name, "#")
+	for _, channel := range channels {
+		if channel.Id == name || channel.Name == name {
+			return channel.Id, nil
+		}
+	}
+	return "", fmt.Errorf("channel %s not found", name)
+}
+
+// uploadStdin uploads everything piped to stdin as a file shared in the given channel.
+func uploadStdin(ctx context.Context, client *SlackClient, channel, title, comment string) (SlackFile, error) {
+	channelId, err := findChannelId(ctx, client, channel)
+	if err != nil {
+		return SlackFile{}, err
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return SlackFile{}, err
+	}
+	filename := title
+	if filename == "" {
+		filename = "stdin.txt"
+	}
+	return client.UploadSlackFile(ctx, SlackUpload{
+		ChannelId: channelId,
+		Filename:  filename,
+		Title:     title,
+		Comment:   comment,
+		Content:   bytes.NewReader(content),
+		Size:      int64(len(content)),
+	})
+}
+
+// getDownloadDir returns the directory in the optional slack_download_dir file, or else ~/Downloads if it exists, or else the working directory.
+func getDownloadDir() string {
+	if dirBytes, err := ioutil.ReadFile(downloadDirFile); err == nil {
+		return expandHome(strings.TrimSpace(string(dirBytes)))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if info, err := os.Stat(filepath.Join(home, "Downloads")); err == nil && info.IsDir() {
+			return filepath.Join(home, "Downloads")
+		}
+	}
+	return "."
+}
+
 func main() {
+	uploadChannel := flag.String("upload", "", "upload stdin to the given channel name or id, and exit, e.g. git diff | slackterm -upload general")
+	uploadTitle := flag.String("title", "", "the title of the file uploaded with -upload")
+	uploadComment := flag.String("comment", "", "the message shared with the file uploaded with -upload")
+	flag.Parse()
+
 	f, err := os.OpenFile("log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		log.Panicln("error opening file: %v", err)
 	}
 	defer f.Close()
-	log.SetOutput(f)
+	log.SetOutput(NewRedactingWriter(f))
 	log.Println("Starting slackterm")
 
 	slackToken, err := getToken()
@@ -31,10 +120,91 @@ func main() {
 		return
 	}
 
-	putChannelIdChan, getChannelIdChan, getChannelNameChan := StartChannelIdManager(slackToken)
-	getUserNameChan := StartUserManager(slackToken)
-	getMessagesChan, putMessageChan := StartMessagesManager(slackToken, getUserNameChan)
-	updateMsgsChan, sendMsgChan := StartSlackRtmHandler(slackToken, putMessageChan)
+	// the root context, cancelled when the GUI quits via C-c, or the process is interrupted or terminated
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	client := NewSlackClient(slackToken)
+	client.BaseUrl = getApiUrl()
+	log.Println("Using Slack API " + client.BaseUrl)
+	throttledChan := make(chan ThrottleInfo)
+	client.Scheduler.Throttled = throttledChan
+
+	auth, err := client.GetSlackAuth(ctx)
+	if err != nil {
+		fmt.Printf("Failed to authenticate with Slack: %v.\nCheck that the token in 'slack_token' is valid.\n", err)
+		log.Printf("error authenticating with Slack: %v\n", err)
+		return
+	}
+	log.Println("Authenticated as " + auth.User + " " + auth.UserId + " on " + auth.Team)
+
+	if *uploadChannel != "" {
+		file, err := uploadStdin(ctx, client, *uploadChannel, *uploadTitle, *uploadComment)
+		if err != nil {
+			fmt.Printf("Failed to upload: %v\n", err)
+			log.Printf("error uploading stdin: %v\n", err)
+			return
+		}
+		fmt.Println("Uploaded " + fileName(file) + " " + file.Permalink)
+		return
+	}
+
+	var transport RealtimeTransport = NewRtmTransport(client)
+	if appToken := getAppToken(); appToken != "" {
+		appClient := NewSlackClient(appToken)
+		appClient.BaseUrl = client.BaseUrl
+		appClient.Scheduler = client.Scheduler
+		transport = NewSocketModeTransport(appClient, client)
+		log.Println("Using Socket Mode")
+	}
+
+	getUserNameChan, getUserIdChan, getUserStatusChan, putUserChan, putPresenceChan := StartUserManager(ctx, client)
+	channelsChangedChan := make(chan string)
+	putChannelChan, changeChannelChan, getChannelIdChan, getChannelNameChan, getChannelsChan := StartChannelIdManager(ctx, client, getUserNameChan, channelsChangedChan)
+	getMessagesChan, getThreadChan, putMessageChan, changeMessageChan, getNewestChan, putHistoryChan, putOutboxChan := StartMessagesManager(ctx, client, getUserNameChan)
+	pinsChangedChan := make(chan string)
+	usersChangedChan := make(chan []string)
+	rtmStatusChan := make(chan RtmStatus)
+	userTypingChan := make(chan SlackRtmUserTyping)
+	updateMsgsChan, sendMsgChan, retryMsgChan, typingChan, subscribePresenceChan := StartSlackRtmHandler(ctx, client, transport, RtmConfig{SelfId: auth.UserId}, RtmChans{
+		PutMsg:          putMessageChan,
+		ChangeMsg:       changeMessageChan,
+		GetUserName:     getUserNameChan,
+		PinsChanged:     pinsChangedChan,
+		PutUser:         putUserChan,
+		PutPresence:     putPresenceChan,
+		UsersChanged:    usersChangedChan,
+		PutChannel:      putChannelChan,
+		ChangeChannel:   changeChannelChan,
+		ChannelsChanged: channelsChangedChan,
+		GetNewest:       getNewestChan,
+		PutHistory:      putHistoryChan,
+		PutOutbox:       putOutboxChan,
+		Status:          rtmStatusChan,
+		UserTyping:      userTypingChan,
+	})
 
-	EnterTheGui(slackToken, putChannelIdChan, getChannelIdChan, getUserNameChan, getMessagesChan, getChannelNameChan, updateMsgsChan, sendMsgChan)
+	EnterTheGui(ctx, client, GuiConfig{SelfId: auth.UserId, DownloadDir: getDownloadDir()}, GuiChans{
+		PutChannel:        putChannelChan,
+		ChangeChannel:     changeChannelChan,
+		GetChannelId:      getChannelIdChan,
+		GetChannelName:    getChannelNameChan,
+		GetChannels:       getChannelsChan,
+		GetUserName:       getUserNameChan,
+		GetUserId:         getUserIdChan,
+		GetUserStatus:     getUserStatusChan,
+		GetMessages:       getMessagesChan,
+		GetThread:         getThreadChan,
+		UpdateMsgs:        updateMsgsChan,
+		PinsChanged:       pinsChangedChan,
+		UsersChanged:      usersChangedChan,
+		ChannelsChanged:   channelsChangedChan,
+		RtmStatus:         rtmStatusChan,
+		UserTyping:        userTypingChan,
+		SubscribePresence: subscribePresenceChan,
+		SendMsg:           sendMsgChan,
+		RetryMsg:          retryMsgChan,
+		Typing:            typingChan,
+		Throttled:         throttledChan,
+	})
 }
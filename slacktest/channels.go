@@ -0,0 +1,341 @@
+package slacktest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var channelName = regexp.MustCompile(`^[a-z0-9_-]{1,80}$`)
+
+// RenameChannel renames the channel, and pushes a channel_rename or group_rename event, as if another client renamed
+// it.
+func (s *Server) RenameChannel(channelId, name string) error {
+	s.mutex.Lock()
+	i := s.channelIndex(channelId)
+	if i < 0 {
+		s.mutex.Unlock()
+		return fmt.Errorf("slacktest: no channel %s", channelId)
+	}
+	s.channels[i].Name = name
+	c := s.channels[i]
+	s.mutex.Unlock()
+	return s.Push(map[string]interface{}{"type": channelEventType(c, "rename"), "channel": map[string]interface{}{"id": c.Id, "name": c.Name, "created": c.Created}})
+}
+
+// CreateChannel adds the channel, and pushes the event Slack sends when another client creates it: im_created for a
+// DM, group_joined for a private channel or multi-person DM, and channel_created for a public channel.
+func (s *Server) CreateChannel(c Channel) error {
+	s.AddChannel(c)
+	switch c.conversationType() {
+	case "im":
+		return s.Push(map[string]interface{}{"type": "im_created", "user": c.User, "channel": c})
+	case "public_channel":
+		return s.Push(map[string]interface{}{"type": "channel_created", "channel": map[string]interface{}{"id": c.Id, "name": c.Name, "created": c.Created, "creator": c.Creator}})
+	default:
+		return s.Push(map[string]interface{}{"type": "group_joined", "channel": c})
+	}
+}
+
+// DeleteChannel deletes the channel and its messages, and pushes a channel_deleted or group_deleted event.
+func (s *Server) DeleteChannel(channelId string) error {
+	s.mutex.Lock()
+	i := s.channelIndex(channelId)
+	if i < 0 {
+		s.mutex.Unlock()
+		return fmt.Errorf("slacktest: no channel %s", channelId)
+	}
+	c := s.channels[i]
+	s.channels = append(s.channels[:i:i], s.channels[i+1:]...)
+	delete(s.messages, channelId)
+	delete(s.pins, channelId)
+	s.mutex.Unlock()
+	return s.Push(map[string]interface{}{"type": channelEventType(c, "deleted"), "channel": channelId})
+}
+
+// UnarchiveChannel unarchives the channel, and pushes a channel_unarchive or group_unarchive event, as if another
+// client unarchived it.
+func (s *Server) UnarchiveChannel(channelId string) error {
+	s.mutex.Lock()
+	i := s.channelIndex(channelId)
+	if i < 0 {
+		s.mutex.Unlock()
+		return fmt.Errorf("slacktest: no channel %s", channelId)
+	}
+	s.channels[i].IsArchived = false
+	c := s.channels[i]
+	s.mutex.Unlock()
+	return s.Push(map[string]interface{}{"type": channelEventType(c, "unarchive"), "channel": channelId, "user": s.Self.Id})
+}
+
+// channelEventType returns the type of the event about the channel, e.g. channel_deleted for a public channel, or
+// group_deleted for a private one.
+func channelEventType(c Channel, event string) string {
+	if c.conversationType() == "public_channel" {
+		return "channel_" + event
+	}
+	return "group_" + event
+}
+
+// channelIndex returns the index of the channel with the given id, or -1 if there's none. The mutex must be held.
+func (s *Server) channelIndex(channelId string) int {
+	for i, c := range s.channels {
+		if c.Id == channelId {
+			return i
+		}
+	}
+	return -1
+}
+
+// memberChannel returns the index of the channel in params, or the Slack error code if it doesn't exist, isn't a
+// channel, or the Self user isn't a member. The mutex must be held.
+func (s *Server) memberChannel(params url.Values) (int, string) {
+	i := s.channelIndex(params.Get("channel"))
+	switch {
+	case i < 0:
+		return 0, "channel_not_found"
+	case s.channels[i].IsIm || s.channels[i].IsMpim:
+		return 0, "method_not_supported_for_channel_type"
+	case !s.channels[i].IsMember:
+		return 0, "not_in_channel"
+	case s.channels[i].IsArchived:
+		return 0, "is_archived"
+	}
+	return i, ""
+}
+
+// conversationsJoin adds the Self user to a public channel, and pushes a channel_joined event.
+func (s *Server) conversationsJoin(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	i := s.channelIndex(params.Get("channel"))
+	switch {
+	case i < 0 || s.channels[i].conversationType() != "public_channel" && !s.channels[i].IsMember:
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	case s.channels[i].IsIm || s.channels[i].IsMpim:
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "method_not_supported_for_channel_type"})
+		return
+	case s.channels[i].IsArchived:
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "is_archived"})
+		return
+	}
+	alreadyMember := s.channels[i].IsMember
+	if !alreadyMember {
+		s.channels[i].IsMember = true
+		s.channels[i].Members = append(s.channels[i].Members, s.Self.Id)
+		s.channels[i].NumMembers = len(s.channels[i].Members)
+	}
+	c := s.channels[i]
+	s.mutex.Unlock()
+
+	if !alreadyMember {
+		s.Push(map[string]interface{}{"type": "channel_joined", "channel": c})
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": c})
+}
+
+// conversationsLeave removes the Self user from a channel, and pushes a channel_left or group_left event.
+func (s *Server) conversationsLeave(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	i, errCode := s.memberChannel(params)
+	if errCode == "not_in_channel" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": true, "not_in_channel": true})
+		return
+	}
+	if errCode != "" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	s.channels[i].IsMember = false
+	s.channels[i].Members = removeString(s.channels[i].Members, s.Self.Id)
+	s.channels[i].NumMembers = len(s.channels[i].Members)
+	c := s.channels[i]
+	s.mutex.Unlock()
+
+	s.Push(map[string]interface{}{"type": channelEventType(c, "left"), "channel": c.Id})
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// conversationsCreate creates a channel with the Self user as its only member. A public channel pushes
+// channel_created and channel_joined events, a private one group_joined.
+func (s *Server) conversationsCreate(w http.ResponseWriter, params url.Values) {
+	name := params.Get("name")
+	if !channelName.MatchString(name) {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_name_specials"})
+		return
+	}
+	s.mutex.Lock()
+	for _, c := range s.channels {
+		if c.Name == name {
+			s.mutex.Unlock()
+			WriteJSON(w, map[string]interface{}{"ok": false, "error": "name_taken"})
+			return
+		}
+	}
+	c := Channel{Name: name, Created: s.nextTs, Creator: s.Self.Id, IsMember: true, IsChannel: true, NumMembers: 1, Members: []string{s.Self.Id}}
+	if params.Get("is_private") == "true" {
+		c.IsChannel, c.IsGroup, c.IsPrivate = false, true, true
+	}
+	for n := len(s.channels) + 1; c.Id == "" || s.channelIndex(c.Id) >= 0; n++ {
+		c.Id = fmt.Sprintf("C%08d", n)
+	}
+	s.channels = append(s.channels, c)
+	s.mutex.Unlock()
+
+	if c.IsPrivate {
+		s.Push(map[string]interface{}{"type": "group_joined", "channel": c})
+	} else {
+		s.Push(map[string]interface{}{"type": "channel_created", "channel": map[string]interface{}{"id": c.Id, "name": c.Name, "created": c.Created, "creator": c.Creator}})
+		s.Push(map[string]interface{}{"type": "channel_joined", "channel": c})
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": c})
+}
+
+// conversationsArchive archives a channel the Self user is a member of, and pushes a channel_archive or group_archive
+// event.
+func (s *Server) conversationsArchive(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	i, errCode := s.memberChannel(params)
+	if errCode == "is_archived" {
+		errCode = "already_archived"
+	}
+	if errCode == "" && s.channels[i].Name == "general" {
+		errCode = "cant_archive_general"
+	}
+	if errCode != "" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	s.channels[i].IsArchived = true
+	c := s.channels[i]
+	s.mutex.Unlock()
+
+	s.Push(map[string]interface{}{"type": channelEventType(c, "archive"), "channel": c.Id, "user": s.Self.Id})
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// conversationsSetTopic sets a channel's topic, and pushes a channel_topic message.
+func (s *Server) conversationsSetTopic(w http.ResponseWriter, params url.Values) {
+	s.setChannelValue(w, params, "topic")
+}
+
+// conversationsSetPurpose sets a channel's purpose, and pushes a channel_purpose message.
+func (s *Server) conversationsSetPurpose(w http.ResponseWriter, params url.Values) {
+	s.setChannelValue(w, params, "purpose")
+}
+
+// setChannelValue sets a channel's topic or purpose, as what says, from the param of the same name.
+func (s *Server) setChannelValue(w http.ResponseWriter, params url.Values, what string) {
+	text := params.Get(what)
+	if len(text) > 250 {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "too_long"})
+		return
+	}
+	s.mutex.Lock()
+	i, errCode := s.memberChannel(params)
+	if errCode != "" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	value := Value{Value: text, Creator: s.Self.Id, LastSet: s.nextTs}
+	if what == "topic" {
+		s.channels[i].Topic = value
+	} else {
+		s.channels[i].Purpose = value
+	}
+	c := s.channels[i]
+	m := s.addMessage(c.Id, Message{Subtype: "channel_" + what, User: s.Self.Id, Text: "set the channel " + what + ": " + text})
+	s.mutex.Unlock()
+
+	s.Push(messageEvent(c.Id, m))
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": c})
+}
+
+// conversationsInvite adds the users to a channel the Self user is a member of.
+func (s *Server) conversationsInvite(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, errCode := s.memberChannel(params)
+	if errCode != "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	userIds := strings.Split(params.Get("users"), ",")
+	for _, userId := range userIds {
+		switch {
+		case userId == s.Self.Id:
+			errCode = "cant_invite_self"
+		case !s.hasUser(userId):
+			errCode = "user_not_found"
+		case indexOfString(s.channels[i].Members, userId) >= 0:
+			errCode = "already_in_channel"
+		}
+		if errCode != "" {
+			WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+			return
+		}
+	}
+	s.channels[i].Members = append(s.channels[i].Members, userIds...)
+	s.channels[i].NumMembers = len(s.channels[i].Members)
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": s.channels[i]})
+}
+
+// conversationsKick removes a user from a channel the Self user is a member of.
+func (s *Server) conversationsKick(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, errCode := s.memberChannel(params)
+	userId := params.Get("user")
+	switch {
+	case errCode != "":
+	case userId == s.Self.Id:
+		errCode = "cant_kick_self"
+	case !s.hasUser(userId):
+		errCode = "user_not_found"
+	case indexOfString(s.channels[i].Members, userId) < 0:
+		errCode = "not_in_channel"
+	}
+	if errCode != "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	s.channels[i].Members = removeString(s.channels[i].Members, userId)
+	s.channels[i].NumMembers = len(s.channels[i].Members)
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// hasUser returns whether a user with the given id was added. The mutex must be held.
+func (s *Server) hasUser(userId string) bool {
+	for _, u := range s.users {
+		if u.Id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOfString(strs []string, s string) int {
+	for i, str := range strs {
+		if str == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeString returns strs without s.
+func removeString(strs []string, s string) []string {
+	if i := indexOfString(strs, s); i >= 0 {
+		return append(strs[:i:i], strs[i+1:]...)
+	}
+	return strs
+}
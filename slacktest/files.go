@@ -0,0 +1,137 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// storedFile is an uploaded file and its content.
+type storedFile struct {
+	File     File
+	Content  []byte
+	Uploaded bool // whether the content was posted to the upload URL
+}
+
+// File returns the uploaded file with the given id, and its content.
+func (s *Server) File(id string) (File, []byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return File{}, nil, false
+	}
+	return f.File, append([]byte(nil), f.Content...), true
+}
+
+// filesGetUploadUrlExternal starts an upload, returning the URL to post the content to.
+func (s *Server) filesGetUploadUrlExternal(w http.ResponseWriter, params url.Values) {
+	length, err := strconv.ParseInt(params.Get("length"), 10, 64)
+	if params.Get("filename") == "" || err != nil || length < 0 {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_arguments"})
+		return
+	}
+	s.mutex.Lock()
+	id := "F" + strconv.FormatInt(s.nextTs, 10)
+	s.nextTs++
+	s.files[id] = &storedFile{File: File{Id: id, Name: params.Get("filename"), Size: length, Filetype: params.Get("snippet_type")}}
+	s.mutex.Unlock()
+	WriteJSON(w, map[string]interface{}{"ok": true, "upload_url": s.server.URL + "/upload/" + id, "file_id": id})
+}
+
+// upload receives the content of a file started with files.getUploadURLExternal. Like Slack's, it needs no token.
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[len("/upload/"):]
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		http.Error(w, "unknown upload", http.StatusNotFound)
+		return
+	}
+	f.Content = content
+	f.Uploaded = true
+	w.Write([]byte("OK - " + strconv.Itoa(len(content))))
+}
+
+// filesCompleteUploadExternal shares uploaded files in a channel, as a file_share message from the Self user, which is pushed to RTM clients.
+func (s *Server) filesCompleteUploadExternal(w http.ResponseWriter, params url.Values) {
+	var requested []struct {
+		Id    string `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal([]byte(params.Get("files")), &requested); err != nil || len(requested) == 0 {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_arguments"})
+		return
+	}
+	channelId := params.Get("channel_id")
+	if _, ok := s.findChannel(channelId); !ok && channelId != "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+
+	s.mutex.Lock()
+	files := []File{}
+	for _, r := range requested {
+		f, ok := s.files[r.Id]
+		if !ok || !f.Uploaded {
+			s.mutex.Unlock()
+			WriteJSON(w, map[string]interface{}{"ok": false, "error": "file_not_found"})
+			return
+		}
+		f.File.Title = r.Title
+		if f.File.Title == "" {
+			f.File.Title = f.File.Name
+		}
+		f.File.User = s.Self.Id
+		if f.File.Filetype != "" {
+			f.File.Mode = "snippet"
+			f.File.Mimetype = "text/plain"
+		}
+		f.File.UrlPrivate = s.server.URL + "/files/" + f.File.Id + "/" + url.PathEscape(path.Base(f.File.Name))
+		f.File.UrlPrivateDownload = f.File.UrlPrivate + "?download=1"
+		f.File.Permalink = "https://slacktest.slack.com/files/" + s.Self.Id + "/" + f.File.Id
+		files = append(files, f.File)
+	}
+	var m Message
+	if channelId != "" {
+		m = s.addMessage(channelId, Message{Subtype: "file_share", User: s.Self.Id, Text: params.Get("initial_comment"), ThreadTs: params.Get("thread_ts"), Files: files})
+	}
+	s.mutex.Unlock()
+
+	if channelId != "" {
+		s.Push(messageEvent(channelId, m))
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "files": files})
+}
+
+// download serves the content of an uploaded file, at its url_private, to requests with the token in an Authorization header.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		http.Error(w, "not authorized", http.StatusForbidden)
+		return
+	}
+	id := strings.SplitN(r.URL.Path[len("/files/"):], "/", 2)[0]
+	s.mutex.Lock()
+	f, ok := s.files[id]
+	var content []byte
+	if ok {
+		content = f.Content
+	}
+	s.mutex.Unlock()
+	if !ok || !f.Uploaded {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(content)
+}
@@ -0,0 +1,122 @@
+package slacktest
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// pinItem is an item served by pins.list, and in pin_added and pin_removed events. Only messages are pinned here.
+type pinItem struct {
+	Type      string  `json:"type"`
+	Channel   string  `json:"channel"`
+	Message   Message `json:"message"`
+	Created   int64   `json:"created"`
+	CreatedBy string  `json:"created_by"`
+}
+
+// pinsList serves the channel's pinned messages, most recently pinned first.
+func (s *Server) pinsList(w http.ResponseWriter, params url.Values) {
+	channelId := params.Get("channel")
+	if _, ok := s.findChannel(channelId); !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	items := []pinItem{}
+	for _, ts := range s.pins[channelId] {
+		for _, m := range s.messages[channelId] {
+			if m.Ts == ts {
+				items = append([]pinItem{{Type: "message", Channel: channelId, Message: m, CreatedBy: s.Self.Id}}, items...)
+				break
+			}
+		}
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "items": items})
+}
+
+// pinsAdd pins a message, and pushes a pin_added event.
+func (s *Server) pinsAdd(w http.ResponseWriter, params url.Values) {
+	s.changePin(w, params, true)
+}
+
+// pinsRemove unpins a message, and pushes a pin_removed event.
+func (s *Server) pinsRemove(w http.ResponseWriter, params url.Values) {
+	s.changePin(w, params, false)
+}
+
+func (s *Server) changePin(w http.ResponseWriter, params url.Values, add bool) {
+	channelId, ts := params.Get("channel"), params.Get("timestamp")
+	s.mutex.Lock()
+	i, errCode := s.findMessage(channelId, ts)
+	if errCode == "" {
+		pinned := s.isPinned(channelId, ts)
+		switch {
+		case add && pinned:
+			errCode = "already_pinned"
+		case !add && !pinned:
+			errCode = "no_pin"
+		case add:
+			s.pins[channelId] = append(s.pins[channelId], ts)
+			s.messages[channelId][i].PinnedTo = []string{channelId}
+		default:
+			s.pins[channelId] = removeTs(s.pins[channelId], ts)
+			s.messages[channelId][i].PinnedTo = nil
+		}
+	}
+	var m Message
+	if errCode == "" {
+		m = s.messages[channelId][i]
+	}
+	eventTs := s.ts()
+	s.mutex.Unlock()
+	if errCode != "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+
+	eventType := "pin_added"
+	if !add {
+		eventType = "pin_removed"
+	}
+	s.Push(map[string]interface{}{
+		"type":       eventType,
+		"user":       s.Self.Id,
+		"channel_id": channelId,
+		"item":       pinItem{Type: "message", Channel: channelId, Message: m, CreatedBy: s.Self.Id},
+		"event_ts":   eventTs,
+	})
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// findMessage returns the index of the message with the given ts, or the Slack error code if it doesn't exist.
+// The mutex must be held.
+func (s *Server) findMessage(channelId, ts string) (int, string) {
+	for i, m := range s.messages[channelId] {
+		if m.Ts == ts {
+			return i, ""
+		}
+	}
+	return 0, "message_not_found"
+}
+
+// isPinned returns whether the message with the given ts is pinned. The mutex must be held.
+func (s *Server) isPinned(channelId, ts string) bool {
+	for _, pinned := range s.pins[channelId] {
+		if pinned == ts {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTs returns a copy of tss without ts.
+func removeTs(tss []string, ts string) []string {
+	var removed []string
+	for _, t := range tss {
+		if t != ts {
+			removed = append(removed, t)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,77 @@
+package slacktest
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// searchMatch is a message found by search.messages.
+type searchMatch struct {
+	Message
+	Username  string            `json:"username"`
+	Channel   map[string]string `json:"channel"`
+	Permalink string            `json:"permalink"`
+}
+
+// searchMessages serves messages matching the query, newest first. Of Slack's modifiers, only in:#channel and
+// from:@user are supported. Every other word must be in the message text, ignoring case.
+func (s *Server) searchMessages(w http.ResponseWriter, params url.Values) {
+	var in, from string
+	var words []string
+	for _, word := range strings.Fields(params.Get("query")) {
+		switch {
+		case strings.HasPrefix(word, "in:"):
+			in = strings.TrimPrefix(strings.TrimPrefix(word, "in:"), "#")
+		case strings.HasPrefix(word, "from:"):
+			from = strings.TrimPrefix(strings.TrimPrefix(word, "from:"), "@")
+		default:
+			words = append(words, strings.ToLower(word))
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	userNames := map[string]string{}
+	for _, u := range s.users {
+		userNames[u.Id] = u.Name
+	}
+	matches := []searchMatch{}
+	for _, c := range s.channels {
+		if in != "" && c.Name != in && c.Id != in {
+			continue
+		}
+		msgs := s.messages[c.Id]
+	messages:
+		for i := len(msgs) - 1; i >= 0; i-- {
+			m := msgs[i]
+			if from != "" && userNames[m.User] != from && m.User != from {
+				continue
+			}
+			for _, word := range words {
+				if !strings.Contains(strings.ToLower(m.Text), word) {
+					continue messages
+				}
+			}
+			matches = append(matches, searchMatch{
+				Message:   m,
+				Username:  userNames[m.User],
+				Channel:   map[string]string{"id": c.Id, "name": c.Name},
+				Permalink: "https://slacktest.slack.com/archives/" + c.Id + "/p" + strings.Replace(m.Ts, ".", "", 1),
+			})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return tsLess(matches[j].Ts, matches[i].Ts) }) // newest first, across channels
+	total := len(matches)
+	start, end, _ := s.page(total, url.Values{"limit": {params.Get("count")}})
+	WriteJSON(w, map[string]interface{}{
+		"ok":    true,
+		"query": params.Get("query"),
+		"messages": map[string]interface{}{
+			"total":   total,
+			"matches": matches[start:end],
+			"paging":  map[string]int{"count": end - start, "total": total, "page": 1, "pages": 1},
+		},
+	})
+}
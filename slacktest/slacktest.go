@@ -0,0 +1,1117 @@
+// Package slacktest provides a fake Slack server, built on httptest, so the
+// slackterm managers and RTM loop can be tested without talking to slack.com.
+//
+// The fake serves a small subset of the Slack Web API: the conversations.*
+// methods, including join, leave, create, archive, setTopic, setPurpose,
+// invite and kick, users.list, users.info, users.setPresence,
+// users.profile.set, auth.test, chat.update, chat.delete, reactions.add and
+// reactions.remove, the external file upload flow, search.messages,
+// pins.list, pins.add and pins.remove, chat.postMessage, rtm.start,
+// rtm.connect, and apps.connections.open. It also serves an RTM websocket,
+// which sends scripted events to connected clients, acknowledges their sent
+// messages with reply_to, or fails, echoes or ignores them if told to, records
+// their typing events, answers pings with pongs, and answers presence_sub with
+// the users' presence, and a Socket Mode websocket, which sends the same
+// events in envelopes, and records their acks. Channel changes push the
+// channel events Slack sends, e.g. channel_joined.
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPageSize is the number of items served per page of list methods, if the request has no limit, as Slack does.
+const DefaultPageSize = 100
+
+// DefaultMaxPageSize is the largest page served, regardless of the requested limit.
+const DefaultMaxPageSize = 1000
+
+// Channel is a conversation. Set one of IsChannel, IsGroup, IsMpim or IsIm;
+// a Channel with none set is served as a public channel.
+type Channel struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	Created    int64    `json:"created"`
+	Creator    string   `json:"creator"`
+	IsArchived bool     `json:"is_archived"`
+	IsMember   bool     `json:"is_member"`
+	IsChannel  bool     `json:"is_channel"`
+	IsGroup    bool     `json:"is_group"`
+	IsPrivate  bool     `json:"is_private"`
+	IsMpim     bool     `json:"is_mpim"`
+	IsIm       bool     `json:"is_im"`
+	User       string   `json:"user,omitempty"`
+	NumMembers int      `json:"num_members"`
+	Topic      Value    `json:"topic"`
+	Purpose    Value    `json:"purpose"`
+	Members    []string `json:"-"`
+}
+
+// Value is a channel's topic or purpose.
+type Value struct {
+	Value   string `json:"value"`
+	Creator string `json:"creator"`
+	LastSet int64  `json:"last_set"`
+}
+
+// conversationType returns the conversations.list type of the channel.
+func (c Channel) conversationType() string {
+	switch {
+	case c.IsIm:
+		return "im"
+	case c.IsMpim:
+		return "mpim"
+	case c.IsPrivate || c.IsGroup:
+		return "private_channel"
+	default:
+		return "public_channel"
+	}
+}
+
+type Message struct {
+	Type       string     `json:"type"`
+	Subtype    string     `json:"subtype,omitempty"`
+	Ts         string     `json:"ts"`
+	User       string     `json:"user"`
+	Text       string     `json:"text"`
+	ThreadTs   string     `json:"thread_ts,omitempty"`
+	ReplyCount int        `json:"reply_count,omitempty"` // set by the server when serving thread parents
+	Reactions  []Reaction `json:"reactions,omitempty"`
+	Edited     *Edited    `json:"edited,omitempty"`
+	Files      []File     `json:"files,omitempty"`
+	BotId      string     `json:"bot_id,omitempty"`
+	Username   string     `json:"username,omitempty"` // the name a bot_message was posted as
+	Icons      *Icons     `json:"icons,omitempty"`
+	PinnedTo   []string   `json:"pinned_to,omitempty"` // set by the server when the message is pinned
+}
+
+type Icons struct {
+	Emoji string `json:"emoji,omitempty"`
+}
+
+type File struct {
+	Id                 string `json:"id"`
+	Name               string `json:"name"`
+	Title              string `json:"title"`
+	Mimetype           string `json:"mimetype"`
+	Filetype           string `json:"filetype"`
+	Size               int64  `json:"size"`
+	User               string `json:"user"`
+	Mode               string `json:"mode"`
+	UrlPrivate         string `json:"url_private"`
+	UrlPrivateDownload string `json:"url_private_download"`
+	Permalink          string `json:"permalink"`
+}
+
+type Edited struct {
+	User string `json:"user"`
+	Ts   string `json:"ts"`
+}
+
+type Reaction struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// isReply returns whether the message is a thread reply, which isn't in the channel history unless it was broadcast.
+func (m Message) isReply() bool {
+	return m.ThreadTs != "" && m.ThreadTs != m.Ts
+}
+
+type User struct {
+	Id      string  `json:"id"`
+	Name    string  `json:"name"`
+	Deleted bool    `json:"deleted"`
+	Profile Profile `json:"profile"`
+}
+
+type Profile struct {
+	RealName         string `json:"real_name"`
+	StatusText       string `json:"status_text,omitempty"`
+	StatusEmoji      string `json:"status_emoji,omitempty"`
+	StatusExpiration int64  `json:"status_expiration,omitempty"`
+}
+
+// SentMessage is a message a client sent over the RTM websocket.
+type SentMessage struct {
+	Id             int      `json:"id"`
+	Type           string   `json:"type"`
+	ChannelId      string   `json:"channel"`
+	Text           string   `json:"text"`
+	ThreadTs       string   `json:"thread_ts"`
+	ReplyBroadcast bool     `json:"reply_broadcast"`
+	Ids            []string `json:"ids"` // the users subscribed to, if this is a presence_sub
+}
+
+// Server is a fake Slack server. Create it with NewServer, and point the
+// client's API base URL at ApiUrl().
+type Server struct {
+	Token       string
+	AppToken    string // the app-level token apps.connections.open accepts, xapp-test by default
+	Self        User
+	MaxPageSize int
+
+	server   *httptest.Server
+	handlers map[string]HandlerFunc // map[method]handler
+	mutex    sync.Mutex
+	channels []Channel
+	users    []User
+	messages map[string][]Message // map[channelId]messages, oldest first
+	conns    map[*websocket.Conn]struct{}
+	limited  map[string]rateLimit // map[method]limit
+	nextTs   int64
+	sent     chan SentMessage
+	files    map[string]*storedFile // map[fileId]file, including files whose upload isn't complete
+	pins     map[string][]string    // map[channelId]pinned message ts, in the order they were pinned
+	presence map[string]string      // map[userId]presence
+	rtmFails int                    // the number of rtm.start and rtm.connect requests still to fail
+	noPongs  bool                   // whether pings are ignored
+
+	sendFails   int    // the number of sent messages still to fail
+	sendError   string // the error msg of failed sent messages
+	echoSends   bool   // whether sent messages are echoed back as message events before they're acknowledged
+	ignoreSends bool   // whether sent messages are dropped without acknowledging them
+
+	sockets      map[*websocket.Conn]struct{} // the Socket Mode connections
+	nextEnvelope int                          // the id of the next Socket Mode envelope
+	unacked      map[string]struct{}          // the ids of the Socket Mode envelopes the client hasn't acked
+}
+
+// NewServer starts a fake Slack server, which accepts requests with the given token.
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:       token,
+		AppToken:    "xapp-test",
+		Self:        User{Id: "U0SELF", Name: "me"},
+		MaxPageSize: DefaultMaxPageSize,
+		handlers:    make(map[string]HandlerFunc),
+		messages:    make(map[string][]Message),
+		conns:       make(map[*websocket.Conn]struct{}),
+		sockets:     make(map[*websocket.Conn]struct{}),
+		unacked:     make(map[string]struct{}),
+		limited:     make(map[string]rateLimit),
+		nextTs:      1,
+		sent:        make(chan SentMessage, 100),
+		files:       make(map[string]*storedFile),
+		pins:        make(map[string][]string),
+		presence:    make(map[string]string),
+	}
+	s.Handle("conversations.list", s.conversationsList)
+	s.Handle("conversations.info", s.conversationsInfo)
+	s.Handle("conversations.history", s.conversationsHistory)
+	s.Handle("conversations.members", s.conversationsMembers)
+	s.Handle("conversations.replies", s.conversationsReplies)
+	s.Handle("conversations.join", s.conversationsJoin)
+	s.Handle("conversations.leave", s.conversationsLeave)
+	s.Handle("conversations.create", s.conversationsCreate)
+	s.Handle("conversations.archive", s.conversationsArchive)
+	s.Handle("conversations.setTopic", s.conversationsSetTopic)
+	s.Handle("conversations.setPurpose", s.conversationsSetPurpose)
+	s.Handle("conversations.invite", s.conversationsInvite)
+	s.Handle("conversations.kick", s.conversationsKick)
+	s.Handle("users.list", s.usersList)
+	s.Handle("users.info", s.usersInfo)
+	s.Handle("users.setPresence", s.usersSetPresence)
+	s.Handle("users.profile.set", s.usersProfileSet)
+	s.Handle("auth.test", s.authTest)
+	s.Handle("chat.postMessage", s.chatPostMessage)
+	s.Handle("chat.update", s.chatUpdate)
+	s.Handle("chat.delete", s.chatDelete)
+	s.Handle("reactions.add", s.reactionsAdd)
+	s.Handle("reactions.remove", s.reactionsRemove)
+	s.Handle("files.getUploadURLExternal", s.filesGetUploadUrlExternal)
+	s.Handle("files.completeUploadExternal", s.filesCompleteUploadExternal)
+	s.Handle("search.messages", s.searchMessages)
+	s.Handle("pins.list", s.pinsList)
+	s.Handle("pins.add", s.pinsAdd)
+	s.Handle("pins.remove", s.pinsRemove)
+	s.Handle("rtm.start", s.rtmStart)
+	s.Handle("rtm.connect", s.rtmConnect)
+	s.Handle("apps.connections.open", s.appsConnectionsOpen)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.api)
+	mux.HandleFunc("/upload/", s.upload)
+	mux.HandleFunc("/files/", s.download)
+	mux.Handle("/rtm", websocket.Handler(s.rtm))
+	mux.Handle("/socket-mode", websocket.Handler(s.socketMode))
+	s.server = httptest.NewServer(mux)
+	return s
+}
+
+// Close shuts down the server, and closes any RTM and Socket Mode connections.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	for ws := range s.conns {
+		ws.Close()
+	}
+	for ws := range s.sockets {
+		ws.Close()
+	}
+	s.mutex.Unlock()
+	s.server.Close()
+}
+
+// ApiUrl returns the base URL of the fake Slack Web API, ending in a slash.
+func (s *Server) ApiUrl() string {
+	return s.server.URL + "/api/"
+}
+
+// RtmUrl returns the websocket URL returned by rtm.start.
+func (s *Server) RtmUrl() string {
+	return "ws" + s.server.URL[len("http"):] + "/rtm"
+}
+
+// SocketModeUrl returns the websocket URL returned by apps.connections.open.
+func (s *Server) SocketModeUrl() string {
+	return "ws" + s.server.URL[len("http"):] + "/socket-mode"
+}
+
+// HandlerFunc serves an API method. params are the request's query and form params.
+type HandlerFunc func(w http.ResponseWriter, params url.Values)
+
+// Handle sets the handler for the given API method, e.g. "users.list". Handlers
+// are only called for requests with a valid token, in either an Authorization
+// Bearer header or a token param: Token, or AppToken for apps.connections.open. This may be used to replace the default
+// handlers, e.g. to return errors, or to add methods the fake doesn't serve.
+func (s *Server) Handle(method string, h HandlerFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[method] = h
+}
+
+// api serves every API method, checking the token and rate limits before calling the method's handler.
+func (s *Server) api(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[len("/api/"):]
+	s.mutex.Lock()
+	h, ok := s.handlers[method]
+	s.mutex.Unlock()
+	if !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token := r.Form.Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = auth[len("Bearer "):]
+	}
+	wantToken := s.Token
+	if method == "apps.connections.open" {
+		wantToken = s.AppToken
+	}
+	if token != wantToken {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_auth"})
+		return
+	}
+	if retryAfter, ok := s.takeRateLimit(method); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "ratelimited"})
+		return
+	}
+	h(w, r.Form)
+}
+
+type rateLimit struct {
+	requests   int
+	retryAfter int
+}
+
+// RateLimit makes the next n requests to method fail with 429 Too Many Requests, with the given Retry-After seconds.
+func (s *Server) RateLimit(method string, n int, retryAfterSeconds int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limited[method] = rateLimit{requests: n, retryAfter: retryAfterSeconds}
+}
+
+// takeRateLimit returns whether the request to method is rate limited, and the Retry-After seconds if so.
+func (s *Server) takeRateLimit(method string) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	limit, ok := s.limited[method]
+	if !ok || limit.requests <= 0 {
+		return 0, false
+	}
+	limit.requests--
+	s.limited[method] = limit
+	return limit.retryAfter, true
+}
+
+// WriteJSON writes v to w as a JSON response.
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("slacktest error writing response: " + err.Error())
+	}
+}
+
+func (s *Server) AddChannel(c Channel) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.channels = append(s.channels, c)
+}
+
+func (s *Server) AddUser(u User) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users = append(s.users, u)
+}
+
+// AddMessage adds a message to the history of the given channel. If the
+// message has no ts, the next ts is assigned. The message with its ts is returned.
+func (s *Server) AddMessage(channelId string, m Message) Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addMessage(channelId, m)
+}
+
+// addMessage adds a message to the channel history. The mutex must be held.
+func (s *Server) addMessage(channelId string, m Message) Message {
+	if m.Type == "" {
+		m.Type = "message"
+	}
+	if m.Ts == "" {
+		m.Ts = s.ts()
+	}
+	if m.isReply() {
+		s.markThreadParent(channelId, m.ThreadTs)
+	}
+	msgs := append(s.messages[channelId], m)
+	sort.SliceStable(msgs, func(i, j int) bool { return tsLess(msgs[i].Ts, msgs[j].Ts) })
+	s.messages[channelId] = msgs
+	return m
+}
+
+// markThreadParent sets the thread_ts of the message with the given ts, which just got its first reply. The mutex must be held.
+func (s *Server) markThreadParent(channelId, ts string) {
+	for i, m := range s.messages[channelId] {
+		if m.Ts == ts {
+			s.messages[channelId][i].ThreadTs = ts
+		}
+	}
+}
+
+// ts returns a new unique, increasing Slack timestamp. The mutex must be held.
+func (s *Server) ts() string {
+	ts := fmt.Sprintf("%d.%06d", 1400000000+s.nextTs, s.nextTs)
+	s.nextTs++
+	return ts
+}
+
+func tsLess(a, b string) bool {
+	af, _ := strconv.ParseFloat(a, 64)
+	bf, _ := strconv.ParseFloat(b, 64)
+	return af < bf
+}
+
+// Messages returns the history of the given channel, oldest first.
+func (s *Server) Messages(channelId string) []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Message(nil), s.messages[channelId]...)
+}
+
+// Sent returns a chan to which every message and typing event sent by a client over RTM, and every message posted
+// with chat.postMessage, is written.
+func (s *Server) Sent() <-chan SentMessage {
+	return s.sent
+}
+
+// Push sends the given event, which is encoded as JSON, to every connected RTM
+// client, and in an events_api envelope to every Socket Mode client.
+func (s *Server) Push(event interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ws := range s.conns {
+		if err := websocket.JSON.Send(ws, event); err != nil {
+			return err
+		}
+	}
+	for ws := range s.sockets {
+		s.nextEnvelope++
+		id := "E" + strconv.Itoa(s.nextEnvelope)
+		s.unacked[id] = struct{}{}
+		envelope := map[string]interface{}{
+			"envelope_id":              id,
+			"type":                     "events_api",
+			"accepts_response_payload": false,
+			"payload":                  map[string]interface{}{"type": "event_callback", "team_id": "T0TEAM", "event": event},
+		}
+		if err := websocket.JSON.Send(ws, envelope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PushMessage adds a message to the channel history, and pushes it to every
+// connected RTM client as a message event.
+func (s *Server) PushMessage(channelId string, m Message) (Message, error) {
+	m = s.AddMessage(channelId, m)
+	return m, s.Push(messageEvent(channelId, m))
+}
+
+// messageEvent returns the RTM message event of m.
+func messageEvent(channelId string, m Message) map[string]interface{} {
+	event := map[string]interface{}{}
+	b, _ := json.Marshal(m)
+	json.Unmarshal(b, &event)
+	event["channel"] = channelId
+	return event
+}
+
+// PushTyping pushes a user_typing event, as Slack sends every few seconds while
+// the user types a message in the channel, to every connected RTM client.
+func (s *Server) PushTyping(channelId, userId string) error {
+	return s.Push(map[string]interface{}{"type": "user_typing", "channel": channelId, "user": userId})
+}
+
+// NumConns returns the number of connected RTM and Socket Mode clients.
+func (s *Server) NumConns() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns) + len(s.sockets)
+}
+
+// Unacked returns the number of Socket Mode envelopes the clients haven't acked.
+func (s *Server) Unacked() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.unacked)
+}
+
+// page returns the bounds of the page of n items requested by the limit and
+// cursor params, and the cursor of the next page, which is empty if this is
+// the last page. The mutex must be held.
+func (s *Server) page(n int, params url.Values) (start int, end int, nextCursor string) {
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > s.MaxPageSize {
+		limit = s.MaxPageSize
+	}
+	if cursor := params.Get("cursor"); strings.HasPrefix(cursor, "next_") {
+		start, _ = strconv.Atoi(cursor[len("next_"):])
+	}
+	if start > n {
+		start = n
+	}
+	end = start + limit
+	if end >= n {
+		return start, n, ""
+	}
+	return start, end, "next_" + strconv.Itoa(end)
+}
+
+func metadata(nextCursor string) map[string]string {
+	return map[string]string{"next_cursor": nextCursor}
+}
+
+func (s *Server) findChannel(id string) (Channel, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.channels {
+		if c.Id == id {
+			return c, true
+		}
+	}
+	return Channel{}, false
+}
+
+// conversationsList serves the channels of the requested types, or public channels if no types are given.
+func (s *Server) conversationsList(w http.ResponseWriter, params url.Values) {
+	types := map[string]bool{}
+	for _, t := range strings.Split(params.Get("types"), ",") {
+		types[t] = true
+	}
+	if params.Get("types") == "" {
+		types["public_channel"] = true
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channels := []Channel{}
+	for _, c := range s.channels {
+		if types[c.conversationType()] {
+			channels = append(channels, c)
+		}
+	}
+	start, end, next := s.page(len(channels), params)
+	WriteJSON(w, map[string]interface{}{"ok": true, "channels": channels[start:end], "response_metadata": metadata(next)})
+}
+
+func (s *Server) conversationsMembers(w http.ResponseWriter, params url.Values) {
+	c, ok := s.findChannel(params.Get("channel"))
+	if !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	members := append([]string{}, c.Members...)
+	s.mutex.Lock()
+	start, end, next := s.page(len(members), params)
+	s.mutex.Unlock()
+	WriteJSON(w, map[string]interface{}{"ok": true, "members": members[start:end], "response_metadata": metadata(next)})
+}
+
+func (s *Server) conversationsInfo(w http.ResponseWriter, params url.Values) {
+	c, ok := s.findChannel(params.Get("channel"))
+	if !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": c})
+}
+
+// conversationsHistory serves messages newest first, between oldest and latest exclusive, a page at a time.
+func (s *Server) conversationsHistory(w http.ResponseWriter, params url.Values) {
+	if _, ok := s.findChannel(params.Get("channel")); !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	latest := params.Get("latest")
+	oldest := params.Get("oldest")
+
+	s.mutex.Lock()
+	all := s.messages[params.Get("channel")]
+	msgs := []Message{}
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if m.isReply() && m.Subtype != "thread_broadcast" {
+			continue
+		}
+		if m.ThreadTs == m.Ts {
+			m.ReplyCount = countReplies(all, m.Ts)
+		}
+		if latest != "" && !tsLess(m.Ts, latest) {
+			continue
+		}
+		if oldest != "" && !tsLess(oldest, m.Ts) {
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+	start, end, next := s.page(len(msgs), params)
+	s.mutex.Unlock()
+
+	resp := map[string]interface{}{"ok": true, "messages": msgs[start:end], "has_more": next != "", "response_metadata": metadata(next)}
+	if latest != "" {
+		resp["latest"] = latest
+	}
+	WriteJSON(w, resp)
+}
+
+func countReplies(msgs []Message, threadTs string) int {
+	n := 0
+	for _, m := range msgs {
+		if m.ThreadTs == threadTs && m.Ts != threadTs {
+			n++
+		}
+	}
+	return n
+}
+
+// conversationsReplies serves the thread parent with the given ts, followed by its replies, oldest first.
+func (s *Server) conversationsReplies(w http.ResponseWriter, params url.Values) {
+	if _, ok := s.findChannel(params.Get("channel")); !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	ts := params.Get("ts")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	all := s.messages[params.Get("channel")]
+	msgs := []Message{}
+	for _, m := range all {
+		if m.Ts == ts {
+			m.ThreadTs = ts
+			m.ReplyCount = countReplies(all, ts)
+			msgs = append([]Message{m}, msgs...)
+		} else if m.ThreadTs == ts {
+			msgs = append(msgs, m)
+		}
+	}
+	if len(msgs) == 0 || msgs[0].Ts != ts {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "thread_not_found"})
+		return
+	}
+	start, end, next := s.page(len(msgs), params)
+	WriteJSON(w, map[string]interface{}{"ok": true, "messages": msgs[start:end], "has_more": next != "", "response_metadata": metadata(next)})
+}
+
+func (s *Server) usersList(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	start, end, next := s.page(len(s.users), params)
+	WriteJSON(w, map[string]interface{}{"ok": true, "members": s.users[start:end], "response_metadata": metadata(next)})
+}
+
+func (s *Server) usersInfo(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, u := range s.users {
+		if u.Id == params.Get("user") {
+			WriteJSON(w, map[string]interface{}{"ok": true, "user": u})
+			return
+		}
+	}
+	WriteJSON(w, map[string]interface{}{"ok": false, "error": "user_not_found"})
+}
+
+func (s *Server) authTest(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	WriteJSON(w, map[string]interface{}{"ok": true, "url": "https://slacktest.slack.com/", "team": "slacktest", "user": s.Self.Name, "team_id": "T0TEAM", "user_id": s.Self.Id})
+}
+
+// chatUpdate replaces the text of one of the Self user's messages, and pushes a message_changed event.
+func (s *Server) chatUpdate(w http.ResponseWriter, params url.Values) {
+	channelId, ts := params.Get("channel"), params.Get("ts")
+	s.mutex.Lock()
+	i, errCode := s.findOwnMessage(channelId, ts, "cant_update_message")
+	if errCode != "" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	previous := s.messages[channelId][i]
+	s.messages[channelId][i].Text = params.Get("text")
+	s.messages[channelId][i].Edited = &Edited{User: s.Self.Id, Ts: s.ts()}
+	m := s.messages[channelId][i]
+	s.mutex.Unlock()
+
+	s.Push(map[string]interface{}{"type": "message", "subtype": "message_changed", "hidden": true, "channel": channelId, "ts": m.Edited.Ts, "message": m, "previous_message": previous})
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": channelId, "ts": ts, "text": m.Text})
+}
+
+// chatDelete deletes one of the Self user's messages, and pushes a message_deleted event.
+func (s *Server) chatDelete(w http.ResponseWriter, params url.Values) {
+	channelId, ts := params.Get("channel"), params.Get("ts")
+	s.mutex.Lock()
+	i, errCode := s.findOwnMessage(channelId, ts, "cant_delete_message")
+	if errCode != "" {
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	previous := s.messages[channelId][i]
+	s.messages[channelId] = append(s.messages[channelId][:i:i], s.messages[channelId][i+1:]...)
+	eventTs := s.ts()
+	s.mutex.Unlock()
+
+	s.Push(map[string]interface{}{"type": "message", "subtype": "message_deleted", "hidden": true, "channel": channelId, "ts": eventTs, "deleted_ts": ts, "previous_message": previous})
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": channelId, "ts": ts})
+}
+
+// findOwnMessage returns the index of the message with the given ts, or the Slack error code if it doesn't exist,
+// or notOwnCode if it isn't the Self user's. The mutex must be held.
+func (s *Server) findOwnMessage(channelId, ts, notOwnCode string) (int, string) {
+	for i, m := range s.messages[channelId] {
+		if m.Ts != ts {
+			continue
+		}
+		if m.User != s.Self.Id {
+			return 0, notOwnCode
+		}
+		return i, ""
+	}
+	return 0, "message_not_found"
+}
+
+// reactionsAdd adds the Self user's reaction to a message, and pushes a reaction_added event.
+func (s *Server) reactionsAdd(w http.ResponseWriter, params url.Values) {
+	s.changeReaction(w, params, true)
+}
+
+// reactionsRemove removes the Self user's reaction from a message, and pushes a reaction_removed event.
+func (s *Server) reactionsRemove(w http.ResponseWriter, params url.Values) {
+	s.changeReaction(w, params, false)
+}
+
+func (s *Server) changeReaction(w http.ResponseWriter, params url.Values, add bool) {
+	channelId, ts, name := params.Get("channel"), params.Get("timestamp"), params.Get("name")
+	if name == "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_name"})
+		return
+	}
+
+	s.mutex.Lock()
+	errCode := "message_not_found"
+	for i, m := range s.messages[channelId] {
+		if m.Ts != ts {
+			continue
+		}
+		if add {
+			s.messages[channelId][i].Reactions, errCode = addReaction(m.Reactions, name, s.Self.Id)
+		} else {
+			s.messages[channelId][i].Reactions, errCode = removeReaction(m.Reactions, name, s.Self.Id)
+		}
+		break
+	}
+	s.mutex.Unlock()
+	if errCode != "" {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+
+	eventType := "reaction_added"
+	if !add {
+		eventType = "reaction_removed"
+	}
+	s.Push(map[string]interface{}{
+		"type":     eventType,
+		"user":     s.Self.Id,
+		"reaction": name,
+		"item":     map[string]interface{}{"type": "message", "channel": channelId, "ts": ts},
+		"event_ts": ts,
+	})
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// addReaction returns a copy of reactions with the user's reaction added, or the Slack error code if the user already reacted.
+func addReaction(reactions []Reaction, name, userId string) ([]Reaction, string) {
+	reactions = append([]Reaction(nil), reactions...)
+	for i, r := range reactions {
+		if r.Name != name {
+			continue
+		}
+		for _, u := range r.Users {
+			if u == userId {
+				return reactions, "already_reacted"
+			}
+		}
+		reactions[i].Count++
+		reactions[i].Users = append(append([]string(nil), r.Users...), userId)
+		return reactions, ""
+	}
+	return append(reactions, Reaction{Name: name, Count: 1, Users: []string{userId}}), ""
+}
+
+// removeReaction returns a copy of reactions with the user's reaction removed, or the Slack error code if the user hadn't reacted.
+func removeReaction(reactions []Reaction, name, userId string) ([]Reaction, string) {
+	for i, r := range reactions {
+		if r.Name != name {
+			continue
+		}
+		for j, u := range r.Users {
+			if u != userId {
+				continue
+			}
+			newreactions := append([]Reaction(nil), reactions...)
+			if r.Count <= 1 {
+				return append(newreactions[:i], newreactions[i+1:]...), ""
+			}
+			newreactions[i].Count--
+			newreactions[i].Users = append(append([]string(nil), r.Users[:j]...), r.Users[j+1:]...)
+			return newreactions, ""
+		}
+	}
+	return reactions, "no_reaction"
+}
+
+// FailRtm makes the next n rtm.start, rtm.connect, and apps.connections.open requests fail with internal_error, as
+// if Slack were having an outage.
+func (s *Server) FailRtm(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rtmFails = n
+}
+
+// IgnorePings makes the RTM websocket stop, or resume, answering pings with pongs, and the Socket Mode websocket
+// stop, or resume, reading, so its websocket pings aren't answered, as if the connection were half-open.
+func (s *Server) IgnorePings(ignore bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.noPongs = ignore
+}
+
+// FailSends makes the next n messages sent on the RTM websocket or with
+// chat.postMessage fail, replying ok:false with the error msg, as Slack does
+// e.g. for messages which are too long.
+func (s *Server) FailSends(n int, msg string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendFails = n
+	s.sendError = msg
+}
+
+// EchoSends makes the RTM websocket send, or stop sending, each sent message
+// back as a message event, before acknowledging it.
+func (s *Server) EchoSends(echo bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.echoSends = echo
+}
+
+// IgnoreSends makes the RTM websocket drop, or resume handling, sent messages,
+// as if the connection died before they were received.
+func (s *Server) IgnoreSends(ignore bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ignoreSends = ignore
+}
+
+// DropConns closes every RTM and Socket Mode websocket, as if the network connection were lost.
+func (s *Server) DropConns() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ws := range s.conns {
+		ws.Close()
+	}
+	for ws := range s.sockets {
+		ws.Close()
+	}
+}
+
+// Disconnect sends a disconnect envelope with the reason, e.g. refresh_requested,
+// to every Socket Mode client, as Slack does before closing a connection.
+func (s *Server) Disconnect(reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for ws := range s.sockets {
+		if err := websocket.JSON.Send(ws, map[string]interface{}{"type": "disconnect", "reason": reason}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rtmConnect serves rtm.connect, which is rtm.start without the users and channels.
+func (s *Server) rtmConnect(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rtmFails > 0 {
+		s.rtmFails--
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "internal_error"})
+		return
+	}
+	WriteJSON(w, map[string]interface{}{
+		"ok":   true,
+		"url":  s.RtmUrl(),
+		"self": map[string]interface{}{"id": s.Self.Id, "name": s.Self.Name},
+		"team": map[string]interface{}{"id": "T0TEAM", "name": "slacktest", "domain": "slacktest"},
+	})
+}
+
+func (s *Server) rtmStart(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rtmFails > 0 {
+		s.rtmFails--
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "internal_error"})
+		return
+	}
+	WriteJSON(w, map[string]interface{}{
+		"ok":       true,
+		"url":      s.RtmUrl(),
+		"self":     map[string]interface{}{"id": s.Self.Id, "name": s.Self.Name},
+		"team":     map[string]interface{}{"id": "T0TEAM", "name": "slacktest", "domain": "slacktest"},
+		"users":    s.users,
+		"channels": s.channels,
+		"ims":      []interface{}{},
+	})
+}
+
+// rtm serves an RTM websocket connection. It sends hello, then acknowledges
+// every message the client sends, and adds it to the channel history, and
+// answers pings and presence_sub. Messages and typing events are written to
+// the Sent chan.
+func (s *Server) rtm(ws *websocket.Conn) {
+	s.mutex.Lock()
+	s.conns[ws] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, ws)
+		s.mutex.Unlock()
+		ws.Close()
+	}()
+
+	if err := websocket.JSON.Send(ws, map[string]string{"type": "hello"}); err != nil {
+		return
+	}
+	for {
+		var msg SentMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return
+		}
+		if msg.Type == "ping" {
+			s.mutex.Lock()
+			var err error
+			if !s.noPongs {
+				err = websocket.JSON.Send(ws, map[string]interface{}{"type": "pong", "reply_to": msg.Id})
+			}
+			s.mutex.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if msg.Type == "presence_sub" {
+			s.mutex.Lock()
+			err := s.presenceSub(ws, msg.Ids)
+			s.mutex.Unlock()
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if msg.Type != "message" && msg.Type != "typing" {
+			continue
+		}
+
+		if msg.Type == "message" {
+			s.mutex.Lock()
+			err := s.rtmSend(ws, msg)
+			s.mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+
+		select {
+		case s.sent <- msg:
+		default:
+			log.Printf("slacktest sent chan full, dropping %v\n", msg)
+		}
+	}
+}
+
+// rtmSend handles a message sent on the RTM websocket ws: it's added to the
+// channel history and acknowledged, unless it's made to fail or be ignored.
+// It must be called with s.mutex held.
+func (s *Server) rtmSend(ws *websocket.Conn, msg SentMessage) error {
+	if s.ignoreSends {
+		return nil
+	}
+	if s.sendFails > 0 {
+		s.sendFails--
+		return websocket.JSON.Send(ws, map[string]interface{}{"ok": false, "reply_to": msg.Id, "error": map[string]interface{}{"code": 2, "msg": s.sendError}})
+	}
+	m := Message{User: s.Self.Id, Text: msg.Text, ThreadTs: msg.ThreadTs}
+	if msg.ReplyBroadcast {
+		m.Subtype = "thread_broadcast"
+	}
+	m = s.addMessage(msg.ChannelId, m)
+	if s.echoSends {
+		event := map[string]interface{}{"type": "message", "channel": msg.ChannelId, "user": m.User, "text": m.Text, "ts": m.Ts}
+		if m.ThreadTs != "" {
+			event["thread_ts"] = m.ThreadTs
+		}
+		if m.Subtype != "" {
+			event["subtype"] = m.Subtype
+		}
+		if err := websocket.JSON.Send(ws, event); err != nil {
+			return err
+		}
+	}
+	return websocket.JSON.Send(ws, map[string]interface{}{"ok": true, "reply_to": msg.Id, "ts": m.Ts, "text": m.Text})
+}
+
+// appsConnectionsOpen serves apps.connections.open, which returns the URL of a new Socket Mode websocket.
+func (s *Server) appsConnectionsOpen(w http.ResponseWriter, params url.Values) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.rtmFails > 0 {
+		s.rtmFails--
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "internal_error"})
+		return
+	}
+	WriteJSON(w, map[string]interface{}{"ok": true, "url": s.SocketModeUrl()})
+}
+
+// socketModePoll is how often the Socket Mode websocket checks whether pings are ignored.
+const socketModePoll = 10 * time.Millisecond
+
+// socketMode serves a Socket Mode websocket connection. It sends hello, then
+// records the client's acks of the envelopes pushed to it.
+func (s *Server) socketMode(ws *websocket.Conn) {
+	s.mutex.Lock()
+	s.sockets[ws] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.sockets, ws)
+		s.mutex.Unlock()
+		ws.Close()
+	}()
+
+	hello := map[string]interface{}{"type": "hello", "num_connections": 1, "connection_info": map[string]string{"app_id": "A0APP"}}
+	if err := websocket.JSON.Send(ws, hello); err != nil {
+		return
+	}
+	for {
+		// websocket pings are answered as they're read, so nothing is read while pings are ignored. Reads time out,
+		// so IgnorePings takes effect while waiting to read.
+		s.mutex.Lock()
+		noPongs := s.noPongs
+		s.mutex.Unlock()
+		if noPongs {
+			time.Sleep(socketModePoll)
+			continue
+		}
+		var ack struct {
+			EnvelopeId string `json:"envelope_id"`
+		}
+		ws.SetReadDeadline(time.Now().Add(socketModePoll))
+		if err := websocket.JSON.Receive(ws, &ack); err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				continue
+			}
+			return
+		}
+		s.mutex.Lock()
+		delete(s.unacked, ack.EnvelopeId)
+		s.mutex.Unlock()
+	}
+}
+
+// chatPostMessage posts a message as the Self user, and pushes it as a message
+// event. It's written to the Sent chan.
+func (s *Server) chatPostMessage(w http.ResponseWriter, params url.Values) {
+	channelId := params.Get("channel")
+	if _, ok := s.findChannel(channelId); !ok {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "channel_not_found"})
+		return
+	}
+	msg := SentMessage{Type: "message", ChannelId: channelId, Text: params.Get("text"), ThreadTs: params.Get("thread_ts"), ReplyBroadcast: params.Get("reply_broadcast") == "true"}
+	s.mutex.Lock()
+	if s.sendFails > 0 {
+		s.sendFails--
+		errCode := s.sendError
+		s.mutex.Unlock()
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": errCode})
+		return
+	}
+	m := Message{User: s.Self.Id, Text: msg.Text, ThreadTs: msg.ThreadTs}
+	if msg.ReplyBroadcast {
+		m.Subtype = "thread_broadcast"
+	}
+	m = s.addMessage(channelId, m)
+	s.mutex.Unlock()
+
+	select {
+	case s.sent <- msg:
+	default:
+		log.Printf("slacktest sent chan full, dropping %v\n", msg)
+	}
+	s.Push(messageEvent(channelId, m))
+	WriteJSON(w, map[string]interface{}{"ok": true, "channel": channelId, "ts": m.Ts, "message": m})
+}
@@ -0,0 +1,83 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+)
+
+// SetPresence sets the user's presence, active or away, and pushes a presence_change event.
+func (s *Server) SetPresence(userId, presence string) error {
+	s.mutex.Lock()
+	s.presence[userId] = presence
+	s.mutex.Unlock()
+	return s.Push(map[string]interface{}{"type": "presence_change", "user": userId, "presence": presence})
+}
+
+// presenceOf returns the user's presence. Users whose presence wasn't set are away. The mutex must be held.
+func (s *Server) presenceOf(userId string) string {
+	if presence, ok := s.presence[userId]; ok {
+		return presence
+	}
+	return "away"
+}
+
+// presenceSub sends the current presence of the subscribed users to ws, as a batched presence_change event for each
+// presence, as Slack does. The mutex must be held.
+func (s *Server) presenceSub(ws *websocket.Conn, ids []string) error {
+	byPresence := map[string][]string{}
+	for _, id := range ids {
+		presence := s.presenceOf(id)
+		byPresence[presence] = append(byPresence[presence], id)
+	}
+	for presence, ids := range byPresence {
+		if err := websocket.JSON.Send(ws, map[string]interface{}{"type": "presence_change", "users": ids, "presence": presence}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usersSetPresence sets the Self user's presence. auto makes them active, as if they were using Slack.
+func (s *Server) usersSetPresence(w http.ResponseWriter, params url.Values) {
+	presence := params.Get("presence")
+	switch presence {
+	case "auto":
+		presence = "active"
+	case "away":
+	default:
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_presence"})
+		return
+	}
+	s.SetPresence(s.Self.Id, presence)
+	WriteJSON(w, map[string]interface{}{"ok": true})
+}
+
+// usersProfileSet sets the status fields of the Self user's profile, and pushes a user_change event. Other profile
+// fields are ignored.
+func (s *Server) usersProfileSet(w http.ResponseWriter, params url.Values) {
+	var profile Profile
+	if err := json.Unmarshal([]byte(params.Get("profile")), &profile); err != nil {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "invalid_profile"})
+		return
+	}
+	if len(profile.StatusText) > 100 {
+		WriteJSON(w, map[string]interface{}{"ok": false, "error": "too_long"})
+		return
+	}
+	s.mutex.Lock()
+	s.Self.Profile.StatusText = profile.StatusText
+	s.Self.Profile.StatusEmoji = profile.StatusEmoji
+	s.Self.Profile.StatusExpiration = profile.StatusExpiration
+	for i, u := range s.users {
+		if u.Id == s.Self.Id {
+			s.users[i] = s.Self
+		}
+	}
+	self := s.Self
+	s.mutex.Unlock()
+
+	s.Push(map[string]interface{}{"type": "user_change", "user": self})
+	WriteJSON(w, map[string]interface{}{"ok": true, "profile": self.Profile})
+}
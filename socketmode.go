@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/net/websocket"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// SlackConnectionsOpen is the response of apps.connections.open, the URL of a new Socket Mode websocket.
+type SlackConnectionsOpen struct {
+	Ok  bool   `json:"ok"`
+	Url string `json:"url"`
+}
+
+// slackConnectionsOpen calls apps.connections.open, which only accepts an app-level token.
+func (c *SlackClient) slackConnectionsOpen(ctx context.Context) (SlackConnectionsOpen, error) {
+	var open SlackConnectionsOpen
+	if err := c.apiPost(ctx, `apps.connections.open`, nil, &open); err != nil {
+		return SlackConnectionsOpen{}, err
+	}
+	return open, nil
+}
+
+// SocketModeEnvelope is a message received on a Socket Mode websocket. Envelopes with an id must be acked, or Slack
+// sends them again.
+type SocketModeEnvelope struct {
+	EnvelopeId string `json:"envelope_id"`
+	Type       string `json:"type"`   // hello, events_api, disconnect, slash_commands, or interactive
+	Reason     string `json:"reason"` // why Slack is closing the connection, if Type is disconnect
+	Payload    struct {
+		Event json.RawMessage `json:"event"` // the Events API event, if Type is events_api
+	} `json:"payload"`
+}
+
+type SocketModeAck struct {
+	EnvelopeId string `json:"envelope_id"`
+}
+
+// SocketModeTransport is the RealtimeTransport of Socket Mode. It connects with apps.connections.open, which takes
+// the app-level token (xapp-) of the app client, and receives the app's Events API events, which are the same as
+// RTM's, acking each envelope.
+//
+// Socket Mode only receives, so messages are sent with chat.postMessage by the web client, of the bot or user token,
+// and its response is received as the message's reply_to, as if RTM acked it. Typing and presence subscriptions
+// can't be sent, and are dropped. Pings are sent as websocket pings, and answered with a pong when anything is next
+// read from the websocket, usually Slack's websocket pong, so a half-open connection stops answering them.
+type SocketModeTransport struct {
+	app  *SlackClient
+	web  *SlackClient
+	conn *socketModeConn
+}
+
+// socketModeConn is a Socket Mode websocket. Its events are read from the websocket by receive, and written to
+// events, along with the replies of sent messages and pings. Receive returns the error which ends it, and done is
+// closed.
+type socketModeConn struct {
+	ctx    context.Context
+	ws     *websocket.Conn
+	events chan socketModeEvent
+	done   chan struct{}
+	mutex  sync.Mutex
+	pingId *int // the id of the ping sent, which isn't answered yet, if any
+}
+
+type socketModeEvent struct {
+	data []byte
+	err  error
+}
+
+func NewSocketModeTransport(app *SlackClient, web *SlackClient) *SocketModeTransport {
+	return &SocketModeTransport{app: app, web: web}
+}
+
+func (t *SocketModeTransport) Connect(ctx context.Context) error {
+	open, err := t.app.slackConnectionsOpen(ctx)
+	if err != nil {
+		return err
+	}
+	conn := &socketModeConn{ctx: ctx, events: make(chan socketModeEvent), done: make(chan struct{})}
+	ws, err := dialSlackWebsocket(ctx, t.app, open.Url, conn.read)
+	if err != nil {
+		return err
+	}
+	ws.PayloadType = websocket.PingFrame // so ping's Write sends a ping. Acks are sent with websocket.JSON, as text.
+	conn.ws = ws
+	if t.conn != nil {
+		t.conn.ws.Close()
+	}
+	t.conn = conn
+	go t.conn.receive()
+	return nil
+}
+
+func (t *SocketModeTransport) Receive() ([]byte, error) {
+	if t.conn == nil {
+		return nil, errNotConnected
+	}
+	select {
+	case ev := <-t.conn.events:
+		return ev.data, ev.err
+	case <-t.conn.done:
+		return nil, errors.New("Socket Mode connection closed")
+	}
+}
+
+func (t *SocketModeTransport) Send(event interface{}) error {
+	if t.conn == nil {
+		return errNotConnected
+	}
+	switch event := event.(type) {
+	case SlackRtmSendMessage:
+		go t.postMessage(t.conn, event)
+	case SlackRtmPing:
+		return t.conn.ping(event.Id)
+	}
+	return nil
+}
+
+func (t *SocketModeTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.ws.Close()
+}
+
+// postMessage sends the message with chat.postMessage, and puts its reply_to on the connection it was sent on. If the
+// connection is lost first, the reply is dropped, as RTM's would be.
+func (t *SocketModeTransport) postMessage(conn *socketModeConn, msg SlackRtmSendMessage) {
+	id := msg.Id
+	reply := SlackRtmReplytoMsg{Ok: true, ReplyTo: &id, Text: msg.Text}
+	ts, err := t.web.PostSlackMessage(conn.ctx, msg.ChannelId, msg.Text, msg.ThreadTs, msg.ReplyBroadcast)
+	if err != nil {
+		code := SlackErrorCode(err)
+		if code == "" {
+			code = err.Error()
+		}
+		reply = SlackRtmReplytoMsg{ReplyTo: &id, Error: &SlackRtmError{Msg: code}}
+	}
+	reply.Time = ts
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Println("error encoding Socket Mode reply: " + err.Error())
+		return
+	}
+	conn.put(socketModeEvent{data: data})
+}
+
+// ping sends a websocket ping, which is answered with a pong with the given id by the next read from the websocket.
+// Only the newest ping is answered, which answers the older ones too.
+func (c *socketModeConn) ping(id int) error {
+	c.mutex.Lock()
+	c.pingId = &id
+	c.mutex.Unlock()
+	_, err := c.ws.Write([]byte(strconv.Itoa(id)))
+	return err
+}
+
+// read is called whenever anything is read from the websocket, which shows the connection is alive, and answers the
+// ping sent, if any. It's called by receive's reads, so the pong is put in the background.
+func (c *socketModeConn) read() {
+	c.mutex.Lock()
+	id := c.pingId
+	c.pingId = nil
+	c.mutex.Unlock()
+	if id == nil {
+		return
+	}
+	pong, err := json.Marshal(SlackRtmPong{Type: "pong", ReplyTo: *id})
+	if err != nil {
+		log.Println("error encoding Socket Mode pong: " + err.Error())
+		return
+	}
+	go c.put(socketModeEvent{data: pong})
+}
+
+// put writes the event for Receive, unless the connection ended, or its ctx is done.
+func (c *socketModeConn) put(ev socketModeEvent) {
+	select {
+	case c.events <- ev:
+	case <-c.done:
+	case <-c.ctx.Done():
+	}
+}
+
+// receive reads envelopes from the websocket until it fails, or Slack disconnects, acking them, and putting their
+// events. The error is put last, and then done is closed.
+func (c *socketModeConn) receive() {
+	defer close(c.done)
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(c.ws, &data); err != nil {
+			c.put(socketModeEvent{err: err})
+			return
+		}
+		var envelope SocketModeEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			log.Println("socketModeConn error decoding envelope: " + err.Error())
+			continue
+		}
+		if envelope.EnvelopeId != "" {
+			if err := websocket.JSON.Send(c.ws, SocketModeAck{EnvelopeId: envelope.EnvelopeId}); err != nil {
+				c.put(socketModeEvent{err: err})
+				return
+			}
+		}
+		switch envelope.Type {
+		case `events_api`:
+			if len(envelope.Payload.Event) > 0 {
+				c.put(socketModeEvent{data: envelope.Payload.Event})
+			}
+		case `disconnect`:
+			// Slack is about to close the connection, e.g. to refresh it, and the app should reconnect
+			c.ws.Close()
+			c.put(socketModeEvent{err: errors.New("Slack closed the Socket Mode connection: " + envelope.Reason)})
+			return
+		}
+	}
+}
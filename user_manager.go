@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 )
 
 func slackUserIdMap(users []SlackUser) map[string]SlackUser {
@@ -23,36 +25,179 @@ func GetUserName(id string, getUserNameChan chan<- UserNameRequest) string {
 	return <-replyChan
 }
 
-// userManager manages user data, and returns it via channels
-// TODO(add terminate channel, to safely shut down the manager)
-func userManager(token string, getName <-chan UserNameRequest) {
-	userSlice, err := GetSlackUsers(token)
-	if err != nil {
-		log.Panicln(err) // TODO(fix to return error, not panic)
+type UserIdRequest struct {
+	Name  string
+	Reply chan<- string
+}
+
+// GetUserId returns the id of the user with the given name, or the empty string if there's none. Only users in
+// users.list, or already looked up, are found.
+func GetUserId(name string, getUserIdChan chan<- UserIdRequest) string {
+	replyChan := make(chan string)
+	getUserIdChan <- UserIdRequest{name, replyChan}
+	return <-replyChan
+}
+
+// UserPresence is a user's presence, PresenceActive or PresenceAway, from a presence_change event.
+type UserPresence struct {
+	Id       string
+	Presence string
+}
+
+// UserStatus is a user's presence and custom status, as shown beside their name.
+type UserStatus struct {
+	Presence string // PresenceActive or PresenceAway, or the empty string if it isn't known
+	SlackStatus
+}
+
+type UserStatusRequest struct {
+	Id    string
+	Reply chan<- UserStatus
+}
+
+// GetUserStatus returns the user's presence and status. Unknown users aren't looked up, and have the empty UserStatus.
+func GetUserStatus(id string, getUserStatusChan chan<- UserStatusRequest) UserStatus {
+	replyChan := make(chan UserStatus)
+	getUserStatusChan <- UserStatusRequest{id, replyChan}
+	return <-replyChan
+}
+
+// profileStatus returns the custom status in the profile, or the empty SlackStatus if it has expired.
+func profileStatus(profile SlackProfile, now time.Time) SlackStatus {
+	status := SlackStatus{Text: profile.StatusText, Emoji: profile.StatusEmoji}
+	if profile.StatusExpiration != 0 {
+		status.Expiration = time.Unix(profile.StatusExpiration, 0)
+		if !now.Before(status.Expiration) {
+			return SlackStatus{}
+		}
+	}
+	return status
+}
+
+// userLookup is the result of looking up a user not in the userManager's map.
+type userLookup struct {
+	Id   string
+	User SlackUser
+	Err  error
+}
+
+// userManager manages user data, and returns it via channels.
+// Users not in the map are looked up via users.info, without blocking other requests. Concurrent requests for the
+// same unknown user wait for a single lookup, whose result is cached.
+// Users and presences put by the RTM handler replace those stored. The manager returns when ctx is done.
+// If users.list fails, e.g. because it's still rate limited after retrying, the manager starts without users, and
+// lists them again in the background.
+func userManager(ctx context.Context, client *SlackClient, getName <-chan UserNameRequest, getId <-chan UserIdRequest, getStatus <-chan UserStatusRequest, put <-chan SlackUser, putPresence <-chan UserPresence) {
+	users := make(map[string]SlackUser)
+	listed := make(chan []SlackUser)
+	if userSlice, err := client.GetSlackUsers(ctx); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("userManager error getting users, retrying in the background: " + err.Error())
+		go listSlackUsers(ctx, client, listed)
+	} else {
+		users = slackUserIdMap(userSlice)
 	}
-	users := slackUserIdMap(userSlice)
-	users["me"] = SlackUser{Id: "me", Name: "me", Deleted: false} // TODO(get self user from API)
+	presences := make(map[string]string)        // map[id]presence
+	pending := make(map[string][]chan<- string) // map[id]replies waiting for the lookup
+	looked := make(chan userLookup)
 	for {
 		select {
-		case g := <-getName:
-			user, ok := users[g.Id]
-			if !ok {
-				user, err := GetSlackUser(token)
-				if err != nil {
-					// TODO(log or return error)
-					g.Reply <- ""
-					continue
+		case <-ctx.Done():
+			return
+		case userSlice := <-listed:
+			for _, user := range userSlice {
+				if _, ok := users[user.Id]; !ok { // users put or looked up since are newer
+					users[user.Id] = user
 				}
+			}
+		case g := <-getName:
+			if user, ok := users[g.Id]; ok {
 				g.Reply <- user.Name
 				continue
 			}
-			g.Reply <- user.Name
+			if replies, ok := pending[g.Id]; ok {
+				pending[g.Id] = append(replies, g.Reply)
+				continue
+			}
+			pending[g.Id] = []chan<- string{g.Reply}
+			go func(id string) {
+				user, err := client.GetSlackUser(ctx, id)
+				select {
+				case looked <- userLookup{Id: id, User: user, Err: err}:
+				case <-ctx.Done():
+				}
+			}(g.Id)
+		case g := <-getId:
+			id := ""
+			for _, user := range users {
+				if user.Name == g.Name && !user.Deleted {
+					id = user.Id
+					break
+				}
+			}
+			g.Reply <- id
+		case g := <-getStatus:
+			g.Reply <- UserStatus{Presence: presences[g.Id], SlackStatus: profileStatus(users[g.Id].Profile, time.Now())}
+		case user := <-put:
+			users[user.Id] = user
+		case p := <-putPresence:
+			presences[p.Id] = p.Presence
+		case l := <-looked:
+			if l.Err != nil {
+				log.Println("userManager error getting user " + l.Id + ": " + l.Err.Error())
+				if SlackErrorCode(l.Err) == "user_not_found" {
+					users[l.Id] = SlackUser{Id: l.Id} // cache, so unknown ids don't make a request every message
+				}
+			} else {
+				users[l.Id] = l.User
+			}
+			for _, reply := range pending[l.Id] {
+				reply <- l.User.Name
+			}
+			delete(pending, l.Id)
+		}
+	}
+}
+
+// listSlackUsers gets every user via users.list, retrying with backoff until it succeeds, Slack rejects the token, or
+// ctx is done, and sends them to listed.
+func listSlackUsers(ctx context.Context, client *SlackClient, listed chan<- []SlackUser) {
+	for attempt := 0; ; attempt++ {
+		wait := rtmBackoff(attempt)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		users, err := client.GetSlackUsers(ctx)
+		if err == nil {
+			select {
+			case listed <- users:
+			case <-ctx.Done():
+			}
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if isRtmAuthError(err) {
+			log.Println("listSlackUsers not retrying, the token was rejected: " + err.Error())
+			return
 		}
+		log.Println("listSlackUsers error getting users: " + err.Error())
 	}
 }
 
-func StartUserManager(token string) chan<- UserNameRequest {
+// StartUserManager starts the user manager, and returns chans to get user names, ids and statuses, and to put changed
+// users and presences.
+func StartUserManager(ctx context.Context, client *SlackClient) (chan<- UserNameRequest, chan<- UserIdRequest, chan<- UserStatusRequest, chan<- SlackUser, chan<- UserPresence) {
 	getChan := make(chan UserNameRequest)
-	go userManager(token, getChan)
-	return getChan
+	getIdChan := make(chan UserIdRequest)
+	getStatusChan := make(chan UserStatusRequest)
+	putChan := make(chan SlackUser)
+	putPresenceChan := make(chan UserPresence)
+	go userManager(ctx, client, getChan, getIdChan, getStatusChan, putChan, putPresenceChan)
+	return getChan, getIdChan, getStatusChan, putChan, putPresenceChan
 }
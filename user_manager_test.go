@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rob05c/slackterm/slacktest"
+)
+
+func TestGetSlackUser(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice", Profile: slacktest.Profile{RealName: "Alice A"}})
+	client := testClient(t, s)
+	user, err := client.GetSlackUser(context.Background(), "U1")
+	if err != nil || user.Id != "U1" || user.Name != "alice" || user.Profile.RealName != "Alice A" {
+		t.Fatalf("expected alice, got %+v %v", user, err)
+	}
+	if _, err := client.GetSlackUser(context.Background(), "U9"); SlackErrorCode(err) != "user_not_found" {
+		t.Fatalf("expected user_not_found, got %v", err)
+	}
+}
+
+func TestUserManagerLooksUpUnknownUsers(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	client := testClient(t, s)
+	var lookups int32
+	s.Handle("users.info", func(w http.ResponseWriter, params url.Values) {
+		atomic.AddInt32(&lookups, 1)
+		time.Sleep(50 * time.Millisecond) // so the requests below are concurrent
+		if params.Get("user") == "U9" {
+			slacktest.WriteJSON(w, map[string]interface{}{"ok": true, "user": slacktest.User{Id: "U9", Name: "guest"}})
+			return
+		}
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": false, "error": "user_not_found"})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getName, getId, _, _, _ := StartUserManager(ctx, client)
+
+	if name := GetUserName("U1", getName); name != "alice" || atomic.LoadInt32(&lookups) != 0 {
+		t.Fatalf("expected alice from users.list, got %q after %d lookups", name, lookups)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if name := GetUserName("U9", getName); name != "guest" {
+				t.Errorf("expected guest, got %q", name)
+			}
+		}()
+	}
+	// the manager answers others while the lookup is made
+	if name := GetUserName("U1", getName); name != "alice" {
+		t.Errorf("expected alice, got %q", name)
+	}
+	wg.Wait()
+	if name := GetUserName("U9", getName); name != "guest" || atomic.LoadInt32(&lookups) != 1 {
+		t.Fatalf("expected one cached lookup, got %d", lookups)
+	}
+	if id := GetUserId("guest", getId); id != "U9" {
+		t.Fatalf("expected the looked up user found by name, got %q", id)
+	}
+
+	// unknown users are cached too, so each message from them doesn't make a request
+	GetUserName("B1", getName)
+	GetUserName("B1", getName)
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Fatalf("expected the unknown user looked up once, got %d lookups", n-1)
+	}
+}
+
+func TestUserManagerListFails(t *testing.T) {
+	s := slacktest.NewServer("xoxp-test")
+	s.AddUser(slacktest.User{Id: "U1", Name: "alice"})
+	s.AddUser(slacktest.User{Id: "U2", Name: "bob"})
+	client := testClient(t, s)
+	var lists int32
+	s.Handle("users.list", func(w http.ResponseWriter, params url.Values) {
+		if atomic.AddInt32(&lists, 1) == 1 {
+			slacktest.WriteJSON(w, map[string]interface{}{"ok": false, "error": "internal_error"})
+			return
+		}
+		slacktest.WriteJSON(w, map[string]interface{}{"ok": true, "members": []slacktest.User{{Id: "U1", Name: "alice"}, {Id: "U2", Name: "bob"}}})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getName, getId, _, _, _ := StartUserManager(ctx, client)
+
+	// users are looked up until they're listed again
+	if name := GetUserName("U1", getName); name != "alice" {
+		t.Fatalf("expected alice looked up, got %q", name)
+	}
+	for deadline := time.Now().Add(5 * time.Second); GetUserId("bob", getId) != "U2"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the users to be listed again, after %d lists", atomic.LoadInt32(&lists))
+		}
+	}
+}